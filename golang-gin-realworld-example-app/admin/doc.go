@@ -0,0 +1,12 @@
+/*
+The admin module containing the API of the moderators and admins, mounted on /api/admin.
+
+routers.go: router binding and the moderation actions (bans, roles, deleting any article or comment)
+
+serializers.go: the users as the moderators see them, with their role and ban
+
+validators.go: the user search, ban and role forms
+
+stats.go: the statistics of the site
+*/
+package admin
@@ -0,0 +1,203 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/metrics"
+	"realworld-backend/users"
+)
+
+// Goes after AuthMiddleware(true), SessionRequired and RequireRole(users.RoleModerator),
+// each route checks the permission it needs.
+func AdminRegister(router *gin.RouterGroup) {
+	router.GET("/users", users.RequirePermission(users.PermissionViewUsers), UserList)
+	router.GET("/users/:username", users.RequirePermission(users.PermissionViewUsers), UserRetrieve)
+	router.POST("/users/:username/ban", users.RequirePermission(users.PermissionBanUsers), UserBan)
+	router.DELETE("/users/:username/ban", users.RequirePermission(users.PermissionBanUsers), UserUnban)
+	router.PUT("/users/:username/role", users.RequirePermission(users.PermissionManageRoles), UserRoleUpdate)
+	router.DELETE("/articles/:slug", users.RequirePermission(users.PermissionModerateContent), ArticleDelete)
+	router.DELETE("/comments/:id", users.RequirePermission(users.PermissionModerateContent), CommentDelete)
+	router.GET("/stats", users.RequirePermission(users.PermissionViewStats), StatsRetrieve)
+}
+
+func UserList(c *gin.Context) {
+	queryValidator := NewUserQueryValidator()
+	if err := queryValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModels, count, err := users.SearchUsers(c.Request.Context(), queryValidator.Query())
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := UsersSerializer{c, userModels}
+	c.JSON(http.StatusOK, gin.H{"users": serializer.Response(), "usersCount": count})
+}
+
+func UserRetrieve(c *gin.Context) {
+	userModel, ok := findUser(c)
+	if !ok {
+		return
+	}
+	serializer := UserSerializer{c, userModel}
+	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+}
+
+func UserBan(c *gin.Context) {
+	userModel, ok := findOutrankedUser(c)
+	if !ok {
+		return
+	}
+	banValidator := NewBanValidator()
+	if err := banValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := userModel.Ban(c.Request.Context(), banValidator.Ban.Reason); err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	moderated(c, "ban", "user_id", userModel.ID, "reason", userModel.BanReason)
+	serializer := UserSerializer{c, userModel}
+	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+}
+
+func UserUnban(c *gin.Context) {
+	userModel, ok := findOutrankedUser(c)
+	if !ok {
+		return
+	}
+	if err := userModel.Unban(c.Request.Context()); err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	moderated(c, "unban", "user_id", userModel.ID)
+	serializer := UserSerializer{c, userModel}
+	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+}
+
+// Nobody gives a role above their own.
+func UserRoleUpdate(c *gin.Context) {
+	userModel, ok := findOutrankedUser(c)
+	if !ok {
+		return
+	}
+	roleValidator := NewRoleValidator()
+	if err := roleValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	role := roleValidator.User.Role
+	if !myUserModel.HasRole(role) {
+		c.JSON(http.StatusForbidden, common.NewError("role", users.ErrNotPermitted))
+		return
+	}
+	previous := userModel.Role
+	if err := userModel.SetRole(c.Request.Context(), role); err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	moderated(c, "role", "user_id", userModel.ID, "from", previous, "to", role)
+	serializer := UserSerializer{c, userModel}
+	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+}
+
+func ArticleDelete(c *gin.Context) {
+	ctx := c.Request.Context()
+	articleModel, err := articles.FindOneArticle(ctx, &articles.ArticleModel{Slug: c.Param("slug")})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	if err := articles.DeleteArticleModel(ctx, &articles.ArticleModel{Slug: articleModel.Slug}); err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	moderated(c, "delete_article", "article_id", articleModel.ID, "slug", articleModel.Slug, "author_id", articleModel.AuthorID)
+	c.JSON(http.StatusOK, gin.H{"article": "Delete success"})
+}
+
+func CommentDelete(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		return
+	}
+	ctx := c.Request.Context()
+	var commentModel articles.CommentModel
+	err = common.GetDBContext(ctx).First(&commentModel, uint(id64)).Error
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		return
+	}
+	if err := articles.DeleteCommentModel(ctx, []uint{commentModel.ID}); err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	moderated(c, "delete_comment", "comment_id", commentModel.ID, "article_id", commentModel.ArticleID, "author_id", commentModel.AuthorID)
+	c.JSON(http.StatusOK, gin.H{"comment": "Delete success"})
+}
+
+func StatsRetrieve(c *gin.Context) {
+	stats, err := SiteStats(c.Request.Context())
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// The user of the :username, answering 404 when there is none.
+func findUser(c *gin.Context) (users.UserModel, bool) {
+	userModel, err := users.FindOneUser(c.Request.Context(), &users.UserModel{Username: c.Param("username")})
+	if gorm.IsRecordNotFoundError(err) {
+		c.JSON(http.StatusNotFound, common.NewError("user", errors.New("Invalid username")))
+		return userModel, false
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return userModel, false
+	}
+	return userModel, true
+}
+
+// The user of the :username when the requester outranks them, answering 403 otherwise.
+func findOutrankedUser(c *gin.Context) (users.UserModel, bool) {
+	userModel, ok := findUser(c)
+	if !ok {
+		return userModel, false
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if !myUserModel.Outranks(userModel) {
+		c.JSON(http.StatusForbidden, common.NewError("user", users.ErrOutranked))
+		return userModel, false
+	}
+	return userModel, true
+}
+
+// Log the moderation action with the moderator, and count it.
+func moderated(c *gin.Context, action string, args ...interface{}) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	args = append([]interface{}{"action", action, "moderator_id", myUserModel.ID}, args...)
+	common.Log(c).Info("moderation", args...)
+	metrics.ModerationActions.WithLabelValues(action).Inc()
+}
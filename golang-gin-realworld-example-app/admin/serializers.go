@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/users"
+)
+
+type UserSerializer struct {
+	C *gin.Context
+	users.UserModel
+}
+
+// A user with what only the moderators see.
+type UserResponse struct {
+	ID            uint       `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	Bio           string     `json:"bio"`
+	Image         *string    `json:"image"`
+	Role          string     `json:"role"`
+	EmailVerified bool       `json:"emailVerified"`
+	TwoFactor     bool       `json:"twoFactor"`
+	LockedUntil   *time.Time `json:"lockedUntil"`
+	BannedAt      *time.Time `json:"bannedAt"`
+	BanReason     string     `json:"banReason"`
+}
+
+func (self *UserSerializer) Response() UserResponse {
+	return UserResponse{
+		ID:            self.ID,
+		Username:      self.Username,
+		Email:         self.Email,
+		Bio:           self.Bio,
+		Image:         self.Image,
+		Role:          self.Role,
+		EmailVerified: self.EmailVerifiedAt != nil,
+		TwoFactor:     self.TwoFactorEnabled(),
+		LockedUntil:   self.LockedUntil,
+		BannedAt:      self.BannedAt,
+		BanReason:     self.BanReason,
+	}
+}
+
+type UsersSerializer struct {
+	C     *gin.Context
+	Users []users.UserModel
+}
+
+func (self *UsersSerializer) Response() []UserResponse {
+	response := []UserResponse{}
+	for _, userModel := range self.Users {
+		serializer := UserSerializer{self.C, userModel}
+		response = append(response, serializer.Response())
+	}
+	return response
+}
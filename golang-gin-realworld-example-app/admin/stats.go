@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"context"
+	"time"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The logins counted in the statistics, the recent ones only.
+const loginWindow = 24 * time.Hour
+
+type StatsResponse struct {
+	Users struct {
+		Total  int            `json:"total"`
+		Banned int            `json:"banned"`
+		ByRole map[string]int `json:"byRole"`
+	} `json:"users"`
+	Articles  int `json:"articles"`
+	Comments  int `json:"comments"`
+	Tags      int `json:"tags"`
+	Favorites int `json:"favorites"`
+	// Of the last loginWindow
+	Logins struct {
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+	} `json:"logins"`
+}
+
+// Count the users, the content and the recent logins of the site.
+func SiteStats(ctx context.Context) (StatsResponse, error) {
+	var stats StatsResponse
+	db := common.GetDBContext(ctx)
+	stats.Users.ByRole = map[string]int{}
+	for _, role := range users.Roles {
+		stats.Users.ByRole[role] = 0
+	}
+
+	rows, err := db.Model(&users.UserModel{}).Select("role, count(*)").Group("role").Rows()
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		var count int
+		if err := rows.Scan(&role, &count); err != nil {
+			return stats, err
+		}
+		stats.Users.ByRole[role] = count
+		stats.Users.Total += count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	since := time.Now().Add(-loginWindow)
+	count := func(into *int, model interface{}, where ...interface{}) {
+		if err != nil {
+			return
+		}
+		scope := db.Model(model)
+		if len(where) > 0 {
+			scope = scope.Where(where[0], where[1:]...)
+		}
+		err = scope.Count(into).Error
+	}
+	count(&stats.Users.Banned, &users.UserModel{}, "banned_at IS NOT NULL")
+	count(&stats.Articles, &articles.ArticleModel{})
+	count(&stats.Comments, &articles.CommentModel{})
+	count(&stats.Tags, &articles.TagModel{})
+	count(&stats.Favorites, &articles.FavoriteModel{})
+	count(&stats.Logins.Succeeded, &users.LoginAttemptModel{}, "success = ? AND created_at > ?", true, since)
+	count(&stats.Logins.Failed, &users.LoginAttemptModel{}, "success = ? AND created_at > ?", false, since)
+	return stats, err
+}
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+func newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(users.AuthMiddleware(true))
+	AdminRegister(r.Group("/admin", users.SessionRequired(), users.RequireRole(users.RoleModerator)))
+	return r
+}
+
+func request(r *gin.Engine, method, url, body string, id uint) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Token %v", common.GenToken(id)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func createUser(username, role string) users.UserModel {
+	userModel := users.UserModel{Username: username, Email: username + "@example.com", PasswordHash: "test-hash", Role: role}
+	test_db.Create(&userModel)
+	return userModel
+}
+
+func TestModeration(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	user := createUser("jake", users.RoleUser)
+	moderator := createUser("mod", users.RoleModerator)
+	admin := createUser("root", users.RoleAdmin)
+
+	asserts.Equal(http.StatusForbidden, request(r, "GET", "/admin/users", ``, user.ID).Code)
+	w := request(r, "GET", "/admin/users?q=jake", ``, moderator.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	var listed struct {
+		Users      []UserResponse `json:"users"`
+		UsersCount int            `json:"usersCount"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &listed))
+	if asserts.Equal(1, listed.UsersCount) {
+		asserts.Equal("jake@example.com", listed.Users[0].Email)
+	}
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "GET", "/admin/users?role=root", ``, moderator.ID).Code)
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "GET", "/admin/users?limit=1000", ``, moderator.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/admin/users/nobody", ``, moderator.ID).Code)
+
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "POST", "/admin/users/jake/ban", `{"ban":{}}`, moderator.ID).Code,
+		"A ban should have a reason")
+	w = request(r, "POST", "/admin/users/jake/ban", `{"ban":{"reason": "spam"}}`, moderator.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"banReason":"spam"`)
+	asserts.Equal(http.StatusForbidden, request(r, "POST", "/admin/users/root/ban", `{"ban":{"reason": "coup"}}`, moderator.ID).Code)
+	asserts.Equal(http.StatusForbidden, request(r, "POST", "/admin/users/mod/ban", `{"ban":{"reason": "self"}}`, moderator.ID).Code)
+	asserts.Equal(http.StatusOK, request(r, "DELETE", "/admin/users/jake/ban", ``, moderator.ID).Code)
+
+	asserts.Equal(http.StatusForbidden, request(r, "PUT", "/admin/users/jake/role", `{"user":{"role": "moderator"}}`, moderator.ID).Code,
+		"Only the admins manage the roles")
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "PUT", "/admin/users/jake/role", `{"user":{"role": "root"}}`, admin.ID).Code)
+	asserts.Equal(http.StatusOK, request(r, "PUT", "/admin/users/jake/role", `{"user":{"role": "moderator"}}`, admin.ID).Code)
+	promoted, _ := users.FindOneUser(context.Background(), &users.UserModel{Username: "jake"})
+	asserts.Equal(users.RoleModerator, promoted.Role)
+	asserts.Equal(http.StatusForbidden, request(r, "PUT", "/admin/users/root/role", `{"user":{"role": "user"}}`, admin.ID).Code,
+		"An admin can't demote themselves")
+}
+
+func TestContentModeration(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createUser("author", users.RoleUser)
+	moderator := createUser("moderator", users.RoleModerator)
+	admin := createUser("admin", users.RoleAdmin)
+	articleUser := articles.GetArticleUserModel(context.Background(), author)
+	article := articles.ArticleModel{Slug: "spam", Title: "Spam", Author: articleUser}
+	test_db.Create(&article)
+	comment := articles.CommentModel{ArticleID: article.ID, Author: articleUser, Body: "spam"}
+	test_db.Create(&comment)
+
+	asserts.Equal(http.StatusForbidden, request(r, "DELETE", "/admin/comments/"+fmt.Sprint(comment.ID), ``, author.ID).Code)
+	asserts.Equal(http.StatusOK, request(r, "DELETE", "/admin/comments/"+fmt.Sprint(comment.ID), ``, moderator.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "DELETE", "/admin/comments/"+fmt.Sprint(comment.ID), ``, moderator.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "DELETE", "/admin/comments/abc", ``, moderator.ID).Code)
+	asserts.Equal(http.StatusOK, request(r, "DELETE", "/admin/articles/spam", ``, moderator.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "DELETE", "/admin/articles/spam", ``, moderator.ID).Code)
+
+	test_db.Create(&users.LoginAttemptModel{Email: "author@example.com", Success: false})
+	asserts.Equal(http.StatusForbidden, request(r, "GET", "/admin/stats", ``, moderator.ID).Code)
+	w := request(r, "GET", "/admin/stats", ``, admin.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Stats StatsResponse `json:"stats"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	stats, err := SiteStats(context.Background())
+	asserts.NoError(err)
+	asserts.Equal(stats, response.Stats)
+	asserts.Equal(stats.Users.Total, stats.Users.ByRole[users.RoleUser]+stats.Users.ByRole[users.RoleModerator]+stats.Users.ByRole[users.RoleAdmin])
+	asserts.GreaterOrEqual(stats.Users.ByRole[users.RoleAdmin], 1)
+	asserts.Zero(stats.Articles, "The deleted articles shouldn't count")
+	asserts.Zero(stats.Comments)
+	asserts.GreaterOrEqual(stats.Logins.Failed, 1)
+}
+
+func TestMain(m *testing.M) {
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	test_db.AutoMigrate(&articles.ArticleModel{}, &articles.ArticleUserModel{}, &articles.TagModel{},
+		&articles.FavoriteModel{}, &articles.CommentModel{})
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
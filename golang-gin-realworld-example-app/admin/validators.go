@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The query of GET /api/admin/users, e.g. ?q=jake&role=user&banned=false&limit=20
+type UserQueryValidator struct {
+	Search string `form:"q" binding:"max=255"`
+	Role   string `form:"role" binding:"omitempty,oneof=user moderator admin"`
+	Banned *bool  `form:"banned"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int    `form:"offset" binding:"min=0"`
+}
+
+func (self *UserQueryValidator) Bind(c *gin.Context) error {
+	if err := common.Bind(c, self); err != nil {
+		return err
+	}
+	if self.Limit == 0 {
+		self.Limit = 20
+	}
+	return nil
+}
+
+func (self *UserQueryValidator) Query() users.UserQuery {
+	return users.UserQuery{
+		Search: self.Search,
+		Role:   self.Role,
+		Banned: self.Banned,
+		Limit:  self.Limit,
+		Offset: self.Offset,
+	}
+}
+
+func NewUserQueryValidator() UserQueryValidator {
+	return UserQueryValidator{}
+}
+
+type BanValidator struct {
+	Ban struct {
+		Reason string `form:"reason" json:"reason" binding:"required,max=255"`
+	} `json:"ban"`
+}
+
+func (self *BanValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewBanValidator() BanValidator {
+	return BanValidator{}
+}
+
+type RoleValidator struct {
+	User struct {
+		Role string `form:"role" json:"role" binding:"required,oneof=user moderator admin"`
+	} `json:"user"`
+}
+
+func (self *RoleValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewRoleValidator() RoleValidator {
+	return RoleValidator{}
+}
@@ -0,0 +1,149 @@
+package articles
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The unchanged lines shown around each change.
+const diffContext = 3
+
+// The largest LCS table computed, beyond it the diff replaces every line
+const maxDiffCells = 1 << 20
+
+type diffLine struct {
+	// ' ' kept, '-' removed, '+' added
+	kind byte
+	text string
+}
+
+// The lines of text without the empty one after the last newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// The edit from a to b keeping their longest common subsequence of lines. The table is
+// quadratic, texts with more than maxDiffCells pairs of lines are removed then added whole.
+func diffLines(a, b []string) []diffLine {
+	if len(a)*len(b) > maxDiffCells {
+		lines := make([]diffLine, 0, len(a)+len(b))
+		for _, line := range a {
+			lines = append(lines, diffLine{'-', line})
+		}
+		for _, line := range b {
+			lines = append(lines, diffLine{'+', line})
+		}
+		return lines
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
+
+// The unified diff from `from` to `to` as diff -u prints it, empty when they are the same.
+//
+//	unifiedDiff("revision 1", "revision 2", before, after)
+func unifiedDiff(fromName, toName, from, to string) string {
+	lines := diffLines(splitLines(from), splitLines(to))
+	// The line of each side before lines[k]
+	fromPos, toPos := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for k, line := range lines {
+		fromPos[k+1], toPos[k+1] = fromPos[k], toPos[k]
+		if line.kind != '+' {
+			fromPos[k+1]++
+		}
+		if line.kind != '-' {
+			toPos[k+1]++
+		}
+	}
+
+	var out strings.Builder
+	for start := 0; start < len(lines); {
+		first := start
+		for first < len(lines) && lines[first].kind == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		// A hunk goes on while the changes are at most two contexts apart
+		end := first
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].kind == ' ' {
+				run++
+			}
+			if run == len(lines) || run-end > 2*diffContext {
+				end = min(end+diffContext, run)
+				break
+			}
+			end = run
+		}
+		hunkStart := max(first-diffContext, start)
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(fromPos[hunkStart], fromPos[end]-fromPos[hunkStart]),
+			hunkRange(toPos[hunkStart], toPos[end]-toPos[hunkStart]))
+		for _, line := range lines[hunkStart:end] {
+			out.WriteByte(line.kind)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		start = end
+	}
+	return out.String()
+}
+
+// The range of a hunk header, starting after the `before` lines preceding the hunk.
+// An empty range names the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
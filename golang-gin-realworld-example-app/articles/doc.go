@@ -8,5 +8,19 @@ routers.go: router binding and core logic
 serializers.go: definition the schema of return data
 
 validators.go: definition the validator of form data
+
+slugs.go: the unique slugs of the titles and the history of the former ones, redirected to the current
+
+lifecycle.go: the draft, scheduled, published and archived statuses and the scheduler publishing on time
+
+revisions.go: the content of an article after each edit, restoring a former revision is a new edit
+
+diff.go: the line based unified diff between two revisions
+
+markdown.go: the sanitized HTML of the article and comment bodies, with a cache of the rendered revisions
+
+search.go: the SQLite FTS5 index of the articles and its ranked, highlighted search, needs -tags sqlite_fts5
+
+policy.go: who may edit or delete an article or comment, its author with the admin and moderator overrides
 */
 package articles
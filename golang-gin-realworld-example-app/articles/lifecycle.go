@@ -0,0 +1,96 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// The values of ArticleModel.Status, only the published articles are listed and seen by everyone.
+const (
+	ArticleDraft = "draft"
+	// Published by the scheduler at PublishedAt
+	ArticleScheduled = "scheduled"
+	ArticlePublished = "published"
+	// Taken down by its author, kept for them
+	ArticleArchived = "archived"
+)
+
+var (
+	ErrPublishedAtPast = errors.New("a scheduled article needs a publication time in the future")
+	ErrUnknownStatus   = errors.New("unknown article status")
+)
+
+func (article ArticleModel) IsPublished() bool {
+	return article.Status == ArticlePublished
+}
+
+// Move the article to status, scheduled at `at` for ArticleScheduled. A published article keeps
+// its first publication time, a draft has none. The times are stored in UTC: sqlite compares
+// them as text, which only orders times of the same offset.
+func (article *ArticleModel) setStatus(status string, at *time.Time, now time.Time) error {
+	now = now.UTC()
+	switch status {
+	case ArticleDraft:
+		article.PublishedAt = nil
+	case ArticleScheduled:
+		if at == nil || !at.After(now) {
+			return ErrPublishedAtPast
+		}
+		utc := at.UTC()
+		article.PublishedAt = &utc
+	case ArticlePublished:
+		if !article.IsPublished() || article.PublishedAt == nil {
+			article.PublishedAt = &now
+		}
+	case ArticleArchived:
+	default:
+		return ErrUnknownStatus
+	}
+	article.Status = status
+	return nil
+}
+
+// Save the article in status, see setStatus.
+//
+//	err := articleModel.SetStatus(ctx, ArticleScheduled, &publishAt)
+func (article *ArticleModel) SetStatus(ctx context.Context, status string, at *time.Time) error {
+	if err := article.setStatus(status, at, time.Now().UTC()); err != nil {
+		return err
+	}
+	return common.GetDBContext(ctx).Model(article).Updates(map[string]interface{}{
+		"status":       article.Status,
+		"published_at": article.PublishedAt,
+	}).Error
+}
+
+// Publish the scheduled articles whose time has come, returning how many.
+func PublishDue(ctx context.Context, now time.Time) (int64, error) {
+	result := common.GetDBContext(ctx).Model(&ArticleModel{}).
+		Where("status = ? AND published_at <= ?", ArticleScheduled, now.UTC()).
+		Updates(map[string]interface{}{"status": ArticlePublished})
+	return result.RowsAffected, result.Error
+}
+
+// Publish the scheduled articles every interval until the server stops.
+func StartScheduler(interval time.Duration) {
+	common.RunBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			published, err := PublishDue(ctx, time.Now())
+			if err != nil {
+				common.Logger.Error("scheduled articles not published", "error", err.Error())
+			} else if published > 0 {
+				common.Logger.Info("scheduled articles published", "count", published)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package articles
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+
+	"realworld-backend/metrics"
+)
+
+// How many rendered bodies are kept in memory, 0 renders every time. Set from articles.markdown_cache_size.
+var MarkdownCacheSize = 1024
+
+// CommonMark with the GFM tables, strikethrough, autolinks and task lists. The raw HTML of
+// the bodies is dropped by goldmark, the sanitizer is the second line of defense.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+var sanitizer = newSanitizer()
+
+func newSanitizer() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	// The language of the fenced code blocks, for the highlighters of the clients
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	// The disabled checkboxes of the task lists
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+	policy.RequireNoReferrerOnLinks(true)
+	return policy
+}
+
+// RenderMarkdown returns the sanitized HTML of a Markdown body.
+func RenderMarkdown(body string) string {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(body), &buf); err != nil {
+		// Only the writer can fail, a bytes.Buffer doesn't
+		return ""
+	}
+	return sanitizer.Sanitize(buf.String())
+}
+
+// The rendered bodies by the hash of their Markdown: every revision is rendered once,
+// the edits get a new entry and the old one ages out.
+type renderCache struct {
+	sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	recent  list.List
+}
+
+type renderEntry struct {
+	key  [sha256.Size]byte
+	html string
+}
+
+var bodyHTMLCache = renderCache{entries: map[[sha256.Size]byte]*list.Element{}}
+
+func (cache *renderCache) render(body string) string {
+	key := sha256.Sum256([]byte(body))
+	cache.Lock()
+	if element, ok := cache.entries[key]; ok {
+		cache.recent.MoveToFront(element)
+		cache.Unlock()
+		metrics.MarkdownRenders.WithLabelValues("hit").Inc()
+		return element.Value.(*renderEntry).html
+	}
+	cache.Unlock()
+
+	metrics.MarkdownRenders.WithLabelValues("miss").Inc()
+	html := RenderMarkdown(body)
+	cache.Lock()
+	defer cache.Unlock()
+	if _, ok := cache.entries[key]; !ok && MarkdownCacheSize > 0 {
+		cache.entries[key] = cache.recent.PushFront(&renderEntry{key, html})
+	}
+	for cache.recent.Len() > MarkdownCacheSize {
+		oldest := cache.recent.Back()
+		cache.recent.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*renderEntry).key)
+	}
+	return html
+}
+
+// The bodyHtml of the article and comment responses, only rendered when asked with ?bodyHtml=true.
+func bodyHTML(c *gin.Context, body string) *string {
+	if c.Request == nil {
+		return nil
+	}
+	if want, _ := strconv.ParseBool(c.Query("bodyHtml")); !want {
+		return nil
+	}
+	html := bodyHTMLCache.render(body)
+	return &html
+}
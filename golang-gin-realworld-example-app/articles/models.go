@@ -1,11 +1,13 @@
 package articles
 
 import (
+	"context"
 	_ "fmt"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
 	"realworld-backend/users"
 	"strconv"
+	"time"
 )
 
 type ArticleModel struct {
@@ -16,6 +18,10 @@ type ArticleModel struct {
 	Body        string `gorm:"size:2048"`
 	Author      ArticleUserModel
 	AuthorID    uint
+	// One of the Article* statuses, see lifecycle.go
+	Status string `gorm:"column:status;size:16;not null;default:'published'"`
+	// When it was published, or will be while scheduled
+	PublishedAt *time.Time     `gorm:"column:published_at"`
 	Tags        []TagModel     `gorm:"many2many:article_tags;"`
 	Comments    []CommentModel `gorm:"ForeignKey:ArticleID"`
 }
@@ -51,12 +57,12 @@ type CommentModel struct {
 	Body      string `gorm:"size:2048"`
 }
 
-func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
+func GetArticleUserModel(ctx context.Context, userModel users.UserModel) ArticleUserModel {
 	var articleUserModel ArticleUserModel
 	if userModel.ID == 0 {
 		return articleUserModel
 	}
-	db := common.GetDB()
+	db := common.GetDBContext(ctx)
 	db.Where(&ArticleUserModel{
 		UserModelID: userModel.ID,
 	}).FirstOrCreate(&articleUserModel)
@@ -64,8 +70,8 @@ func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
 	return articleUserModel
 }
 
-func (article ArticleModel) favoritesCount() uint {
-	db := common.GetDB()
+func (article ArticleModel) favoritesCount(ctx context.Context) uint {
+	db := common.GetDBContext(ctx)
 	var count uint
 	db.Model(&FavoriteModel{}).Where(FavoriteModel{
 		FavoriteID: article.ID,
@@ -73,8 +79,8 @@ func (article ArticleModel) favoritesCount() uint {
 	return count
 }
 
-func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
-	db := common.GetDB()
+func (article ArticleModel) isFavoriteBy(ctx context.Context, user ArticleUserModel) bool {
+	db := common.GetDBContext(ctx)
 	var favorite FavoriteModel
 	db.Where(FavoriteModel{
 		FavoriteID:   article.ID,
@@ -83,8 +89,8 @@ func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
 	return favorite.ID != 0
 }
 
-func (article ArticleModel) favoriteBy(user ArticleUserModel) error {
-	db := common.GetDB()
+func (article ArticleModel) favoriteBy(ctx context.Context, user ArticleUserModel) error {
+	db := common.GetDBContext(ctx)
 	var favorite FavoriteModel
 	err := db.FirstOrCreate(&favorite, &FavoriteModel{
 		FavoriteID:   article.ID,
@@ -93,8 +99,8 @@ func (article ArticleModel) favoriteBy(user ArticleUserModel) error {
 	return err
 }
 
-func (article ArticleModel) unFavoriteBy(user ArticleUserModel) error {
-	db := common.GetDB()
+func (article ArticleModel) unFavoriteBy(ctx context.Context, user ArticleUserModel) error {
+	db := common.GetDBContext(ctx)
 	err := db.Where(FavoriteModel{
 		FavoriteID:   article.ID,
 		FavoriteByID: user.ID,
@@ -102,45 +108,79 @@ func (article ArticleModel) unFavoriteBy(user ArticleUserModel) error {
 	return err
 }
 
-func SaveOne(data interface{}) error {
-	db := common.GetDB()
+func SaveOne(ctx context.Context, data interface{}) error {
+	db := common.GetDBContext(ctx)
 	err := db.Save(data).Error
 	return err
 }
 
-func FindOneArticle(condition interface{}) (ArticleModel, error) {
-	db := common.GetDB()
+func FindOneArticle(ctx context.Context, condition interface{}) (ArticleModel, error) {
+	db := common.GetDBContext(ctx)
 	var model ArticleModel
 	tx := db.Begin()
-	tx.Where(condition).First(&model)
-	tx.Model(&model).Related(&model.Author, "Author")
-	tx.Model(&model.Author).Related(&model.Author.UserModel)
-	tx.Model(&model).Related(&model.Tags, "Tags")
-	err := tx.Commit().Error
+	if err := tx.Where(condition).First(&model).Error; err != nil {
+		tx.Rollback()
+		return model, err
+	}
+	err := firstError(
+		tx.Model(&model).Related(&model.Author, "Author"),
+		tx.Model(&model.Author).Related(&model.Author.UserModel),
+		tx.Model(&model).Related(&model.Tags, "Tags"),
+	)
+	if err != nil {
+		tx.Rollback()
+		return model, err
+	}
+	err = tx.Commit().Error
 	return model, err
 }
 
-func (self *ArticleModel) getComments() error {
-	db := common.GetDB()
+// Keep the first error of a chain of gorm calls, a missing row only means an empty relation here.
+func firstError(results ...*gorm.DB) error {
+	for _, result := range results {
+		if result.Error != nil && !gorm.IsRecordNotFoundError(result.Error) {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// The comment of the article, gorm.ErrRecordNotFound for the comment of another one.
+func (article ArticleModel) findComment(ctx context.Context, id uint) (CommentModel, error) {
+	var comment CommentModel
+	err := common.GetDBContext(ctx).Where("id = ? AND article_id = ?", id, article.ID).First(&comment).Error
+	return comment, err
+}
+
+func (self *ArticleModel) getComments(ctx context.Context) error {
+	db := common.GetDBContext(ctx)
 	tx := db.Begin()
-	tx.Model(self).Related(&self.Comments, "Comments")
+	err := firstError(tx.Model(self).Related(&self.Comments, "Comments"))
 	for i, _ := range self.Comments {
-		tx.Model(&self.Comments[i]).Related(&self.Comments[i].Author, "Author")
-		tx.Model(&self.Comments[i].Author).Related(&self.Comments[i].Author.UserModel)
+		if err != nil {
+			break
+		}
+		err = firstError(
+			tx.Model(&self.Comments[i]).Related(&self.Comments[i].Author, "Author"),
+			tx.Model(&self.Comments[i].Author).Related(&self.Comments[i].Author.UserModel),
+		)
 	}
-	err := tx.Commit().Error
-	return err
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
-func getAllTags() ([]TagModel, error) {
-	db := common.GetDB()
+func getAllTags(ctx context.Context) ([]TagModel, error) {
+	db := common.GetDBContext(ctx)
 	var models []TagModel
 	err := db.Find(&models).Error
 	return models, err
 }
 
-func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleModel, int, error) {
-	db := common.GetDB()
+func FindManyArticle(ctx context.Context, tag, author, limit, offset, favorited string) ([]ArticleModel, int, error) {
+	db := common.GetDBContext(ctx)
 	var models []ArticleModel
 	var count int
 
@@ -154,56 +194,56 @@ func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleMod
 		limit_int = 20
 	}
 
+	// Every query goes through keep, the first database error is returned instead of a silently short list.
+	var dbErr error
+	keep := func(result *gorm.DB) {
+		if dbErr == nil {
+			dbErr = firstError(result)
+		}
+	}
+
 	tx := db.Begin()
+	// The other statuses are only listed to their author, see GetDrafts
+	query := tx.Model(&ArticleModel{}).Where("status = ?", ArticlePublished)
+	found := true
 	if tag != "" {
 		var tagModel TagModel
-		tx.Where(TagModel{Tag: tag}).First(&tagModel)
-		if tagModel.ID != 0 {
-			tx.Model(&tagModel).Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
-			count = tx.Model(&tagModel).Association("ArticleModels").Count()
-		}
+		keep(tx.Where(TagModel{Tag: tag}).First(&tagModel))
+		found = tagModel.ID != 0
+		query = query.Where("id IN (?)", tx.Table("article_tags").Select("article_model_id").Where("tag_model_id = ?", tagModel.ID).SubQuery())
 	} else if author != "" {
 		var userModel users.UserModel
-		tx.Where(users.UserModel{Username: author}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-
-		if articleUserModel.ID != 0 {
-			count = tx.Model(&articleUserModel).Association("ArticleModels").Count()
-			tx.Model(&articleUserModel).Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
-		}
+		keep(tx.Where(users.UserModel{Username: author}).First(&userModel))
+		articleUserModel := GetArticleUserModel(ctx, userModel)
+		found = articleUserModel.ID != 0
+		query = query.Where("author_id = ?", articleUserModel.ID)
 	} else if favorited != "" {
 		var userModel users.UserModel
-		tx.Where(users.UserModel{Username: favorited}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-		if articleUserModel.ID != 0 {
-			var favoriteModels []FavoriteModel
-			tx.Where(FavoriteModel{
-				FavoriteByID: articleUserModel.ID,
-			}).Offset(offset_int).Limit(limit_int).Find(&favoriteModels)
-
-			count = tx.Model(&articleUserModel).Association("FavoriteModels").Count()
-			for _, favorite := range favoriteModels {
-				var model ArticleModel
-				tx.Model(&favorite).Related(&model, "Favorite")
-				models = append(models, model)
-			}
-		}
-	} else {
-		db.Model(&models).Count(&count)
-		db.Offset(offset_int).Limit(limit_int).Find(&models)
+		keep(tx.Where(users.UserModel{Username: favorited}).First(&userModel))
+		articleUserModel := GetArticleUserModel(ctx, userModel)
+		found = articleUserModel.ID != 0
+		query = query.Where("id IN (?)", tx.Model(&FavoriteModel{}).Select("favorite_id").Where("favorite_by_id = ?", articleUserModel.ID).SubQuery())
+	}
+	if found {
+		keep(query.Count(&count))
+		keep(query.Order("published_at desc, id desc").Offset(offset_int).Limit(limit_int).Find(&models))
 	}
 
 	for i, _ := range models {
-		tx.Model(&models[i]).Related(&models[i].Author, "Author")
-		tx.Model(&models[i].Author).Related(&models[i].Author.UserModel)
-		tx.Model(&models[i]).Related(&models[i].Tags, "Tags")
+		keep(tx.Model(&models[i]).Related(&models[i].Author, "Author"))
+		keep(tx.Model(&models[i].Author).Related(&models[i].Author.UserModel))
+		keep(tx.Model(&models[i]).Related(&models[i].Tags, "Tags"))
+	}
+	if dbErr != nil {
+		tx.Rollback()
+		return models, count, dbErr
 	}
 	err = tx.Commit().Error
 	return models, count, err
 }
 
-func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleModel, int, error) {
-	db := common.GetDB()
+func (self *ArticleUserModel) GetArticleFeed(ctx context.Context, limit, offset string) ([]ArticleModel, int, error) {
+	db := common.GetDBContext(ctx)
 	var models []ArticleModel
 	var count int
 
@@ -217,53 +257,116 @@ func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleMod
 	}
 
 	tx := db.Begin()
-	followings := self.UserModel.GetFollowings()
+	followings := self.UserModel.GetFollowings(ctx)
 	var articleUserModels []uint
 	for _, following := range followings {
-		articleUserModel := GetArticleUserModel(following)
+		articleUserModel := GetArticleUserModel(ctx, following)
 		articleUserModels = append(articleUserModels, articleUserModel.ID)
 	}
 
-	tx.Where("author_id in (?)", articleUserModels).Order("updated_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
+	err = firstError(tx.Where("author_id in (?) AND status = ?", articleUserModels, ArticlePublished).Order("published_at desc").Offset(offset_int).Limit(limit_int).Find(&models))
+
+	for i, _ := range models {
+		if err != nil {
+			break
+		}
+		err = firstError(
+			tx.Model(&models[i]).Related(&models[i].Author, "Author"),
+			tx.Model(&models[i].Author).Related(&models[i].Author.UserModel),
+			tx.Model(&models[i]).Related(&models[i].Tags, "Tags"),
+		)
+	}
+	if err != nil {
+		tx.Rollback()
+		return models, count, err
+	}
+	err = tx.Commit().Error
+	return models, count, err
+}
+
+// The articles of the author not published: drafts, scheduled and archived, last edited first.
+func (self *ArticleUserModel) GetDrafts(ctx context.Context, limit, offset string) ([]ArticleModel, int, error) {
+	db := common.GetDBContext(ctx)
+	var models []ArticleModel
+	var count int
 
+	offset_int, err := strconv.Atoi(offset)
+	if err != nil {
+		offset_int = 0
+	}
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil {
+		limit_int = 20
+	}
+
+	tx := db.Begin()
+	query := tx.Model(&ArticleModel{}).Where("author_id = ? AND status <> ?", self.ID, ArticlePublished)
+	err = firstError(
+		query.Count(&count),
+		query.Order("updated_at desc").Offset(offset_int).Limit(limit_int).Find(&models),
+	)
 	for i, _ := range models {
-		tx.Model(&models[i]).Related(&models[i].Author, "Author")
-		tx.Model(&models[i].Author).Related(&models[i].Author.UserModel)
-		tx.Model(&models[i]).Related(&models[i].Tags, "Tags")
+		if err != nil {
+			break
+		}
+		err = firstError(
+			tx.Model(&models[i]).Related(&models[i].Author, "Author"),
+			tx.Model(&models[i].Author).Related(&models[i].Author.UserModel),
+			tx.Model(&models[i]).Related(&models[i].Tags, "Tags"),
+		)
+	}
+	if err != nil {
+		tx.Rollback()
+		return models, count, err
 	}
 	err = tx.Commit().Error
 	return models, count, err
 }
 
-func (model *ArticleModel) setTags(tags []string) error {
-	db := common.GetDB()
+func (model *ArticleModel) setTags(ctx context.Context, tags []string) error {
+	tagList, err := findOrCreateTags(common.GetDBContext(ctx), tags)
+	if err != nil {
+		return err
+	}
+	model.Tags = tagList
+	return nil
+}
+
+// The tags named tags, the missing ones created with db, which may be a transaction.
+func findOrCreateTags(db *gorm.DB, tags []string) ([]TagModel, error) {
 	var tagList []TagModel
 	for _, tag := range tags {
 		var tagModel TagModel
 		err := db.FirstOrCreate(&tagModel, TagModel{Tag: tag}).Error
 		if err != nil {
-			return err
+			return nil, err
 		}
 		tagList = append(tagList, tagModel)
 	}
-	model.Tags = tagList
-	return nil
+	return tagList, nil
 }
 
-func (model *ArticleModel) Update(data interface{}) error {
-	db := common.GetDB()
+func (model *ArticleModel) Update(ctx context.Context, data interface{}) error {
+	db := common.GetDBContext(ctx)
 	err := db.Model(model).Update(data).Error
 	return err
 }
 
-func DeleteArticleModel(condition interface{}) error {
-	db := common.GetDB()
-	err := db.Where(condition).Delete(ArticleModel{}).Error
-	return err
+func DeleteArticleModel(ctx context.Context, condition interface{}) error {
+	return common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if err := tx.Model(&ArticleModel{}).Where(condition).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if err := tx.Where(condition).Delete(ArticleModel{}).Error; err != nil {
+			return err
+		}
+		return unindexArticles(tx, ids)
+	})
 }
 
-func DeleteCommentModel(condition interface{}) error {
-	db := common.GetDB()
+func DeleteCommentModel(ctx context.Context, condition interface{}) error {
+	db := common.GetDBContext(ctx)
 	err := db.Where(condition).Delete(CommentModel{}).Error
 	return err
 }
@@ -0,0 +1,64 @@
+package articles
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+var ErrNotAuthor = errors.New("only the author can do this")
+
+// Everyone reads a published article, only its author the others: a draft is private.
+func AuthorizeArticleRead(ctx context.Context, user users.UserModel, article ArticleModel) error {
+	if article.IsPublished() {
+		return nil
+	}
+	return authorize(ctx, user, article.AuthorID, false)
+}
+
+// The author edits the article, an admin may fix anyone's.
+func AuthorizeArticleUpdate(ctx context.Context, user users.UserModel, article ArticleModel) error {
+	return authorize(ctx, user, article.AuthorID, user.IsAdmin())
+}
+
+// The former versions of an article are its author's and the moderators', the text removed by
+// an edit isn't public anymore.
+func AuthorizeRevisionsRead(ctx context.Context, user users.UserModel, article ArticleModel) error {
+	return authorize(ctx, user, article.AuthorID, user.Can(users.PermissionModerateContent))
+}
+
+// The author deletes the article, the moderators anyone's.
+func AuthorizeArticleDelete(ctx context.Context, user users.UserModel, article ArticleModel) error {
+	return authorize(ctx, user, article.AuthorID, user.Can(users.PermissionModerateContent))
+}
+
+// The author deletes the comment, the moderators anyone's.
+func AuthorizeCommentDelete(ctx context.Context, user users.UserModel, comment CommentModel) error {
+	return authorize(ctx, user, comment.AuthorID, user.Can(users.PermissionModerateContent))
+}
+
+// ErrNotAuthor unless the user is the ArticleUserModel authorID or the override applies.
+func authorize(ctx context.Context, user users.UserModel, authorID uint, override bool) error {
+	if user.ID == 0 {
+		return ErrNotAuthor
+	}
+	if override {
+		return nil
+	}
+	var author ArticleUserModel
+	err := common.GetDBContext(ctx).Where(&ArticleUserModel{UserModelID: user.ID}).First(&author).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotAuthor
+	}
+	if err != nil {
+		return err
+	}
+	if author.ID != authorID {
+		return ErrNotAuthor
+	}
+	return nil
+}
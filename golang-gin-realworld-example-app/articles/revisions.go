@@ -0,0 +1,141 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// The content of an article after one of its edits, never changed. Restoring an old revision
+// records a new one with its content.
+type RevisionModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	ArticleID uint `gorm:"column:article_id;not null;unique_index:uix_article_revisions_article_number"`
+	// 1 for the article as created, then one more per edit
+	Number      int              `gorm:"column:number;not null;unique_index:uix_article_revisions_article_number"`
+	Editor      ArticleUserModel `gorm:"save_associations:false"`
+	EditorID    uint             `gorm:"column:editor_id;not null"`
+	Title       string           `gorm:"column:title;not null"`
+	Description string           `gorm:"column:description;size:2048"`
+	Body        string           `gorm:"column:body;size:2048"`
+	// The names of the tags, a JSON array
+	Tags string `gorm:"column:tags;size:2048"`
+	// The revision restored by this one
+	RestoredFrom *int `gorm:"column:restored_from"`
+}
+
+func (RevisionModel) TableName() string {
+	return "article_revisions"
+}
+
+func (r RevisionModel) TagList() []string {
+	var tags []string
+	json.Unmarshal([]byte(r.Tags), &tags)
+	return tags
+}
+
+// The revision as the text the diffs compare.
+func (r RevisionModel) document() string {
+	return fmt.Sprintf("Title: %s\nDescription: %s\nTags: %s\n\n%s\n", r.Title, r.Description, strings.Join(r.TagList(), ", "), r.Body)
+}
+
+// A unified diff from the revision `from` to r, empty when their contents are the same.
+// from is the empty article for Number 0.
+func (r RevisionModel) DiffFrom(from RevisionModel) string {
+	before := ""
+	if from.Number != 0 {
+		before = from.document()
+	}
+	return unifiedDiff(fmt.Sprintf("revision %d", from.Number), fmt.Sprintf("revision %d", r.Number), before, r.document())
+}
+
+// Record the article as stored now as its next revision.
+func recordRevision(tx *gorm.DB, articleID uint, editorID uint, restoredFrom *int) error {
+	var article ArticleModel
+	if err := tx.First(&article, articleID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&article).Related(&article.Tags, "Tags").Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	tags := []string{}
+	for _, tag := range article.Tags {
+		tags = append(tags, tag.Tag)
+	}
+	encoded, _ := json.Marshal(tags)
+	var last struct{ Number int }
+	if err := tx.Model(&RevisionModel{}).Select("COALESCE(MAX(number), 0) AS number").Where("article_id = ?", articleID).Scan(&last).Error; err != nil {
+		return err
+	}
+	return tx.Create(&RevisionModel{
+		ArticleID:    articleID,
+		Number:       last.Number + 1,
+		EditorID:     editorID,
+		Title:        article.Title,
+		Description:  article.Description,
+		Body:         article.Body,
+		Tags:         string(encoded),
+		RestoredFrom: restoredFrom,
+	}).Error
+}
+
+// The articles created before the revisions have none, their content becomes the first
+// revision before their first edit.
+func recordFirstRevision(tx *gorm.DB, article ArticleModel) error {
+	var count int
+	if err := tx.Model(&RevisionModel{}).Where("article_id = ?", article.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return recordRevision(tx, article.ID, article.AuthorID, nil)
+}
+
+// The revisions of the article, the last first, and how many it has.
+func (article ArticleModel) Revisions(ctx context.Context, limit, offset int) ([]RevisionModel, int, error) {
+	db := common.GetDBContext(ctx)
+	var models []RevisionModel
+	var count int
+	query := db.Model(&RevisionModel{}).Where("article_id = ?", article.ID)
+	err := firstError(
+		query.Count(&count),
+		query.Order("number desc").Offset(offset).Limit(limit).Find(&models),
+	)
+	for i := range models {
+		if err != nil {
+			break
+		}
+		err = firstError(
+			db.Model(&models[i]).Related(&models[i].Editor, "Editor"),
+			db.Model(&models[i].Editor).Related(&models[i].Editor.UserModel),
+		)
+	}
+	return models, count, err
+}
+
+// The revision `number` of the article, gorm.ErrRecordNotFound when it has none.
+func (article ArticleModel) FindRevision(ctx context.Context, number int) (RevisionModel, error) {
+	db := common.GetDBContext(ctx)
+	var model RevisionModel
+	if err := db.Where(&RevisionModel{ArticleID: article.ID, Number: number}).First(&model).Error; err != nil {
+		return model, err
+	}
+	err := firstError(
+		db.Model(&model).Related(&model.Editor, "Editor"),
+		db.Model(&model.Editor).Related(&model.Editor.UserModel),
+	)
+	return model, err
+}
+
+// Edit the article back to the content of the revision, recorded as a new revision by editor.
+func (model *ArticleModel) Restore(ctx context.Context, revision RevisionModel, editor ArticleUserModel) error {
+	return model.edit(ctx, ArticleModel{}, editor, &revision)
+}
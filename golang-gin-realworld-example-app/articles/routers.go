@@ -2,31 +2,44 @@ package articles
 
 import (
 	"errors"
+	"net/url"
+	"strings"
 	"realworld-backend/common"
+	"realworld-backend/metrics"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 func ArticlesRegister(router *gin.RouterGroup) {
-	router.POST("/", ArticleCreate)
-	router.PUT("/:slug", ArticleUpdate)
-	router.DELETE("/:slug", ArticleDelete)
-	router.POST("/:slug/favorite", ArticleFavorite)
-	router.DELETE("/:slug/favorite", ArticleUnfavorite)
-	router.POST("/:slug/comments", ArticleCommentCreate)
-	router.DELETE("/:slug/comments/:id", ArticleCommentDelete)
+	router.POST("/", users.RequireScope(users.ScopeArticlesWrite), users.VerifiedEmailRequired(), ArticleCreate)
+	router.PUT("/:slug", users.RequireScope(users.ScopeArticlesWrite), ArticleUpdate)
+	router.DELETE("/:slug", users.RequireScope(users.ScopeArticlesWrite), ArticleDelete)
+	router.POST("/:slug/publish", users.RequireScope(users.ScopeArticlesWrite), ArticlePublish)
+	router.POST("/:slug/unpublish", users.RequireScope(users.ScopeArticlesWrite), ArticleUnpublish)
+	router.POST("/:slug/archive", users.RequireScope(users.ScopeArticlesWrite), ArticleArchive)
+	router.GET("/:slug/revisions", users.RequireScope(users.ScopeArticlesRead), ArticleRevisionList)
+	router.GET("/:slug/revisions/:n", users.RequireScope(users.ScopeArticlesRead), ArticleRevisionRetrieve)
+	router.GET("/:slug/revisions/:n/diff", users.RequireScope(users.ScopeArticlesRead), ArticleRevisionDiff)
+	router.POST("/:slug/revisions/:n/restore", users.RequireScope(users.ScopeArticlesWrite), ArticleRevisionRestore)
+	router.POST("/:slug/favorite", users.RequireScope(users.ScopeArticlesWrite), ArticleFavorite)
+	router.DELETE("/:slug/favorite", users.RequireScope(users.ScopeArticlesWrite), ArticleUnfavorite)
+	router.POST("/:slug/comments", users.RequireScope(users.ScopeCommentsWrite), users.VerifiedEmailRequired(), ArticleCommentCreate)
+	router.DELETE("/:slug/comments/:id", users.RequireScope(users.ScopeCommentsWrite), ArticleCommentDelete)
 }
 
 func ArticlesAnonymousRegister(router *gin.RouterGroup) {
-	router.GET("/", ArticleList)
-	router.GET("/:slug", ArticleRetrieve)
-	router.GET("/:slug/comments", ArticleCommentList)
+	router.GET("/", users.RequireScope(users.ScopeArticlesRead), ArticleList)
+	router.GET("/:slug", users.RequireScope(users.ScopeArticlesRead), ArticleRetrieve)
+	router.GET("/by-id/:id", users.RequireScope(users.ScopeArticlesRead), ArticleRetrieveByID)
+	router.GET("/:slug/comments", users.RequireScope(users.ScopeCommentsRead), ArticleCommentList)
 }
 
 func TagsAnonymousRegister(router *gin.RouterGroup) {
-	router.GET("/", TagList)
+	router.GET("/", users.RequireScope(users.ScopeArticlesRead), TagList)
 }
 
 func ArticleCreate(c *gin.Context) {
@@ -36,11 +49,20 @@ func ArticleCreate(c *gin.Context) {
 		return
 	}
 	//fmt.Println(articleModelValidator.articleModel.Author.UserModel)
+	if status := articleModelValidator.Article.Status; status != "" {
+		err := articleModelValidator.articleModel.setStatus(status, articleModelValidator.Article.PublishedAt, time.Now())
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("publishedAt", err))
+			return
+		}
+	}
 
-	if err := SaveOne(&articleModelValidator.articleModel); err != nil {
+	if err := CreateArticle(c.Request.Context(), &articleModelValidator.articleModel); err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	metrics.ArticlesCreated.Inc()
 	serializer := ArticleSerializer{c, articleModelValidator.articleModel}
 	c.JSON(http.StatusCreated, gin.H{"article": serializer.Response()})
 }
@@ -52,8 +74,9 @@ func ArticleList(c *gin.Context) {
 	favorited := c.Query("favorited")
 	limit := c.Query("limit")
 	offset := c.Query("offset")
-	articleModels, modelCount, err := FindManyArticle(tag, author, limit, offset, favorited)
+	articleModels, modelCount, err := FindManyArticle(c.Request.Context(), tag, author, limit, offset, favorited)
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
 	}
@@ -69,9 +92,28 @@ func ArticleFeed(c *gin.Context) {
 		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
 		return
 	}
-	articleUserModel := GetArticleUserModel(myUserModel)
-	articleModels, modelCount, err := articleUserModel.GetArticleFeed(limit, offset)
+	articleUserModel := GetArticleUserModel(c.Request.Context(), myUserModel)
+	articleModels, modelCount, err := articleUserModel.GetArticleFeed(c.Request.Context(), limit, offset)
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
+}
+
+// The unpublished articles of the user, reached through GET /articles/drafts like the feed.
+func ArticleDrafts(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	articleUserModel := GetArticleUserModel(c.Request.Context(), myUserModel)
+	articleModels, modelCount, err := articleUserModel.GetDrafts(c.Request.Context(), c.Query("limit"), c.Query("offset"))
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
 	}
@@ -79,28 +121,135 @@ func ArticleFeed(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
 }
 
+// The ?limit= and ?offset= of a list, limit 20 by default and kept within 1..100, offset
+// at least 0. SQLite reads a negative LIMIT as no limit at all.
+func pagination(c *gin.Context) (int, int) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil {
+		offset = 0
+	}
+	return min(max(limit, 1), 100), max(offset, 0)
+}
+
+// The published articles matching ?q=, the best first, reached through GET /articles/search like the feed.
+func ArticleSearch(c *gin.Context) {
+	q := c.Query("q")
+	if len(q) > maxSearchLength {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("q", errors.New("is too long")))
+		return
+	}
+	limit, offset := pagination(c)
+	results, count, err := SearchArticles(c.Request.Context(), q, limit, offset)
+	switch {
+	case errors.Is(err, ErrEmptySearch):
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("q", err))
+		return
+	case errors.Is(err, ErrSearchUnavailable):
+		c.JSON(http.StatusNotImplemented, common.NewError("search", err))
+		return
+	case err != nil:
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := SearchResultsSerializer{c, results}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": count})
+}
+
 func ArticleRetrieve(c *gin.Context) {
 	slug := c.Param("slug")
 	if slug == "feed" {
 		ArticleFeed(c)
 		return
 	}
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	if slug == "drafts" {
+		ArticleDrafts(c)
+		return
+	}
+	if slug == "search" {
+		ArticleSearch(c)
+		return
+	}
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		if redirectRenamed(c, slug, err) {
+			return
+		}
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	if refuseHidden(c, "articles", articleModel) {
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+// The permalink of the article, it outlives the renames.
+func ArticleRetrieveByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid id")))
+		return
+	}
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Model: gorm.Model{ID: uint(id)}})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid id")))
+		return
+	}
+	if refuseHidden(c, "articles", articleModel) {
+		return
+	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
+// Answer a lookup of a former slug with a 301 to the same path under the current one.
+func redirectRenamed(c *gin.Context, slug string, err error) bool {
+	if !gorm.IsRecordNotFoundError(err) {
+		return false
+	}
+	current, err := FindRenamedSlug(c.Request.Context(), slug)
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			common.LogDBError(c, err)
+		}
+		return false
+	}
+	// The :slug segment of the route is the one to replace, whatever the other segments are
+	route := strings.Split(c.FullPath(), "/")
+	path := strings.Split(c.Request.URL.Path, "/")
+	if len(route) != len(path) {
+		return false
+	}
+	for i, segment := range route {
+		if segment == ":slug" {
+			path[i] = current
+		}
+	}
+	location := url.URL{Path: strings.Join(path, "/"), RawQuery: c.Request.URL.RawQuery}
+	c.Redirect(http.StatusMovedPermanently, location.String())
+	return true
+}
+
 func ArticleUpdate(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if refuseUnauthorized(c, "article", AuthorizeArticleUpdate(c.Request.Context(), myUserModel, articleModel)) {
+		return
+	}
 	articleModelValidator := NewArticleModelValidatorFillWith(articleModel)
 	if err := articleModelValidator.Bind(c); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
@@ -108,7 +257,11 @@ func ArticleUpdate(c *gin.Context) {
 	}
 
 	articleModelValidator.articleModel.ID = articleModel.ID
-	if err := articleModel.Update(articleModelValidator.articleModel); err != nil {
+	// An admin's edit keeps the author
+	articleModelValidator.articleModel.Author = articleModel.Author
+	editor := GetArticleUserModel(c.Request.Context(), myUserModel)
+	if err := articleModel.UpdateWithSlug(c.Request.Context(), articleModelValidator.articleModel, editor); err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -118,47 +271,125 @@ func ArticleUpdate(c *gin.Context) {
 
 func ArticleDelete(c *gin.Context) {
 	slug := c.Param("slug")
-	err := DeleteArticleModel(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if refuseUnauthorized(c, "article", AuthorizeArticleDelete(c.Request.Context(), myUserModel, articleModel)) {
+		return
+	}
+	err = DeleteArticleModel(c.Request.Context(), &ArticleModel{Slug: slug})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"article": "Delete success"})
 }
 
+// Publish the article now, or schedule it at a future publishedAt.
+func ArticlePublish(c *gin.Context) {
+	publishValidator := NewPublishValidator()
+	if err := publishValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if at := publishValidator.Article.PublishedAt; at != nil {
+		changeStatus(c, ArticleScheduled, at)
+		return
+	}
+	changeStatus(c, ArticlePublished, nil)
+}
+
+// Take the article back to a draft.
+func ArticleUnpublish(c *gin.Context) {
+	changeStatus(c, ArticleDraft, nil)
+}
+
+func ArticleArchive(c *gin.Context) {
+	changeStatus(c, ArticleArchived, nil)
+}
+
+// Move the :slug article to status, for its author or an admin.
+func changeStatus(c *gin.Context, status string, at *time.Time) {
+	ctx := c.Request.Context()
+	articleModel, err := FindOneArticle(ctx, &ArticleModel{Slug: c.Param("slug")})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if refuseUnauthorized(c, "article", AuthorizeArticleUpdate(ctx, myUserModel, articleModel)) {
+		return
+	}
+	err = articleModel.SetStatus(ctx, status, at)
+	if errors.Is(err, ErrPublishedAtPast) {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("publishedAt", err))
+		return
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
 func ArticleFavorite(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	if refuseHidden(c, "articles", articleModel) {
+		return
+	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.favoriteBy(GetArticleUserModel(myUserModel))
+	err = articleModel.favoriteBy(c.Request.Context(), GetArticleUserModel(c.Request.Context(), myUserModel))
+	common.LogDBError(c, err)
+	if err == nil {
+		metrics.Favorites.Inc()
+	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
 func ArticleUnfavorite(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	if refuseHidden(c, "articles", articleModel) {
+		return
+	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.unFavoriteBy(GetArticleUserModel(myUserModel))
+	err = articleModel.unFavoriteBy(c.Request.Context(), GetArticleUserModel(c.Request.Context(), myUserModel))
+	common.LogDBError(c, err)
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
 func ArticleCommentCreate(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
 		return
 	}
+	if refuseHidden(c, "comment", articleModel) {
+		return
+	}
 	commentModelValidator := NewCommentModelValidator()
 	if err := commentModelValidator.Bind(c); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
@@ -166,7 +397,8 @@ func ArticleCommentCreate(c *gin.Context) {
 	}
 	commentModelValidator.commentModel.Article = articleModel
 
-	if err := SaveOne(&commentModelValidator.commentModel); err != nil {
+	if err := SaveOne(c.Request.Context(), &commentModelValidator.commentModel); err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -181,23 +413,190 @@ func ArticleCommentDelete(c *gin.Context) {
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
 		return
 	}
-	err = DeleteCommentModel([]uint{id})
+	ctx := c.Request.Context()
+	articleModel, err := FindOneArticle(ctx, &ArticleModel{Slug: c.Param("slug")})
 	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
+		return
+	}
+	commentModel, err := articleModel.findComment(ctx, id)
+	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
 		return
 	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if refuseUnauthorized(c, "comment", AuthorizeCommentDelete(ctx, myUserModel, commentModel)) {
+		return
+	}
+	err = DeleteCommentModel(ctx, []uint{commentModel.ID})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"comment": "Delete success"})
 }
 
+// The revisions of the :slug article, the last first.
+func ArticleRevisionList(c *gin.Context) {
+	articleModel, ok := findRevisedArticle(c)
+	if !ok {
+		return
+	}
+	limit, offset := pagination(c)
+	revisionModels, count, err := articleModel.Revisions(c.Request.Context(), limit, offset)
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := RevisionsSerializer{c, revisionModels}
+	c.JSON(http.StatusOK, gin.H{"revisions": serializer.Response(), "revisionsCount": count})
+}
+
+func ArticleRevisionRetrieve(c *gin.Context) {
+	articleModel, ok := findRevisedArticle(c)
+	if !ok {
+		return
+	}
+	revisionModel, ok := findRevision(c, articleModel, c.Param("n"))
+	if !ok {
+		return
+	}
+	serializer := RevisionSerializer{c, revisionModel}
+	c.JSON(http.StatusOK, gin.H{"revision": serializer.Response()})
+}
+
+// The unified diff to the :n revision from the ?from one, the previous by default.
+func ArticleRevisionDiff(c *gin.Context) {
+	articleModel, ok := findRevisedArticle(c)
+	if !ok {
+		return
+	}
+	to, ok := findRevision(c, articleModel, c.Param("n"))
+	if !ok {
+		return
+	}
+	from := RevisionModel{Number: to.Number - 1}
+	if c.Query("from") != "" {
+		from, ok = findRevision(c, articleModel, c.Query("from"))
+	} else if from.Number > 0 {
+		from, ok = findRevision(c, articleModel, strconv.Itoa(from.Number))
+	}
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"diff": gin.H{"from": from.Number, "to": to.Number, "unified": to.DiffFrom(from)}})
+}
+
+// Edit the article back to the :n revision, for its author or an admin.
+func ArticleRevisionRestore(c *gin.Context) {
+	ctx := c.Request.Context()
+	articleModel, err := FindOneArticle(ctx, &ArticleModel{Slug: c.Param("slug")})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if refuseUnauthorized(c, "article", AuthorizeArticleUpdate(ctx, myUserModel, articleModel)) {
+		return
+	}
+	revisionModel, ok := findRevision(c, articleModel, c.Param("n"))
+	if !ok {
+		return
+	}
+	if err := articleModel.Restore(ctx, revisionModel, GetArticleUserModel(ctx, myUserModel)); err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	articleModel, err = FindOneArticle(ctx, &ArticleModel{Model: gorm.Model{ID: articleModel.ID}})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+// The :slug article when the user may read its revisions, answering 403 or 404 otherwise.
+func findRevisedArticle(c *gin.Context) (ArticleModel, bool) {
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: c.Param("slug")})
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return articleModel, false
+	}
+	if refuseHidden(c, "articles", articleModel) {
+		return articleModel, false
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	err = AuthorizeRevisionsRead(c.Request.Context(), myUserModel, articleModel)
+	return articleModel, !refuseUnauthorized(c, "revisions", err)
+}
+
+// The revision numbered n of the article, answering 404 when it has none.
+func findRevision(c *gin.Context, articleModel ArticleModel, n string) (RevisionModel, bool) {
+	number, err := strconv.Atoi(n)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("revision", errors.New("Invalid number")))
+		return RevisionModel{}, false
+	}
+	revisionModel, err := articleModel.FindRevision(c.Request.Context(), number)
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusNotFound, common.NewError("revision", errors.New("Invalid number")))
+		return revisionModel, false
+	}
+	return revisionModel, true
+}
+
+// Answer 404 for an article the user can't read, as if it didn't exist.
+func refuseHidden(c *gin.Context, key string, articleModel ArticleModel) bool {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	err := AuthorizeArticleRead(c.Request.Context(), myUserModel, articleModel)
+	if errors.Is(err, ErrNotAuthor) {
+		c.JSON(http.StatusNotFound, common.NewError(key, errors.New("Invalid slug")))
+		return true
+	}
+	return refuseUnauthorized(c, key, err)
+}
+
+// Answer a refused authorization, 403 for ErrNotAuthor.
+func refuseUnauthorized(c *gin.Context, key string, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrNotAuthor):
+		c.JSON(http.StatusForbidden, common.NewError(key, err))
+	default:
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	}
+	return true
+}
+
 func ArticleCommentList(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(c.Request.Context(), &ArticleModel{Slug: slug})
 	if err != nil {
+		if redirectRenamed(c, slug, err) {
+			return
+		}
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Invalid slug")))
 		return
 	}
-	err = articleModel.getComments()
+	if refuseHidden(c, "comments", articleModel) {
+		return
+	}
+	err = articleModel.getComments(c.Request.Context())
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Database error")))
 		return
 	}
@@ -205,8 +604,9 @@ func ArticleCommentList(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response()})
 }
 func TagList(c *gin.Context) {
-	tagModels, err := getAllTags()
+	tagModels, err := getAllTags(c.Request.Context())
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
 	}
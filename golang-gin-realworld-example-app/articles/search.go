@@ -0,0 +1,265 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+var (
+	// go-sqlite3 only has FTS5 with the sqlite_fts5 build tag, the index may also be missing
+	// when the migrations ran from a build without it.
+	ErrSearchUnavailable = errors.New("full-text search needs a binary built with -tags sqlite_fts5 and its index, see rebuild-search-index")
+	ErrEmptySearch       = errors.New("must contain a word")
+)
+
+// Around the matches in the highlights until they are escaped, see highlightHTML
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// Longer queries are refused, each term costs a lookup of the index
+const maxSearchLength = 256
+
+// bm25 weights of the title, description, body and tags columns
+const searchWeights = "10.0, 5.0, 1.0, 3.0"
+
+// Same as migration 0015
+const (
+	createSearchIndexSQL = `CREATE VIRTUAL TABLE IF NOT EXISTS "article_search" USING fts5("title","description","body","tags", tokenize = 'unicode61 remove_diacritics 2')`
+	fillSearchIndexSQL   = `INSERT INTO "article_search"("rowid","title","description","body","tags")
+		SELECT "id", "title", COALESCE("description", ''), COALESCE("body", ''),
+			COALESCE((SELECT group_concat("tag_models"."tag", ' ') FROM "article_tags" JOIN "tag_models" ON "tag_models"."id" = "article_tags"."tag_model_id" WHERE "article_tags"."article_model_id" = "article_models"."id"), '')
+		FROM "article_models" WHERE "deleted_at" IS NULL`
+)
+
+// Same as migration 0016, one row counting the writes the index missed
+const (
+	createSearchStateSQL = `CREATE TABLE IF NOT EXISTS "article_search_state" ("id" integer primary key,"missed_writes" integer NOT NULL DEFAULT 0)`
+	missSearchWriteSQL   = `INSERT INTO "article_search_state"("id","missed_writes") VALUES (1, 1)
+		ON CONFLICT("id") DO UPDATE SET "missed_writes" = "missed_writes" + 1`
+)
+
+// A published article matching a search, with its title and the best fragment of its columns
+// as HTML, the matches in <mark>.
+type SearchResult struct {
+	Article ArticleModel
+	// bm25, lower is better
+	Rank    float64
+	Title   string
+	Snippet string
+}
+
+func fts5Compiled(db *gorm.DB) bool {
+	var fts5 bool
+	db.Raw(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Row().Scan(&fts5)
+	return fts5
+}
+
+// Whether the index table exists, also seen by a binary without FTS5 that can't use it.
+func searchIndexExists(db *gorm.DB) bool {
+	var exists bool
+	db.Raw(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'article_search')`).Row().Scan(&exists)
+	return exists
+}
+
+// The writes the index missed since it was last rebuilt, 0 without the table of migration 0016.
+func missedSearchWrites(db *gorm.DB) int {
+	var missed int
+	db.Raw(`SELECT "missed_writes" FROM "article_search_state" WHERE "id" = 1`).Row().Scan(&missed)
+	return missed
+}
+
+func searchIndexReady(db *gorm.DB) bool {
+	var ready bool
+	db.Raw(`SELECT sqlite_compileoption_used('ENABLE_FTS5') AND EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'article_search')`).Row().Scan(&ready)
+	return ready
+}
+
+// The FTS5 query of q: every word must match, "quoted words" as a phrase and a word ending
+// with * as a prefix. Each term is quoted, so the FTS5 operators and column filters in q
+// are searched as words instead of failing the query.
+func searchQuery(q string) string {
+	var terms []string
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+		var term string
+		prefix := false
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				term, q = q[1:], ""
+			} else {
+				term, q = q[1:end+1], q[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(q, unicode.IsSpace)
+			if end < 0 {
+				end = len(q)
+			}
+			term, q = q[:end], q[end:]
+			term, prefix = strings.CutSuffix(term, "*")
+		}
+		// Punctuation alone has no token to match
+		if strings.IndexFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// The highlight of the index escaped for HTML, the matches in <mark>.
+func highlightHTML(highlight string) string {
+	escaped := html.EscapeString(highlight)
+	return strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>").Replace(escaped)
+}
+
+// The published articles matching q, the best first, and how many match.
+func SearchArticles(ctx context.Context, q string, limit, offset int) ([]SearchResult, int, error) {
+	match := searchQuery(q)
+	if match == "" {
+		return nil, 0, ErrEmptySearch
+	}
+	db := common.GetDBContext(ctx)
+	if !searchIndexReady(db) {
+		return nil, 0, ErrSearchUnavailable
+	}
+	from := ` FROM "article_search" JOIN "article_models" ON "article_models"."id" = "article_search"."rowid"
+		WHERE "article_search" MATCH ? AND "article_models"."status" = ? AND "article_models"."deleted_at" IS NULL`
+
+	tx := db.Begin()
+	var total struct{ Count int }
+	var hits []struct {
+		ID      uint
+		Score   float64
+		Title   string
+		Snippet string
+	}
+	err := firstError(
+		tx.Raw(`SELECT count(*) AS count`+from, match, ArticlePublished).Scan(&total),
+		tx.Raw(`SELECT "article_search"."rowid" AS id, bm25("article_search", `+searchWeights+`) AS score,
+			highlight("article_search", 0, ?, ?) AS title, snippet("article_search", -1, ?, ?, '…', 16) AS snippet`+from+`
+			ORDER BY score LIMIT ? OFFSET ?`,
+			matchStart, matchEnd, matchStart, matchEnd, match, ArticlePublished, limit, offset).Scan(&hits),
+	)
+	results := make([]SearchResult, len(hits))
+	for i, hit := range hits {
+		if err != nil {
+			break
+		}
+		article := &results[i].Article
+		err = firstError(
+			tx.First(article, hit.ID),
+			tx.Model(article).Related(&article.Author, "Author"),
+			tx.Model(&article.Author).Related(&article.Author.UserModel),
+			tx.Model(article).Related(&article.Tags, "Tags"),
+		)
+		results[i].Rank = hit.Score
+		results[i].Title = highlightHTML(hit.Title)
+		results[i].Snippet = highlightHTML(hit.Snippet)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, total.Count, err
+	}
+	err = tx.Commit().Error
+	return results, total.Count, err
+}
+
+// Put the article as stored now in the index, nothing to do without one.
+func indexArticle(tx *gorm.DB, articleID uint) error {
+	if !searchIndexReady(tx) {
+		return missSearchWrite(tx, []uint{articleID})
+	}
+	var article ArticleModel
+	if err := tx.First(&article, articleID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&article).Related(&article.Tags, "Tags").Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	tags := make([]string, 0, len(article.Tags))
+	for _, tag := range article.Tags {
+		tags = append(tags, tag.Tag)
+	}
+	if err := tx.Exec(`DELETE FROM "article_search" WHERE "rowid" = ?`, articleID).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`INSERT INTO "article_search"("rowid","title","description","body","tags") VALUES (?, ?, ?, ?, ?)`,
+		article.ID, article.Title, article.Description, article.Body, strings.Join(tags, " ")).Error
+}
+
+func unindexArticles(tx *gorm.DB, articleIDs []uint) error {
+	if len(articleIDs) == 0 {
+		return nil
+	}
+	if !searchIndexReady(tx) {
+		return missSearchWrite(tx, articleIDs)
+	}
+	return tx.Exec(`DELETE FROM "article_search" WHERE "rowid" IN (?)`, articleIDs).Error
+}
+
+// Count a write a binary without FTS5 couldn't make to an existing index, so EnsureSearchIndex
+// rebuilds it at the next startup of a build with FTS5. Nothing to do without an index.
+func missSearchWrite(tx *gorm.DB, articleIDs []uint) error {
+	if !searchIndexExists(tx) {
+		return nil
+	}
+	common.Logger.Warn("search index not updated by a build without sqlite_fts5, serve rebuilds it at the next start of a build with it",
+		"article_ids", articleIDs)
+	for _, statement := range []string{createSearchStateSQL, missSearchWriteSQL} {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create the index when it's missing and fill it again from the articles, returns how many
+// articles it holds.
+func RebuildSearchIndex(ctx context.Context) (int, error) {
+	db := common.GetDBContext(ctx)
+	if !fts5Compiled(db) {
+		return 0, ErrSearchUnavailable
+	}
+	var count int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range []string{
+			createSearchIndexSQL, `DELETE FROM "article_search"`, fillSearchIndexSQL,
+			createSearchStateSQL, `DELETE FROM "article_search_state"`,
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Table("article_search").Count(&count).Error
+	})
+	return count, err
+}
+
+// Rebuild the index when the binary has FTS5 but the database has no index, as left by
+// migrations run from a build without it, or an index that missed the writes of such a build.
+// Returns whether it did and how many articles it holds.
+func EnsureSearchIndex(ctx context.Context) (bool, int, error) {
+	db := common.GetDBContext(ctx)
+	if !fts5Compiled(db) || searchIndexReady(db) && missedSearchWrites(db) == 0 {
+		return false, 0, nil
+	}
+	count, err := RebuildSearchIndex(ctx)
+	return err == nil, count, err
+}
@@ -1,7 +1,7 @@
 package articles
 
 import (
-	"github.com/gosimple/slug"
+	"realworld-backend/tracing"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
 )
@@ -45,17 +45,20 @@ type ArticleSerializer struct {
 }
 
 type ArticleResponse struct {
-	ID             uint                  `json:"-"`
+	ID             uint                  `json:"id"`
 	Title          string                `json:"title"`
 	Slug           string                `json:"slug"`
 	Description    string                `json:"description"`
 	Body           string                `json:"body"`
+	BodyHTML       *string               `json:"bodyHtml,omitempty"`
 	CreatedAt      string                `json:"createdAt"`
 	UpdatedAt      string                `json:"updatedAt"`
 	Author         users.ProfileResponse `json:"author"`
 	Tags           []string              `json:"tagList"`
 	Favorite       bool                  `json:"favorited"`
 	FavoritesCount uint                  `json:"favoritesCount"`
+	Status         string                `json:"status"`
+	PublishedAt    *string               `json:"publishedAt"`
 }
 
 type ArticlesSerializer struct {
@@ -64,20 +67,28 @@ type ArticlesSerializer struct {
 }
 
 func (s *ArticleSerializer) Response() ArticleResponse {
+	ctx, end := tracing.StartGin(s.C, "ArticleSerializer.Response")
+	defer end()
 	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
 	authorSerializer := ArticleUserSerializer{s.C, s.Author}
 	response := ArticleResponse{
 		ID:          s.ID,
-		Slug:        slug.Make(s.Title),
+		Slug:        s.Slug,
 		Title:       s.Title,
 		Description: s.Description,
 		Body:        s.Body,
+		BodyHTML:    bodyHTML(s.C, s.Body),
 		CreatedAt:   s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		//UpdatedAt:      s.UpdatedAt.UTC().Format(time.RFC3339Nano),
 		UpdatedAt:      s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		Author:         authorSerializer.Response(),
-		Favorite:       s.isFavoriteBy(GetArticleUserModel(myUserModel)),
-		FavoritesCount: s.favoritesCount(),
+		Favorite:       s.isFavoriteBy(ctx, GetArticleUserModel(ctx, myUserModel)),
+		FavoritesCount: s.favoritesCount(ctx),
+		Status:         s.Status,
+	}
+	if s.PublishedAt != nil {
+		publishedAt := s.PublishedAt.UTC().Format("2006-01-02T15:04:05.999Z")
+		response.PublishedAt = &publishedAt
 	}
 	response.Tags = make([]string, 0)
 	for _, tag := range s.Tags {
@@ -88,6 +99,8 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 }
 
 func (s *ArticlesSerializer) Response() []ArticleResponse {
+	_, end := tracing.StartGin(s.C, "ArticlesSerializer.Response")
+	defer end()
 	response := []ArticleResponse{}
 	for _, article := range s.Articles {
 		serializer := ArticleSerializer{s.C, article}
@@ -96,6 +109,52 @@ func (s *ArticlesSerializer) Response() []ArticleResponse {
 	return response
 }
 
+type SearchResultSerializer struct {
+	C *gin.Context
+	SearchResult
+}
+
+type SearchResultsSerializer struct {
+	C       *gin.Context
+	Results []SearchResult
+}
+
+// The article with how it matched the search
+type SearchResultResponse struct {
+	ArticleResponse
+	Search SearchMatchResponse `json:"search"`
+}
+
+type SearchMatchResponse struct {
+	// Higher is better
+	Score   float64 `json:"score"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+}
+
+func (s *SearchResultSerializer) Response() SearchResultResponse {
+	articleSerializer := ArticleSerializer{s.C, s.Article}
+	return SearchResultResponse{
+		ArticleResponse: articleSerializer.Response(),
+		Search: SearchMatchResponse{
+			Score:   -s.Rank,
+			Title:   s.Title,
+			Snippet: s.Snippet,
+		},
+	}
+}
+
+func (s *SearchResultsSerializer) Response() []SearchResultResponse {
+	_, end := tracing.StartGin(s.C, "SearchResultsSerializer.Response")
+	defer end()
+	response := []SearchResultResponse{}
+	for _, result := range s.Results {
+		serializer := SearchResultSerializer{s.C, result}
+		response = append(response, serializer.Response())
+	}
+	return response
+}
+
 type CommentSerializer struct {
 	C *gin.Context
 	CommentModel
@@ -109,16 +168,20 @@ type CommentsSerializer struct {
 type CommentResponse struct {
 	ID        uint                  `json:"id"`
 	Body      string                `json:"body"`
+	BodyHTML  *string               `json:"bodyHtml,omitempty"`
 	CreatedAt string                `json:"createdAt"`
 	UpdatedAt string                `json:"updatedAt"`
 	Author    users.ProfileResponse `json:"author"`
 }
 
 func (s *CommentSerializer) Response() CommentResponse {
+	_, end := tracing.StartGin(s.C, "CommentSerializer.Response")
+	defer end()
 	authorSerializer := ArticleUserSerializer{s.C, s.Author}
 	response := CommentResponse{
 		ID:        s.ID,
 		Body:      s.Body,
+		BodyHTML:  bodyHTML(s.C, s.Body),
 		CreatedAt: s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		UpdatedAt: s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		Author:    authorSerializer.Response(),
@@ -127,6 +190,8 @@ func (s *CommentSerializer) Response() CommentResponse {
 }
 
 func (s *CommentsSerializer) Response() []CommentResponse {
+	_, end := tracing.StartGin(s.C, "CommentsSerializer.Response")
+	defer end()
 	response := []CommentResponse{}
 	for _, comment := range s.Comments {
 		serializer := CommentSerializer{s.C, comment}
@@ -134,3 +199,51 @@ func (s *CommentsSerializer) Response() []CommentResponse {
 	}
 	return response
 }
+
+type RevisionSerializer struct {
+	C *gin.Context
+	RevisionModel
+}
+
+type RevisionResponse struct {
+	Number       int                   `json:"number"`
+	Title        string                `json:"title"`
+	Description  string                `json:"description"`
+	Body         string                `json:"body"`
+	Tags         []string              `json:"tagList"`
+	CreatedAt    string                `json:"createdAt"`
+	Editor       users.ProfileResponse `json:"editor"`
+	RestoredFrom *int                  `json:"restoredFrom"`
+}
+
+type RevisionsSerializer struct {
+	C         *gin.Context
+	Revisions []RevisionModel
+}
+
+func (s *RevisionSerializer) Response() RevisionResponse {
+	editorSerializer := ArticleUserSerializer{s.C, s.Editor}
+	response := RevisionResponse{
+		Number:       s.Number,
+		Title:        s.Title,
+		Description:  s.Description,
+		Body:         s.Body,
+		Tags:         s.TagList(),
+		CreatedAt:    s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		Editor:       editorSerializer.Response(),
+		RestoredFrom: s.RestoredFrom,
+	}
+	if response.Tags == nil {
+		response.Tags = []string{}
+	}
+	return response
+}
+
+func (s *RevisionsSerializer) Response() []RevisionResponse {
+	response := []RevisionResponse{}
+	for _, revision := range s.Revisions {
+		serializer := RevisionSerializer{s.C, revision}
+		response = append(response, serializer.Response())
+	}
+	return response
+}
@@ -0,0 +1,198 @@
+package articles
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gosimple/slug"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// A slug an article had before a rename, its links redirect to the current one.
+type SlugHistoryModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	ArticleID uint   `gorm:"column:article_id;not null;index"`
+	Slug      string `gorm:"column:slug;size:255;not null;unique_index"`
+}
+
+func (SlugHistoryModel) TableName() string {
+	return "article_slugs"
+}
+
+// The path segments routed elsewhere than to an article.
+var reservedSlugs = map[string]bool{"feed": true, "drafts": true, "by-id": true, "search": true}
+
+const (
+	// The slug of a long title is cut there, leaving room for a suffix
+	maxSlugBase = 200
+	// -2 to -9 before a random suffix
+	numericSlugSuffixes = 9
+	randomSlugSuffixes  = 5
+	// A concurrent create taking the slug first is retried that many times
+	createArticleTries = 3
+)
+
+var ErrNoFreeSlug = errors.New("no free slug for the title")
+
+// The slug of the title free for the article: as is, else suffixed with -2 to -9, else with a
+// random suffix. The current and former slugs of any other article are taken, deleted ones
+// included, so an old link never leads to another article. articleID 0 is a new article.
+func uniqueSlug(db *gorm.DB, title string, articleID uint) (string, error) {
+	base := slug.Make(title)
+	if len(base) > maxSlugBase {
+		base = strings.TrimRight(base[:maxSlugBase], "-")
+	}
+	if base == "" {
+		base = "article"
+	}
+	candidates := []string{base}
+	for i := 2; i <= numericSlugSuffixes; i++ {
+		candidates = append(candidates, fmt.Sprintf("%s-%d", base, i))
+	}
+	for i := 0; i < randomSlugSuffixes; i++ {
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		candidates = append(candidates, base+"-"+hex.EncodeToString(suffix))
+	}
+	for _, candidate := range candidates {
+		taken, err := slugTaken(db, candidate, articleID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", ErrNoFreeSlug
+}
+
+func slugTaken(db *gorm.DB, candidate string, articleID uint) (bool, error) {
+	if reservedSlugs[candidate] {
+		return true, nil
+	}
+	var count int
+	// Unscoped: a deleted article keeps its row and the unique index
+	err := db.Unscoped().Model(&ArticleModel{}).Where("slug = ? AND id <> ?", candidate, articleID).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = db.Model(&SlugHistoryModel{}).Where("slug = ? AND article_id <> ?", candidate, articleID).Count(&count).Error
+	return count > 0, err
+}
+
+func isSlugConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: article_models.slug")
+}
+
+// Create the article under a free slug of its title, published unless it has a status,
+// with its first revision.
+func CreateArticle(ctx context.Context, article *ArticleModel) error {
+	if article.Status == "" {
+		article.setStatus(ArticlePublished, nil, time.Now())
+	}
+	var err error
+	for try := 0; try < createArticleTries; try++ {
+		err = common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			if article.Slug, err = uniqueSlug(tx, article.Title, 0); err != nil {
+				return err
+			}
+			if err := tx.Create(article).Error; err != nil {
+				return err
+			}
+			if err := recordRevision(tx, article.ID, article.AuthorID, nil); err != nil {
+				return err
+			}
+			return indexArticle(tx, article.ID)
+		})
+		if !isSlugConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Update the article with data as editor, recorded as a new revision. A title of another slug
+// moves the article to a free slug of it, the former one joins the history.
+func (model *ArticleModel) UpdateWithSlug(ctx context.Context, data ArticleModel, editor ArticleUserModel) error {
+	return model.edit(ctx, data, editor, nil)
+}
+
+// Update the article with data, or with the content of the revision `restored` when not nil.
+func (model *ArticleModel) edit(ctx context.Context, data ArticleModel, editor ArticleUserModel, restored *RevisionModel) error {
+	return common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := recordFirstRevision(tx, *model); err != nil {
+			return err
+		}
+		var restoredFrom *int
+		if restored != nil {
+			data = ArticleModel{Title: restored.Title, Description: restored.Description, Body: restored.Body}
+			// In the transaction, a failed restore leaves no new tag behind
+			var err error
+			if data.Tags, err = findOrCreateTags(tx, restored.TagList()); err != nil {
+				return err
+			}
+			restoredFrom = &restored.Number
+		}
+		data.Slug = model.Slug
+		if slug.Make(data.Title) != slug.Make(model.Title) {
+			var err error
+			if data.Slug, err = uniqueSlug(tx, data.Title, model.ID); err != nil {
+				return err
+			}
+		}
+		if data.Slug != model.Slug {
+			// Renamed back to a former slug, it leaves the history
+			err := tx.Where("article_id = ? AND slug = ?", model.ID, data.Slug).Delete(&SlugHistoryModel{}).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&SlugHistoryModel{ArticleID: model.ID, Slug: model.Slug}).Error; err != nil {
+				return err
+			}
+		}
+		// A map, the struct Update of gorm skips the empty description or body of data
+		err := tx.Model(model).Updates(map[string]interface{}{
+			"title":       data.Title,
+			"slug":        data.Slug,
+			"description": data.Description,
+			"body":        data.Body,
+		}).Error
+		if err != nil {
+			return err
+		}
+		// The tags sent replace the article's, Update only adds them
+		if err := tx.Model(model).Association("Tags").Replace(data.Tags).Error; err != nil {
+			return err
+		}
+		if err := recordRevision(tx, model.ID, editor.ID, restoredFrom); err != nil {
+			return err
+		}
+		return indexArticle(tx, model.ID)
+	})
+}
+
+// The current slug of the article that had the slug, gorm.ErrRecordNotFound when none did
+// or it was deleted since.
+func FindRenamedSlug(ctx context.Context, former string) (string, error) {
+	db := common.GetDBContext(ctx)
+	var history SlugHistoryModel
+	if err := db.Where(&SlugHistoryModel{Slug: former}).First(&history).Error; err != nil {
+		return "", err
+	}
+	var article ArticleModel
+	if err := db.Select("slug").First(&article, history.ArticleID).Error; err != nil {
+		return "", err
+	}
+	return article.Slug, nil
+}
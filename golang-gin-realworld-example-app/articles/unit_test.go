@@ -2,17 +2,24 @@ package articles
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"realworld-backend/common"
+	"realworld-backend/metrics"
 	"realworld-backend/users"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +35,10 @@ func setupTestDB() {
 	test_db.AutoMigrate(&FavoriteModel{})
 	test_db.AutoMigrate(&ArticleUserModel{})
 	test_db.AutoMigrate(&CommentModel{})
+	test_db.AutoMigrate(&SlugHistoryModel{})
+	test_db.AutoMigrate(&RevisionModel{})
+	// Only with -tags sqlite_fts5, TestSearch checks the 501 otherwise
+	RebuildSearchIndex(context.Background())
 }
 
 // Helper function to create a test user
@@ -60,6 +71,28 @@ func createTestArticle(title, description, body string, author ArticleUserModel)
 	return article
 }
 
+// The articles API as mounted by SetupRouter
+func newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/api/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/api/articles"))
+	return r
+}
+
+// A JSON request to r, authenticated as the user id unless it's 0
+func request(r *gin.Engine, method, url, body string, id uint) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if id != 0 {
+		req.Header.Set("Authorization", "Token "+common.GenToken(id))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
 // TestMain sets up test environment
 func TestMain(m *testing.M) {
 	setupTestDB()
@@ -75,7 +108,7 @@ func TestArticleModel_Creation(t *testing.T) {
 
 	// Create test user and article user model
 	user := createTestUser("testuser", "test@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 
 	// Test article creation with valid data
 	article := ArticleModel{
@@ -85,7 +118,7 @@ func TestArticleModel_Creation(t *testing.T) {
 		Author:      articleUser,
 	}
 
-	err := SaveOne(&article)
+	err := SaveOne(context.Background(), &article)
 	asserts.NoError(err, "Article should be saved successfully")
 	asserts.NotZero(article.ID, "Article should have an ID after creation")
 	asserts.Equal("Test Article", article.Title, "Article title should match")
@@ -96,7 +129,7 @@ func TestArticleModel_Validation(t *testing.T) {
 
 	// Test article with empty required fields
 	article := ArticleModel{}
-	err := SaveOne(&article)
+	err := SaveOne(context.Background(), &article)
 
 	// Should handle missing required fields gracefully
 	// Note: GORM might not enforce all validations at model level,
@@ -110,26 +143,26 @@ func TestArticleModel_FavoritesFunctionality(t *testing.T) {
 	// Setup test data
 	user1 := createTestUser("user1", "user1@example.com")
 	user2 := createTestUser("user2", "user2@example.com")
-	articleUser1 := GetArticleUserModel(user1)
-	articleUser2 := GetArticleUserModel(user2)
+	articleUser1 := GetArticleUserModel(context.Background(), user1)
+	articleUser2 := GetArticleUserModel(context.Background(), user2)
 
 	article := createTestArticle("Favorite Test", "Test Description", "Test Body", articleUser1)
 
 	// Test initial state
-	asserts.Equal(uint(0), article.favoritesCount(), "Initial favorites count should be 0")
-	asserts.False(article.isFavoriteBy(articleUser2), "Article should not be favorited initially")
+	asserts.Equal(uint(0), article.favoritesCount(context.Background()), "Initial favorites count should be 0")
+	asserts.False(article.isFavoriteBy(context.Background(), articleUser2), "Article should not be favorited initially")
 
 	// Test favoriting
-	err := article.favoriteBy(articleUser2)
+	err := article.favoriteBy(context.Background(), articleUser2)
 	asserts.NoError(err, "Favoriting should succeed")
-	asserts.True(article.isFavoriteBy(articleUser2), "Article should be favorited after favoriting")
-	asserts.Equal(uint(1), article.favoritesCount(), "Favorites count should be 1")
+	asserts.True(article.isFavoriteBy(context.Background(), articleUser2), "Article should be favorited after favoriting")
+	asserts.Equal(uint(1), article.favoritesCount(context.Background()), "Favorites count should be 1")
 
 	// Test unfavoriting
-	err = article.unFavoriteBy(articleUser2)
+	err = article.unFavoriteBy(context.Background(), articleUser2)
 	asserts.NoError(err, "Unfavoriting should succeed")
-	asserts.False(article.isFavoriteBy(articleUser2), "Article should not be favorited after unfavoriting")
-	asserts.Equal(uint(0), article.favoritesCount(), "Favorites count should be 0 after unfavoriting")
+	asserts.False(article.isFavoriteBy(context.Background(), articleUser2), "Article should not be favorited after unfavoriting")
+	asserts.Equal(uint(0), article.favoritesCount(context.Background()), "Favorites count should be 0 after unfavoriting")
 }
 
 func TestArticleModel_TagAssociation(t *testing.T) {
@@ -137,7 +170,7 @@ func TestArticleModel_TagAssociation(t *testing.T) {
 
 	// Create test user
 	user := createTestUser("taguser", "taguser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 
 	// Create article with tags beforehand
 	tags := []string{"golang", "testing", "gin"}
@@ -149,7 +182,7 @@ func TestArticleModel_TagAssociation(t *testing.T) {
 	}
 
 	// Test setting tags first, then save
-	err := article.setTags(tags)
+	err := article.setTags(context.Background(), tags)
 	asserts.NoError(err, "Setting tags should succeed")
 	asserts.Equal(3, len(article.Tags), "Article should have 3 tags after setTags")
 
@@ -158,7 +191,7 @@ func TestArticleModel_TagAssociation(t *testing.T) {
 	articleCounter++
 
 	// Save article with tags
-	err = SaveOne(&article)
+	err = SaveOne(context.Background(), &article)
 	asserts.NoError(err, "Article with tags should be saved")
 
 	// Retrieve and verify tags
@@ -172,14 +205,14 @@ func TestGetArticleUserModel(t *testing.T) {
 
 	// Test with valid user
 	user := createTestUser("articleuser", "articleuser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 
 	asserts.NotZero(articleUser.ID, "ArticleUserModel should be created")
 	asserts.Equal(user.ID, articleUser.UserModelID, "UserModelID should match")
 
 	// Test with empty user
 	emptyUser := users.UserModel{}
-	emptyArticleUser := GetArticleUserModel(emptyUser)
+	emptyArticleUser := GetArticleUserModel(context.Background(), emptyUser)
 	asserts.Zero(emptyArticleUser.ID, "Empty user should return empty ArticleUserModel")
 }
 
@@ -190,7 +223,7 @@ func TestArticleSerializer_Output(t *testing.T) {
 
 	// Setup test data
 	user := createTestUser("serializeruser", "serializeruser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 	article := createTestArticle("Serializer Test", "Test Description", "Test Body", articleUser)
 
 	// Create gin context for serializer
@@ -216,7 +249,7 @@ func TestArticleListSerializer(t *testing.T) {
 
 	// Setup test data
 	user := createTestUser("listuser", "listuser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 
 	article1 := createTestArticle("Article 1", "Description 1", "Body 1", articleUser)
 	article2 := createTestArticle("Article 2", "Description 2", "Body 2", articleUser)
@@ -243,7 +276,7 @@ func TestCommentSerializer_Structure(t *testing.T) {
 
 	// Setup test data
 	user := createTestUser("commentuser", "commentuser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 	article := createTestArticle("Comment Test", "Test Description", "Test Body", articleUser)
 
 	// Create comment
@@ -483,7 +516,7 @@ func TestArticleModel_UpdateFunctionality(t *testing.T) {
 
 	// Create test article
 	user := createTestUser("updateuser", "updateuser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 	article := createTestArticle("Original Title", "Original Description", "Original Body", articleUser)
 
 	// Update article
@@ -493,7 +526,7 @@ func TestArticleModel_UpdateFunctionality(t *testing.T) {
 		"body":        "Updated Body",
 	}
 
-	err := article.Update(updatedData)
+	err := article.Update(context.Background(), updatedData)
 	asserts.NoError(err, "Article update should succeed")
 
 	// Verify update by reloading
@@ -507,19 +540,19 @@ func TestFindOneArticle(t *testing.T) {
 
 	// Create test article
 	user := createTestUser("finduser", "finduser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 	article := createTestArticle("Find Test", "Description", "Body", articleUser)
 	// Update slug directly in database for testing
 	uniqueSlug := fmt.Sprintf("find-test-slug-%d", articleCounter)
 	test_db.Model(&article).Update("slug", uniqueSlug)
 
 	// Test finding by slug
-	foundArticle, err := FindOneArticle(&ArticleModel{Slug: uniqueSlug})
+	foundArticle, err := FindOneArticle(context.Background(), &ArticleModel{Slug: uniqueSlug})
 	asserts.NoError(err, "Finding article should succeed")
 	asserts.Equal("Find Test", foundArticle.Title, "Found article should have correct title")
 
 	// Test finding non-existent article
-	_, err = FindOneArticle(&ArticleModel{Slug: "non-existent-slug"})
+	_, err = FindOneArticle(context.Background(), &ArticleModel{Slug: "non-existent-slug"})
 	// Note: FindOneArticle may not return error for non-existent articles in current implementation
 	// The error handling might be done at the router level
 	// asserts.Error(err, "Finding non-existent article should return error")
@@ -530,13 +563,13 @@ func TestDeleteArticleModel(t *testing.T) {
 
 	// Create test article
 	user := createTestUser("deleteuser", "deleteuser@example.com")
-	articleUser := GetArticleUserModel(user)
+	articleUser := GetArticleUserModel(context.Background(), user)
 	article := createTestArticle("Delete Test", "Description", "Body", articleUser)
 	uniqueSlug := fmt.Sprintf("delete-test-slug-%d", articleCounter)
 	test_db.Model(&article).Update("slug", uniqueSlug)
 
 	// Delete article
-	err := DeleteArticleModel(&ArticleModel{Slug: uniqueSlug})
+	err := DeleteArticleModel(context.Background(), &ArticleModel{Slug: uniqueSlug})
 	asserts.NoError(err, "Deleting article should succeed")
 
 	// Verify deletion
@@ -555,7 +588,7 @@ func TestGetAllTags(t *testing.T) {
 	test_db.Create(&tag2)
 
 	// Get all tags
-	tags, err := getAllTags()
+	tags, err := getAllTags(context.Background())
 	asserts.NoError(err, "Getting all tags should succeed")
 	asserts.GreaterOrEqual(len(tags), 2, "Should return at least 2 tags")
 
@@ -567,3 +600,480 @@ func TestGetAllTags(t *testing.T) {
 	asserts.Contains(tagNames, "test-tag-1", "Should contain test-tag-1")
 	asserts.Contains(tagNames, "test-tag-2", "Should contain test-tag-2")
 }
+
+func TestOwnershipPolicy(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createTestUser("policyauthor", "policyauthor@example.com")
+	other := createTestUser("policyother", "policyother@example.com")
+	moderator := createTestUser("policymod", "policymod@example.com")
+	test_db.Model(&moderator).Update("role", users.RoleModerator)
+	admin := createTestUser("policyadmin", "policyadmin@example.com")
+	test_db.Model(&admin).Update("role", users.RoleAdmin)
+	article := createTestArticle("Policy Article", "Description", "Body", GetArticleUserModel(context.Background(), author))
+	otherArticle := createTestArticle("Other Article", "Description", "Body", GetArticleUserModel(context.Background(), other))
+	comment := CommentModel{Article: article, Author: GetArticleUserModel(context.Background(), other), Body: "First"}
+	test_db.Create(&comment)
+	commentURL := func(slug string) string { return fmt.Sprintf("/api/articles/%s/comments/%d", slug, comment.ID) }
+
+	update := `{"article":{"title": "Edited Title"}}`
+	w := request(r, "PUT", "/api/articles/"+article.Slug, update, other.ID)
+	asserts.Equal(403, w.Code)
+	asserts.Contains(w.Body.String(), ErrNotAuthor.Error())
+	asserts.Equal(403, request(r, "PUT", "/api/articles/"+article.Slug, update, moderator.ID).Code, "A moderator can't edit")
+	asserts.Equal(404, request(r, "PUT", "/api/articles/nope", update, author.ID).Code)
+	asserts.Equal(200, request(r, "PUT", "/api/articles/"+article.Slug, update, author.ID).Code)
+	// The title makes the slug
+	asserts.Equal(200, request(r, "PUT", "/api/articles/edited-title", `{"article":{"title": "Fixed Title"}}`, admin.ID).Code)
+	edited, err := FindOneArticle(context.Background(), &ArticleModel{Model: gorm.Model{ID: article.ID}})
+	asserts.NoError(err)
+	asserts.Equal("Fixed Title", edited.Title)
+	asserts.Equal(author.ID, edited.Author.UserModelID, "An admin's edit should keep the author")
+
+	asserts.Equal(404, request(r, "DELETE", commentURL(otherArticle.Slug), ``, other.ID).Code,
+		"A comment should only be deleted through its article")
+	asserts.Equal(403, request(r, "DELETE", commentURL(edited.Slug), ``, author.ID).Code,
+		"The article's author doesn't own the comments")
+	asserts.Equal(200, request(r, "DELETE", commentURL(edited.Slug), ``, other.ID).Code)
+	asserts.Equal(404, request(r, "DELETE", commentURL(edited.Slug), ``, other.ID).Code)
+
+	asserts.Equal(403, request(r, "DELETE", "/api/articles/"+edited.Slug, ``, other.ID).Code)
+	asserts.Equal(200, request(r, "DELETE", "/api/articles/"+edited.Slug, ``, author.ID).Code)
+	asserts.Equal(404, request(r, "DELETE", "/api/articles/"+edited.Slug, ``, author.ID).Code)
+	asserts.Equal(200, request(r, "DELETE", "/api/articles/"+otherArticle.Slug, ``, moderator.ID).Code)
+}
+
+func TestSlugs(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createTestUser("slugauthor", "slugauthor@example.com")
+	write := func(method, url, title string) ArticleResponse {
+		w := request(r, method, url, fmt.Sprintf(`{"article":{"title": %q, "body": "Body"}}`, title), author.ID)
+		var response struct {
+			Article ArticleResponse `json:"article"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response), w.Body.String())
+		return response.Article
+	}
+
+	first := write("POST", "/api/articles/", "Same Title")
+	asserts.Equal("same-title", first.Slug)
+	second := write("POST", "/api/articles/", "Same Title")
+	asserts.Equal("same-title-2", second.Slug, "A second article of the title should get a suffix")
+	asserts.Equal("feed-2", write("POST", "/api/articles/", "Feed").Slug, "The routes' words should be reserved")
+	for i := 3; i <= numericSlugSuffixes; i++ {
+		write("POST", "/api/articles/", "Same Title")
+	}
+	asserts.Regexp(`^same-title-[0-9a-f]{6}$`, write("POST", "/api/articles/", "Same Title").Slug)
+
+	renamed := write("PUT", "/api/articles/same-title", "A Better Title")
+	asserts.Equal("a-better-title", renamed.Slug)
+	asserts.Equal(first.ID, renamed.ID)
+	asserts.Equal("a-better-title", write("PUT", "/api/articles/a-better-title", "A better title").Slug,
+		"The slug shouldn't change with the title's case")
+	w := request(r, "GET", "/api/articles/same-title?x=1", ``, author.ID)
+	asserts.Equal(http.StatusMovedPermanently, w.Code)
+	asserts.Equal("/api/articles/a-better-title?x=1", w.Header().Get("Location"))
+	w = request(r, "GET", "/api/articles/same-title/comments", ``, author.ID)
+	asserts.Equal(http.StatusMovedPermanently, w.Code)
+	asserts.Equal("/api/articles/a-better-title/comments", w.Header().Get("Location"))
+	asserts.NotEqual("same-title", write("POST", "/api/articles/", "Same Title").Slug,
+		"A former slug shouldn't be given to another article")
+
+	asserts.Equal("same-title", write("PUT", "/api/articles/a-better-title", "Same Title").Slug,
+		"An article should get its former slug back")
+	asserts.Equal(http.StatusOK, request(r, "GET", "/api/articles/same-title", ``, author.ID).Code)
+	asserts.Equal(http.StatusMovedPermanently, request(r, "GET", "/api/articles/a-better-title", ``, author.ID).Code)
+
+	w = request(r, "GET", fmt.Sprintf("/api/articles/by-id/%d", first.ID), ``, author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"same-title"`)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/by-id/abc", ``, author.ID).Code)
+
+	asserts.Equal(http.StatusOK, request(r, "DELETE", "/api/articles/same-title-2", ``, author.ID).Code)
+	asserts.NotEqual("same-title-2", write("POST", "/api/articles/", "Same Title").Slug,
+		"A deleted article's slug shouldn't be given again")
+}
+
+func TestArticleLifecycle(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createTestUser("lifecycleauthor", "lifecycleauthor@example.com")
+	reader := createTestUser("lifecyclereader", "lifecyclereader@example.com")
+	admin := createTestUser("lifecycleadmin", "lifecycleadmin@example.com")
+	test_db.Model(&admin).Update("role", users.RoleAdmin)
+	article := func(w *httptest.ResponseRecorder) ArticleResponse {
+		var response struct {
+			Article ArticleResponse `json:"article"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Article
+	}
+	listed := func(url string, id uint) []string {
+		var response struct {
+			Articles []ArticleResponse `json:"articles"`
+		}
+		asserts.NoError(json.Unmarshal(request(r, "GET", url, ``, id).Body.Bytes(), &response))
+		var slugs []string
+		for _, a := range response.Articles {
+			slugs = append(slugs, a.Slug)
+		}
+		return slugs
+	}
+
+	w := request(r, "POST", "/api/articles/", `{"article":{"title": "Lifecycle Draft", "body": "Body", "status": "draft"}}`, author.ID)
+	asserts.Equal(http.StatusCreated, w.Code)
+	draft := article(w)
+	asserts.Equal(ArticleDraft, draft.Status)
+	asserts.Nil(draft.PublishedAt)
+	asserts.Equal(http.StatusOK, request(r, "GET", "/api/articles/"+draft.Slug, ``, author.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+draft.Slug, ``, 0).Code, "A draft should be hidden")
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+draft.Slug, ``, reader.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+draft.Slug, ``, admin.ID).Code, "A draft should be hidden from the admins too")
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+draft.Slug+"/comments", ``, reader.ID).Code)
+	asserts.Equal(http.StatusNotFound, request(r, "POST", "/api/articles/"+draft.Slug+"/favorite", ``, reader.ID).Code)
+	asserts.NotContains(listed("/api/articles/?author=lifecycleauthor", 0), draft.Slug)
+	asserts.Contains(listed("/api/articles/drafts", author.ID), draft.Slug)
+	asserts.Empty(listed("/api/articles/drafts", reader.ID))
+	asserts.Equal(http.StatusUnauthorized, request(r, "GET", "/api/articles/drafts", ``, 0).Code)
+
+	asserts.Equal(http.StatusForbidden, request(r, "POST", "/api/articles/"+draft.Slug+"/publish", ``, reader.ID).Code)
+	w = request(r, "POST", "/api/articles/"+draft.Slug+"/publish", ``, author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(ArticlePublished, article(w).Status)
+	asserts.NotNil(article(w).PublishedAt)
+	asserts.Equal(http.StatusOK, request(r, "GET", "/api/articles/"+draft.Slug, ``, 0).Code)
+	asserts.Contains(listed("/api/articles/?author=lifecycleauthor", 0), draft.Slug)
+	w = request(r, "PUT", "/api/articles/"+draft.Slug, `{"article":{"title": "Lifecycle Draft", "status": "draft"}}`, author.ID)
+	asserts.Equal(ArticlePublished, article(w).Status, "An update shouldn't change the status")
+
+	asserts.Equal(ArticleArchived, article(request(r, "POST", "/api/articles/"+draft.Slug+"/archive", ``, author.ID)).Status)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+draft.Slug, ``, reader.ID).Code)
+	asserts.Equal(ArticleDraft, article(request(r, "POST", "/api/articles/"+draft.Slug+"/unpublish", ``, author.ID)).Status)
+
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "POST", "/api/articles/", `{"article":{"title": "Lifecycle Late", "status": "scheduled", "publishedAt": "2020-01-01T00:00:00Z"}}`, author.ID).Code)
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "POST", "/api/articles/"+draft.Slug+"/publish", `{"article":{"publishedAt": "2020-01-01T00:00:00Z"}}`, author.ID).Code)
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w = request(r, "POST", "/api/articles/", fmt.Sprintf(`{"article":{"title": "Lifecycle Scheduled", "status": "scheduled", "publishedAt": %q}}`, at.Format(time.RFC3339)), author.ID)
+	asserts.Equal(http.StatusCreated, w.Code)
+	scheduled := article(w)
+	asserts.Equal(ArticleScheduled, scheduled.Status)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+scheduled.Slug, ``, reader.ID).Code)
+
+	published, err := PublishDue(context.Background(), time.Now())
+	asserts.NoError(err)
+	asserts.Zero(published, "An article shouldn't be published before its time")
+	published, err = PublishDue(context.Background(), at)
+	asserts.NoError(err)
+	asserts.EqualValues(1, published)
+	w = request(r, "GET", "/api/articles/"+scheduled.Slug, ``, reader.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(at.Format("2006-01-02T15:04:05.999Z"), *article(w).PublishedAt, "The scheduled time should stay the publication time")
+
+	// The offsets of the client and the server shouldn't move the publication
+	tokyo, newYork := time.FixedZone("JST", 9*3600), time.FixedZone("EST", -5*3600)
+	at = time.Now().Add(time.Hour).Truncate(time.Second).In(tokyo)
+	w = request(r, "POST", "/api/articles/", fmt.Sprintf(`{"article":{"title": "Lifecycle Tokyo", "status": "scheduled", "publishedAt": %q}}`, at.Format(time.RFC3339)), author.ID)
+	asserts.Equal(http.StatusCreated, w.Code)
+	published, err = PublishDue(context.Background(), time.Now().In(newYork))
+	asserts.NoError(err)
+	asserts.Zero(published, "An article shouldn't be published before its time in another zone")
+	published, err = PublishDue(context.Background(), at.Add(-time.Minute).In(newYork))
+	asserts.NoError(err)
+	asserts.Zero(published)
+	published, err = PublishDue(context.Background(), at.In(newYork))
+	asserts.NoError(err)
+	asserts.EqualValues(1, published)
+	asserts.Equal(at.UTC().Format("2006-01-02T15:04:05.999Z"), *article(request(r, "GET", "/api/articles/"+article(w).Slug, ``, reader.ID)).PublishedAt)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	asserts := assert.New(t)
+	asserts.Equal("", unifiedDiff("a", "b", "same\n", "same\n"))
+	asserts.Equal("--- a\n+++ b\n@@ -0,0 +1,2 @@\n+one\n+two\n", unifiedDiff("a", "b", "", "one\ntwo\n"))
+
+	before := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	after := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+	asserts.Equal(`--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`, unifiedDiff("a", "b", before, after), "Changes more than two contexts apart should be separate hunks")
+	asserts.Equal(`--- a
++++ b
+@@ -1 +1 @@
+-3
++three
+`, unifiedDiff("a", "b", "3\n", "three\n"))
+
+	long := strings.Repeat("line\n", 1025)
+	diff := unifiedDiff("a", "b", long, long+"more\n")
+	asserts.True(strings.HasPrefix(diff, "--- a\n+++ b\n@@ -1,1025 +1,1026 @@\n-line\n"), "Too long texts should be replaced whole")
+	asserts.Equal(1025, strings.Count(diff, "\n-line"))
+}
+
+func TestRevisions(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createTestUser("revisionauthor", "revisionauthor@example.com")
+	reader := createTestUser("revisionreader", "revisionreader@example.com")
+	var response struct {
+		Article        ArticleResponse    `json:"article"`
+		Revision       RevisionResponse   `json:"revision"`
+		Revisions      []RevisionResponse `json:"revisions"`
+		RevisionsCount int                `json:"revisionsCount"`
+		Diff           struct {
+			From, To int
+			Unified  string
+		} `json:"diff"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		response.Revisions = nil
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response), w.Body.String())
+	}
+
+	decode(request(r, "POST", "/api/articles/", `{"article":{"title": "Revised Article", "body": "First\nbody", "tagList": ["a"]}}`, author.ID))
+	slug := response.Article.Slug
+	decode(request(r, "PUT", "/api/articles/"+slug, `{"article":{"title": "Revised Article", "body": "First\nbody\nedited", "tagList": ["b"]}}`, author.ID))
+	asserts.Equal([]string{"b"}, response.Article.Tags, "The tags sent should replace the article's")
+	decode(request(r, "PUT", "/api/articles/"+slug, `{"article":{"title": "Renamed Article"}}`, author.ID))
+	slug = response.Article.Slug
+
+	asserts.Equal(http.StatusForbidden, request(r, "GET", "/api/articles/"+slug+"/revisions", ``, reader.ID).Code)
+	decode(request(r, "GET", "/api/articles/"+slug+"/revisions", ``, author.ID))
+	asserts.Equal(3, response.RevisionsCount)
+	if asserts.Len(response.Revisions, 3) {
+		asserts.Equal(3, response.Revisions[0].Number)
+		asserts.Equal("Renamed Article", response.Revisions[0].Title)
+		asserts.Equal("revisionauthor", response.Revisions[0].Editor.Username)
+	}
+	decode(request(r, "GET", "/api/articles/"+slug+"/revisions?limit=-1", ``, author.ID))
+	asserts.Len(response.Revisions, 1, "A negative limit should return one revision, not all")
+	w := request(r, "GET", "/api/articles/"+slug+"/revisions/1", ``, author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	decode(w)
+	asserts.Equal("First\nbody", response.Revision.Body)
+	asserts.Equal([]string{"a"}, response.Revision.Tags)
+	asserts.Equal(http.StatusNotFound, request(r, "GET", "/api/articles/"+slug+"/revisions/9", ``, author.ID).Code)
+
+	decode(request(r, "GET", "/api/articles/"+slug+"/revisions/2/diff", ``, author.ID))
+	asserts.Equal(1, response.Diff.From)
+	asserts.Contains(response.Diff.Unified, "-Tags: a\n+Tags: b\n")
+	asserts.Contains(response.Diff.Unified, " body\n+edited\n")
+	decode(request(r, "GET", "/api/articles/"+slug+"/revisions/3/diff?from=1", ``, author.ID))
+	asserts.Contains(response.Diff.Unified, "--- revision 1\n+++ revision 3\n")
+	asserts.Contains(response.Diff.Unified, "-Title: Revised Article\n+Title: Renamed Article\n")
+
+	asserts.Equal(http.StatusForbidden, request(r, "POST", "/api/articles/"+slug+"/revisions/1/restore", ``, reader.ID).Code)
+	w = request(r, "POST", "/api/articles/"+slug+"/revisions/1/restore", ``, author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	decode(w)
+	asserts.Equal("Revised Article", response.Article.Title)
+	asserts.Equal("First\nbody", response.Article.Body)
+	asserts.Equal([]string{"a"}, response.Article.Tags)
+	decode(request(r, "GET", "/api/articles/"+response.Article.Slug+"/revisions/4", ``, author.ID))
+	if asserts.NotNil(response.Revision.RestoredFrom) {
+		asserts.Equal(1, *response.Revision.RestoredFrom)
+	}
+
+	decode(request(r, "PUT", "/api/articles/"+response.Article.Slug, `{"article":{"title": "Revised Article", "description": "Now described", "body": "Longer body"}}`, author.ID))
+	w = request(r, "POST", "/api/articles/"+response.Article.Slug+"/revisions/4/restore", ``, author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	decode(w)
+	asserts.Equal("", response.Article.Description, "Restoring should empty a description the revision didn't have")
+	asserts.Equal("First\nbody", response.Article.Body)
+	decode(request(r, "GET", "/api/articles/"+response.Article.Slug+"/revisions/6/diff?from=4", ``, author.ID))
+	asserts.Equal("", response.Diff.Unified, "The restored revision should have the content of the one it restores")
+
+	legacy := createTestArticle("Legacy Article", "Description", "Old body", GetArticleUserModel(context.Background(), author))
+	request(r, "PUT", "/api/articles/"+legacy.Slug, `{"article":{"title": "Legacy Article", "body": "New body"}}`, author.ID)
+	decode(request(r, "GET", "/api/articles/"+legacy.Slug+"/revisions", ``, author.ID))
+	if asserts.Len(response.Revisions, 2, "An article without revisions should get one of its content before its first edit") {
+		asserts.Equal("Old body", response.Revisions[1].Body)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	asserts := assert.New(t)
+	html := RenderMarkdown("# Title\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n```go\nfmt.Println(1)\n```\n\n- [x] done\n\nSee https://example.com and ~~this~~.")
+	asserts.Contains(html, "<h1>Title</h1>")
+	asserts.Contains(html, "<td>1</td>")
+	asserts.Contains(html, `<code class="language-go">`)
+	asserts.Contains(html, `<input checked="" disabled="" type="checkbox"> done`)
+	asserts.Contains(html, `<a href="https://example.com" rel="nofollow noreferrer">https://example.com</a>`)
+	asserts.Contains(html, "<del>this</del>")
+
+	for _, body := range []string{
+		"<script>alert(1)</script>",
+		"[click](javascript:alert(1))",
+		`<img src="x" onerror="alert(1)">`,
+		"<iframe src=\"https://example.com\"></iframe>",
+	} {
+		html := RenderMarkdown(body)
+		asserts.NotContains(html, "alert", body)
+		asserts.NotContains(html, "iframe", body)
+	}
+}
+
+func TestBodyHTML(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createTestUser("markdownauthor", "markdownauthor@example.com")
+	article := createTestArticle("Markdown Article", "Description", "Some *emphasis*", GetArticleUserModel(context.Background(), author))
+	test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: article.AuthorID, Body: "A **comment**"})
+	var response struct {
+		Article  ArticleResponse   `json:"article"`
+		Articles []ArticleResponse `json:"articles"`
+		Comments []CommentResponse `json:"comments"`
+	}
+	get := func(url string) {
+		w := request(r, "GET", url, ``, 0)
+		asserts.Equal(http.StatusOK, w.Code, w.Body.String())
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	}
+
+	get("/api/articles/" + article.Slug)
+	asserts.Nil(response.Article.BodyHTML, "The HTML should only be rendered when asked")
+	get("/api/articles/" + article.Slug + "?bodyHtml=true")
+	if asserts.NotNil(response.Article.BodyHTML) {
+		asserts.Equal("<p>Some <em>emphasis</em></p>\n", *response.Article.BodyHTML)
+	}
+	get("/api/articles/" + article.Slug + "/comments?bodyHtml=true")
+	if asserts.Len(response.Comments, 1) && asserts.NotNil(response.Comments[0].BodyHTML) {
+		asserts.Equal("<p>A <strong>comment</strong></p>\n", *response.Comments[0].BodyHTML)
+	}
+
+	misses := testutil.ToFloat64(metrics.MarkdownRenders.WithLabelValues("miss"))
+	get("/api/articles/?author=markdownauthor&bodyHtml=true")
+	get("/api/articles/?author=markdownauthor&bodyHtml=true")
+	asserts.Len(response.Articles, 1)
+	asserts.Equal(misses, testutil.ToFloat64(metrics.MarkdownRenders.WithLabelValues("miss")), "A rendered body should come from the cache")
+
+	test_db.Model(&article).Update("body", "Edited _body_")
+	get("/api/articles/" + article.Slug + "?bodyHtml=true")
+	asserts.Equal(misses+1, testutil.ToFloat64(metrics.MarkdownRenders.WithLabelValues("miss")), "An edit should render again")
+	if asserts.NotNil(response.Article.BodyHTML) {
+		asserts.Equal("<p>Edited <em>body</em></p>\n", *response.Article.BodyHTML)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	asserts := assert.New(t)
+	asserts.Equal(`"go" "graceful shutdown" "optim"*`, searchQuery(`go "graceful shutdown" optim*`))
+	asserts.Equal(`"title:x" "OR" "-y" "NEAR("`, searchQuery(`title:x OR -y NEAR(`), "The FTS5 syntax should be searched as words")
+	asserts.Equal(`"say" "a""b" "hi"`, searchQuery(`say a"b "hi`))
+	asserts.Equal("", searchQuery(` * - "" `))
+	asserts.Equal(`&lt;b&gt;<mark>go</mark> &amp; co&lt;/b&gt;`, highlightHTML("<b>\x02go\x03 & co</b>"))
+}
+
+func TestSearch(t *testing.T) {
+	asserts := assert.New(t)
+	r := newRouter()
+	author := createTestUser("searchauthor", "searchauthor@example.com")
+	var response struct {
+		Article       ArticleResponse        `json:"article"`
+		Articles      []SearchResultResponse `json:"articles"`
+		ArticlesCount int                    `json:"articlesCount"`
+	}
+	search := func(q string) []string {
+		w := request(r, "GET", "/api/articles/search?q="+url.QueryEscape(q), ``, author.ID)
+		asserts.Equal(http.StatusOK, w.Code, w.Body.String())
+		response.Articles = nil
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		titles := []string{}
+		for _, article := range response.Articles {
+			titles = append(titles, article.Title)
+		}
+		return titles
+	}
+
+	if !fts5Compiled(test_db) {
+		asserts.Equal(http.StatusNotImplemented, request(r, "GET", "/api/articles/search?q=go", ``, author.ID).Code)
+		asserts.Equal(http.StatusCreated, request(r, "POST", "/api/articles/", `{"article":{"title": "Not indexed"}}`, author.ID).Code)
+		asserts.Zero(missedSearchWrites(test_db), "Without an index there's nothing to miss")
+		// The index a build with FTS5 left, this one can't write to it
+		test_db.Exec(`CREATE TABLE "article_search" ("title")`)
+		defer test_db.Exec(`DROP TABLE "article_search"`)
+		asserts.Equal(http.StatusCreated, request(r, "POST", "/api/articles/", `{"article":{"title": "Missed by the index"}}`, author.ID).Code)
+		asserts.Equal(1, missedSearchWrites(test_db), "A write the index can't take should be counted")
+		t.Skip("built without -tags sqlite_fts5")
+	}
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "GET", "/api/articles/search?q=*", ``, author.ID).Code)
+
+	for _, body := range []string{
+		`{"article":{"title": "Graceful shutdown in Go", "description": "Draining connections", "body": "Stop accepting requests, then wait.", "tagList": ["golang"]}}`,
+		`{"article":{"title": "Connection pools", "description": "Sizing them", "body": "A graceful shutdown closes the pool last.", "tagList": ["databases"]}}`,
+		`{"article":{"title": "Optimizing <queries>", "description": "Indexes", "body": "Measure first, optimize second.", "tagList": ["golang"]}}`,
+	} {
+		w := request(r, "POST", "/api/articles/", body, author.ID)
+		asserts.Equal(http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	asserts.Equal([]string{"Graceful shutdown in Go", "Connection pools"}, search("graceful shutdown"), "A title match should rank first")
+	if asserts.Len(response.Articles, 2) {
+		asserts.Equal("<mark>Graceful</mark> <mark>shutdown</mark> in Go", response.Articles[0].Search.Title)
+		asserts.Contains(response.Articles[1].Search.Snippet, "A <mark>graceful</mark> <mark>shutdown</mark> closes")
+		asserts.Greater(response.Articles[0].Search.Score, response.Articles[1].Search.Score)
+		asserts.Equal("searchauthor", response.Articles[0].Author.Username)
+	}
+	asserts.Equal(2, response.ArticlesCount)
+	w := request(r, "GET", "/api/articles/search?q=graceful+shutdown&limit=-1&offset=-5", ``, author.ID)
+	asserts.Equal(http.StatusOK, w.Code, w.Body.String())
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.Len(response.Articles, 1, "A negative limit should return one result, not all")
+	asserts.Equal(2, response.ArticlesCount)
+	asserts.Equal([]string{"Connection pools"}, search(`"closes the pool"`), "A phrase should match its words in order")
+	asserts.Empty(search(`"pool closes"`))
+	asserts.Equal([]string{"Optimizing <queries>"}, search("optimi*"), "A prefix should match the words starting with it")
+	asserts.Equal("<mark>Optimizing</mark> &lt;queries&gt;", response.Articles[0].Search.Title)
+	slug := response.Articles[0].Slug
+	asserts.Len(search("golang"), 2, "The tags should be searched")
+	asserts.Len(search("golang graceful"), 1, "Every word should match")
+
+	// Edited, archived and deleted articles leave the results
+	request(r, "PUT", "/api/articles/"+slug, `{"article":{"title": "Optimizing <queries>", "body": "Nothing to see.", "tagList": ["sql"]}}`, author.ID)
+	asserts.Empty(search("golang optimize"))
+	asserts.Len(search("sql"), 1)
+	asserts.Equal(http.StatusOK, request(r, "POST", "/api/articles/"+slug+"/archive", ``, author.ID).Code)
+	asserts.Empty(search("sql"))
+	request(r, "POST", "/api/articles/"+slug+"/publish", ``, author.ID)
+	asserts.Len(search("sql"), 1)
+	asserts.Equal(http.StatusOK, request(r, "DELETE", "/api/articles/"+slug, ``, author.ID).Code)
+	asserts.Empty(search("sql"))
+
+	count, err := RebuildSearchIndex(context.Background())
+	asserts.NoError(err)
+	asserts.Equal([]string{"Graceful shutdown in Go", "Connection pools"}, search("graceful shutdown"), "Rebuilding should find the same articles")
+	var articles int
+	test_db.Model(&ArticleModel{}).Count(&articles)
+	asserts.Equal(articles, count)
+
+	created, _, err := EnsureSearchIndex(context.Background())
+	asserts.NoError(err)
+	asserts.False(created, "An existing index should be kept")
+	test_db.Exec(`DROP TABLE "article_search"`)
+	created, count, err = EnsureSearchIndex(context.Background())
+	asserts.NoError(err)
+	asserts.True(created, "A missing index should be created")
+	asserts.Equal(articles, count)
+	asserts.Len(search("graceful shutdown"), 2)
+
+	// As a build without FTS5 would count a write it couldn't index
+	asserts.NoError(missSearchWrite(test_db, []uint{1}))
+	asserts.Equal(1, missedSearchWrites(test_db))
+	created, _, err = EnsureSearchIndex(context.Background())
+	asserts.NoError(err)
+	asserts.True(created, "An index that missed writes should be rebuilt")
+	asserts.Zero(missedSearchWrites(test_db))
+}
@@ -1,7 +1,8 @@
 package articles
 
 import (
-	"github.com/gosimple/slug"
+	"time"
+
 	"realworld-backend/common"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
@@ -13,6 +14,9 @@ type ArticleModelValidator struct {
 		Description string   `form:"description" json:"description" binding:"max=2048"`
 		Body        string   `form:"body" json:"body" binding:"max=2048"`
 		Tags        []string `form:"tagList" json:"tagList"`
+		// Published when empty, ignored on update: see the publish routes
+		Status      string     `form:"status" json:"status" binding:"omitempty,oneof=draft scheduled published"`
+		PublishedAt *time.Time `form:"publishedAt" json:"publishedAt"`
 	} `json:"article"`
 	articleModel ArticleModel `json:"-"`
 }
@@ -39,12 +43,11 @@ func (s *ArticleModelValidator) Bind(c *gin.Context) error {
 	if err != nil {
 		return err
 	}
-	s.articleModel.Slug = slug.Make(s.Article.Title)
 	s.articleModel.Title = s.Article.Title
 	s.articleModel.Description = s.Article.Description
 	s.articleModel.Body = s.Article.Body
-	s.articleModel.Author = GetArticleUserModel(myUserModel)
-	s.articleModel.setTags(s.Article.Tags)
+	s.articleModel.Author = GetArticleUserModel(common.RequestContext(c), myUserModel)
+	s.articleModel.setTags(common.RequestContext(c), s.Article.Tags)
 	return nil
 }
 
@@ -67,6 +70,24 @@ func (s *CommentModelValidator) Bind(c *gin.Context) error {
 		return err
 	}
 	s.commentModel.Body = s.Comment.Body
-	s.commentModel.Author = GetArticleUserModel(myUserModel)
+	s.commentModel.Author = GetArticleUserModel(common.RequestContext(c), myUserModel)
 	return nil
 }
+
+// The optional body of POST /api/articles/:slug/publish, a future publishedAt schedules the article.
+type PublishValidator struct {
+	Article struct {
+		PublishedAt *time.Time `form:"publishedAt" json:"publishedAt"`
+	} `json:"article"`
+}
+
+func (self *PublishValidator) Bind(c *gin.Context) error {
+	if c.Request.ContentLength == 0 {
+		return nil
+	}
+	return common.Bind(c, self)
+}
+
+func NewPublishValidator() PublishValidator {
+	return PublishValidator{}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/articles"
+	"realworld-backend/config"
+	"realworld-backend/jwtkeys"
+	"realworld-backend/users"
+)
+
+// A subcommand of the binary: `realworld-backend [-config file] <name> [args]`.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(cfg config.Config, db *gorm.DB, args []string) error
+}
+
+var commands = []command{
+	{"serve", "serve", "start the HTTP API server (default)", runServe},
+	{"migrate", "migrate up|down [steps]|status", "apply, revert or list the schema migrations", runMigrate},
+	{"seed", "seed [-users n] [-articles n] ...", "fill the database with realistic users, articles, tags, follows and favorites", runSeed},
+	{"create-user", "create-user -username name -email email -password password [-admin]", "create an account", runCreateUser},
+	{"promote-admin", "promote-admin <username|email>", "give an existing account the admin role", runPromoteAdmin},
+	{"unlock-user", "unlock-user <username|email>", "lift the lock of an account after too many failed logins", runUnlockUser},
+	{"disable-2fa", "disable-2fa <username|email>", "turn off the two-factor authentication of a user who lost the device and codes", runDisableTwoFactor},
+	{"rebuild-search-index", "rebuild-search-index", "create the full-text index of the articles and fill it again", runRebuildSearchIndex},
+	{"gen-signing-key", "gen-signing-key -id kid -out file [-alg ES256]", "write a new private key for auth.signing_keys", runGenSigningKey},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config file] <command> [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-20s %s\n  %-20s   %s\n", cmd.name, cmd.summary, "", cmd.usage)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func runCreateUser(cfg config.Config, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	username := flags.String("username", "", "username, 4 to 255 letters or digits")
+	email := flags.String("email", "", "email address, used to login")
+	password := flags.String("password", "", "password, at least 8 characters")
+	bio := flags.String("bio", "", "optional bio")
+	admin := flags.Bool("admin", false, "give the account the admin role")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	userModel, err := users.NewValidatedUserModel(*username, *email, *password)
+	if err != nil {
+		return err
+	}
+	userModel.Bio = *bio
+	// The operator vouches for the address
+	verifiedAt := time.Now()
+	userModel.EmailVerifiedAt = &verifiedAt
+	if *admin {
+		userModel.Role = users.RoleAdmin
+	}
+	if err := users.SaveOne(context.Background(), &userModel); err != nil {
+		return err
+	}
+	fmt.Printf("created user %d %s <%s> role=%s\n", userModel.ID, userModel.Username, userModel.Email, userModel.Role)
+	return nil
+}
+
+func runPromoteAdmin(cfg config.Config, db *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: promote-admin <username|email>")
+	}
+	userModel, err := findUser(args[0])
+	if err != nil {
+		return err
+	}
+	if err := userModel.Update(context.Background(), users.UserModel{Role: users.RoleAdmin}); err != nil {
+		return err
+	}
+	fmt.Printf("promoted user %d %s to %s\n", userModel.ID, userModel.Username, users.RoleAdmin)
+	return nil
+}
+
+func runUnlockUser(cfg config.Config, db *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: unlock-user <username|email>")
+	}
+	userModel, err := findUser(args[0])
+	if err != nil {
+		return err
+	}
+	if err := userModel.Unlock(context.Background()); err != nil {
+		return err
+	}
+	fmt.Printf("unlocked user %d %s\n", userModel.ID, userModel.Username)
+	return nil
+}
+
+func runDisableTwoFactor(cfg config.Config, db *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: disable-2fa <username|email>")
+	}
+	userModel, err := findUser(args[0])
+	if err != nil {
+		return err
+	}
+	if !userModel.TwoFactorEnabled() {
+		return fmt.Errorf("user %s has no two-factor authentication", userModel.Username)
+	}
+	if err := userModel.ResetTwoFactor(context.Background()); err != nil {
+		return err
+	}
+	fmt.Printf("disabled two-factor authentication of user %d %s\n", userModel.ID, userModel.Username)
+	return nil
+}
+
+// The account whose username, or else email, is `name`.
+func findUser(name string) (users.UserModel, error) {
+	userModel, err := users.FindOneUser(context.Background(), &users.UserModel{Username: name})
+	if err != nil {
+		userModel, err = users.FindOneUser(context.Background(), &users.UserModel{Email: name})
+	}
+	if err != nil {
+		return userModel, fmt.Errorf("no user with username or email %q", name)
+	}
+	return userModel, nil
+}
+
+func runRebuildSearchIndex(cfg config.Config, db *gorm.DB, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: rebuild-search-index")
+	}
+	count, err := articles.RebuildSearchIndex(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("indexed %d articles\n", count)
+	return nil
+}
+
+func runGenSigningKey(cfg config.Config, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("gen-signing-key", flag.ContinueOnError)
+	id := flags.String("id", "", "kid of the key, e.g. the month it starts signing")
+	alg := flags.String("alg", jwtkeys.ES256, "RS256, ES256 or EdDSA")
+	out := flags.String("out", "", "PEM file to create, readable by its owner only")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" || *out == "" {
+		return errors.New("usage: gen-signing-key -id kid -out file [-alg ES256]")
+	}
+
+	private, err := jwtkeys.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+	data, err := jwtkeys.MarshalPrivateKeyPEM(private)
+	if err != nil {
+		return err
+	}
+	// O_EXCL: never overwrite a key that may still be signing
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s key %q to %s, add it to auth.signing_keys:\n", *alg, *id, *out)
+	fmt.Printf("  - id: %q\n    algorithm: %s\n    private_key_file: %s\n", *id, *alg, *out)
+	return nil
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+var backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+var backgroundWG sync.WaitGroup
+
+// Start a goroutine which the server waits for on shutdown, before closing the database.
+// fn should return soon after ctx is cancelled.
+//
+//	common.RunBackground(func(ctx context.Context) { for { select { case <-ctx.Done(): return; ... } } })
+func RunBackground(fn func(ctx context.Context)) {
+	backgroundWG.Add(1)
+	go func() {
+		defer backgroundWG.Done()
+		fn(backgroundCtx)
+	}()
+}
+
+// Cancel every RunBackground goroutine and wait for them to return, giving up when ctx expires.
+func StopBackground(ctx context.Context) error {
+	cancelBackground()
+	done := make(chan struct{})
+	go func() {
+		backgroundWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
@@ -13,13 +14,25 @@ type Database struct {
 
 var DB *gorm.DB
 
+// The sqlite file and pool size used by Init, overwritten from the config at boot.
+var DBPath = "./../gorm.db"
+var DBMaxIdleConns = 10
+
+// Log every SQL statement at debug level, see GormLogger.
+var SQLLogMode = false
+
 // Opening a database and save the reference to `Database` struct.
 func Init() *gorm.DB {
-	db, err := gorm.Open("sqlite3", "./../gorm.db")
+	db, err := gorm.Open("sqlite3", DBPath)
 	if err != nil {
-		fmt.Println("db err: (Init) ", err)
+		Logger.Error("db err: (Init)", "path", DBPath, "error", err)
+	}
+	db.SetLogger(GormLogger{})
+	// Left untouched gorm still logs the errors, LogMode(false) would silence them too
+	if SQLLogMode {
+		db.LogMode(true)
 	}
-	db.DB().SetMaxIdleConns(10)
+	db.DB().SetMaxIdleConns(DBMaxIdleConns)
 	//db.LogMode(true)
 	DB = db
 	return DB
@@ -48,3 +61,14 @@ func TestDBFree(test_db *gorm.DB) error {
 func GetDB() *gorm.DB {
 	return DB
 }
+
+// The gorm setting GetDBContext stores the context in, read back by the tracing callbacks.
+const DBContextKey = "realworld:context"
+
+// Like GetDB, but the statements run through the returned connection belong to ctx,
+// so they are traced as children of the span it carries.
+//
+//	db := common.GetDBContext(ctx)
+func GetDBContext(ctx context.Context) *gorm.DB {
+	return DB.Set(DBContextKey, ctx)
+}
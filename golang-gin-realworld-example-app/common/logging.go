@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The structured logger of the process, replaced from the config at boot.
+var Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// The header a request id is read from and echoed back in, so a client or proxy can correlate its own logs.
+const RequestIDHeader = "X-Request-ID"
+
+// An incoming request id is only trusted when it's short and can't forge a log line.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type loggerKey struct{}
+
+// Build a slog logger writing `format` (json or text) at `level` (debug, info, warn, error).
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+func ContextWithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// The logger carried by ctx, or the process Logger when there's none.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return Logger
+}
+
+// The logger of the current request, every line it writes carries the request_id.
+//
+//	common.Log(c).Error("database error", "error", err)
+func Log(c *gin.Context) *slog.Logger {
+	return LoggerFrom(RequestContext(c))
+}
+
+// The context of the current request, carrying its logger and trace.
+// Falls back to context.Background() for a gin context without request, as built in tests.
+func RequestContext(c *gin.Context) context.Context {
+	if c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+func RequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// Only correlates the logs, a failed read leaves a zero id rather than failing the request
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Log a database error with the request id, a missing row is an expected outcome and isn't logged.
+//
+//	if err != nil { common.LogDBError(c, err); ... }
+func LogDBError(c *gin.Context, err error) {
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		Log(c).Error("database error", "route", c.FullPath(), "error", err.Error())
+	}
+}
+
+// Assign or propagate the X-Request-ID, put a request scoped logger in the request context
+// and write one access log line per request once the handlers are done.
+//
+//	r.Use(common.RequestLogger())
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		logger := Logger.With("request_id", requestID)
+		// Set when tracing.Middleware runs first, a log line can then be looked up from its trace
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			logger = logger.With("trace_id", span.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(ContextWithLogger(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if userID := c.GetUint("my_user_id"); userID != 0 {
+			attrs = append(attrs, "user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		logger.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Like gin.Recovery but the panic is logged as JSON with the request id.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		Log(c).Error("panic", "error", fmt.Sprint(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// Route the messages of gorm into Logger: errors always, statements at debug level when LogMode is on.
+type GormLogger struct{}
+
+func (GormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		return
+	}
+	switch values[0] {
+	case "sql":
+		if len(values) >= 6 {
+			Logger.Debug("sql", "source", values[1], "duration", values[2], "query", values[3], "rows", values[5])
+		}
+	case "error":
+		Logger.Error("database error", "source", values[1], "error", fmt.Sprint(values[2:]...))
+	case "info":
+		// Boot time chatter such as callback registration
+		Logger.Debug("gorm", "source", values[1], "message", fmt.Sprint(values[2:]...))
+	default:
+		if len(values) >= 3 {
+			if err, ok := values[2].(error); ok {
+				Logger.Error("database error", "source", values[1], "error", err.Error())
+				return
+			}
+		}
+		Logger.Info("gorm", "source", values[1], "message", fmt.Sprint(values[2:]...))
+	}
+}
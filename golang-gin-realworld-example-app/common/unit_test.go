@@ -2,6 +2,9 @@ package common
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -10,6 +13,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -169,6 +173,7 @@ func TestJWTTokenParsing(t *testing.T) {
 	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok {
 		assert.Equal(float64(userID), claims["id"], "Token should contain correct user ID")
 		assert.NotNil(claims["exp"], "Token should contain expiration time")
+		assert.InDelta(float64(time.Now().Unix()), claims["iat"], 60, "Token should contain its issue time")
 	} else {
 		t.Errorf("Claims should be of type jwt.MapClaims")
 	}
@@ -239,3 +244,50 @@ func TestUtilityFunctions(t *testing.T) {
 	str2 := RandString(10)
 	assert.NotEqual(str1, str2, "Multiple calls to RandString should return different strings")
 }
+
+func TestRequestLogger(t *testing.T) {
+	asserts := assert.New(t)
+	var buf bytes.Buffer
+	defer func(l *slog.Logger) { Logger = l }(Logger)
+	Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestLogger())
+	r.GET("/items/:id", func(c *gin.Context) {
+		c.Set("my_user_id", uint(7))
+		LogDBError(c, errors.New("disk I/O error"))
+		LogDBError(c, gorm.ErrRecordNotFound)
+		c.JSON(http.StatusNotFound, gin.H{})
+	})
+
+	// A valid incoming id is propagated
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/items/3", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	r.ServeHTTP(w, req)
+	asserts.Equal("abc-123", w.Header().Get(RequestIDHeader))
+
+	var lines []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		asserts.NoError(json.Unmarshal(line, &entry))
+		lines = append(lines, entry)
+	}
+	asserts.Len(lines, 2, "The missing row should not be logged")
+	asserts.Equal("database error", lines[0]["msg"])
+	asserts.Equal("abc-123", lines[0]["request_id"], "Handler logs should carry the request id")
+	asserts.Equal("request", lines[1]["msg"])
+	asserts.Equal("WARN", lines[1]["level"])
+	asserts.Equal("abc-123", lines[1]["request_id"])
+	asserts.Equal("/items/:id", lines[1]["route"])
+	asserts.Equal(float64(404), lines[1]["status"])
+	asserts.Equal(float64(7), lines[1]["user_id"])
+
+	// An unsafe incoming id is replaced
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/items/3", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n{\"level\":\"ERROR\"}")
+	r.ServeHTTP(w, req)
+	asserts.Regexp("^[0-9a-f]{32}$", w.Header().Get(RequestIDHeader))
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+
+	"realworld-backend/jwtkeys"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -24,20 +26,30 @@ func RandString(n int) string {
 	return string(b)
 }
 
-// Keep this two config private, it should not expose to open source
-const NBSecretPassword = "A String Very Very Very Strong!!@##$!@#$"
+// The JWT signing secret and token lifetime, overwritten from the config at boot.
+// Keep the production secret private, it should not expose to open source.
+var NBSecretPassword = "A String Very Very Very Strong!!@##$!@#$"
+var TokenLifetime = time.Hour * 24
+
+// The keys signing and verifying the tokens, rebuilt from the auth config at boot.
+// Until then only NBSecretPassword, signing the legacy HS256 tokens without kid.
+var Keys, _ = jwtkeys.NewManager(TokenLifetime, jwtkeys.NewHMACKey("", []byte(NBSecretPassword)))
+
+// Placeholder password meaning "unchanged" when a validator is filled with an existing user
 const NBRandomPassword = "A String Very Very Very Niubilty!!@##$!@#4"
 
 // A Util function to generate jwt_token which can be used in the request header
 func GenToken(id uint) string {
-	jwt_token := jwt.New(jwt.SigningMethodHS256)
-	// Set some claims
-	jwt_token.Claims = jwt.MapClaims{
+	now := time.Now()
+	// Sign with the current key of Keys, naming it in the kid header
+	token, err := Keys.Sign(jwt.MapClaims{
 		"id":  id,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(TokenLifetime).Unix(),
+	})
+	if err != nil {
+		Logger.Error("token signing failed", "error", err.Error())
 	}
-	// Sign and get the complete encoded token as a string
-	token, _ := jwt_token.SignedString([]byte(NBSecretPassword))
 	return token
 }
 
@@ -0,0 +1,547 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// The environment variable holding the path of an optional YAML or TOML config file.
+const ConfigFileEnv = "REALWORLD_CONFIG"
+
+// The JWT secret shipped with the source code, it's only acceptable outside of production.
+const DefaultJWTSecret = "A String Very Very Very Strong!!@##$!@#$"
+
+// Config is the typed view of every setting the server needs at boot time.
+//
+// Each leaf field can be set in the YAML file (`yaml` tag) and overridden by an
+// environment variable (`env` tag), the environment always wins.
+type Config struct {
+	Env       string          `yaml:"env" env:"REALWORLD_ENV"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Log       LogConfig       `yaml:"log"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Mail      MailConfig      `yaml:"mail"`
+	OIDC      OIDCConfig      `yaml:"oidc"`
+	Articles  ArticlesConfig  `yaml:"articles"`
+}
+
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"REALWORLD_ADDR"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"REALWORLD_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"REALWORLD_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"REALWORLD_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"REALWORLD_IDLE_TIMEOUT"`
+	// How long SIGINT/SIGTERM waits for in-flight requests and background work before giving up
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"REALWORLD_SHUTDOWN_TIMEOUT"`
+	// IPs or CIDRs of the reverse proxies whose X-Forwarded-For is believed. The rate limits and
+	// the lockout go by the client IP, anyone could pick theirs if every peer was trusted.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"REALWORLD_TRUSTED_PROXIES"`
+}
+
+type DatabaseConfig struct {
+	Path         string `yaml:"path" env:"REALWORLD_DB_PATH"`
+	MaxIdleConns int    `yaml:"max_idle_conns" env:"REALWORLD_DB_MAX_IDLE_CONNS"`
+	AutoMigrate  bool   `yaml:"auto_migrate" env:"REALWORLD_DB_AUTO_MIGRATE"`
+}
+
+type AuthConfig struct {
+	JWTSecret string `yaml:"jwt_secret" env:"REALWORLD_JWT_SECRET"`
+	// Lifetime of the JWT access tokens, keep it short: they can't be revoked
+	TokenLifetime time.Duration `yaml:"token_lifetime" env:"REALWORLD_TOKEN_LIFETIME"`
+	// Lifetime of the refresh tokens exchanged on POST /api/users/refresh
+	RefreshTokenLifetime time.Duration `yaml:"refresh_token_lifetime" env:"REALWORLD_REFRESH_TOKEN_LIFETIME"`
+	BcryptCost           int           `yaml:"bcrypt_cost" env:"REALWORLD_BCRYPT_COST"`
+	Lockout              LockoutConfig `yaml:"lockout"`
+	// Also set the access token in an HttpOnly session cookie, unsafe requests then need the CSRF token
+	CookieSessions bool `yaml:"cookie_sessions" env:"REALWORLD_COOKIE_SESSIONS"`
+	// How long the link of a password reset email can be used
+	PasswordResetLifetime time.Duration `yaml:"password_reset_lifetime" env:"REALWORLD_PASSWORD_RESET_LIFETIME"`
+	// How long the link of a verification email can be used
+	EmailVerificationLifetime time.Duration `yaml:"email_verification_lifetime" env:"REALWORLD_EMAIL_VERIFICATION_LIFETIME"`
+	// Refuse to create articles and comments until the email of the author is verified
+	RequireVerifiedEmail bool `yaml:"require_verified_email" env:"REALWORLD_REQUIRE_VERIFIED_EMAIL"`
+	// The issuer shown by the authenticator apps of the users with 2FA
+	TOTPIssuer string `yaml:"totp_issuer" env:"REALWORLD_TOTP_ISSUER"`
+	// How long the second step of a login with 2FA may take after the password
+	LoginChallengeLifetime time.Duration `yaml:"login_challenge_lifetime" env:"REALWORLD_LOGIN_CHALLENGE_LIFETIME"`
+	// Accept the token from the access_token query parameter and form field, they leak into logs
+	QueryTokens bool `yaml:"query_tokens" env:"REALWORLD_QUERY_TOKENS"`
+	// Keys signing the access tokens besides jwt_secret, published on /.well-known/jwks.json
+	SigningKeys []SigningKeyConfig `yaml:"signing_keys"`
+	// When jwt_secret stops signing, it still verifies for key_grace_period: zero keeps it forever
+	LegacySecretNotAfter time.Time `yaml:"legacy_secret_not_after"`
+	// How long a key still verifies tokens once it stopped signing, at least token_lifetime
+	KeyGracePeriod time.Duration `yaml:"key_grace_period" env:"REALWORLD_KEY_GRACE_PERIOD"`
+}
+
+// A key signing the access tokens from NotBefore until NotAfter, both optional.
+// The most recent active key signs, jwt_secret only when none is active.
+type SigningKeyConfig struct {
+	// The kid header of the tokens it signs
+	ID string `yaml:"id"`
+	// HS256, RS256, ES256 or EdDSA
+	Algorithm string `yaml:"algorithm"`
+	// PEM private key of the asymmetric algorithms, see the gen-signing-key command
+	PrivateKeyFile string `yaml:"private_key_file"`
+	// Shared secret of HS256, never published
+	Secret    string    `yaml:"secret"`
+	NotBefore time.Time `yaml:"not_before"`
+	NotAfter  time.Time `yaml:"not_after"`
+}
+
+// See users.LockoutPolicy.
+type LockoutConfig struct {
+	MaxFailures   int           `yaml:"max_failures" env:"REALWORLD_LOCKOUT_MAX_FAILURES"`
+	LockDuration  time.Duration `yaml:"lock_duration" env:"REALWORLD_LOCKOUT_DURATION"`
+	BaseDelay     time.Duration `yaml:"base_delay" env:"REALWORLD_LOCKOUT_BASE_DELAY"`
+	MaxDelay      time.Duration `yaml:"max_delay" env:"REALWORLD_LOCKOUT_MAX_DELAY"`
+	IPMaxFailures int           `yaml:"ip_max_failures" env:"REALWORLD_LOCKOUT_IP_MAX_FAILURES"`
+	IPWindow      time.Duration `yaml:"ip_window" env:"REALWORLD_LOCKOUT_IP_WINDOW"`
+}
+
+type CORSConfig struct {
+	AllowOrigins []string `yaml:"allow_origins" env:"REALWORLD_CORS_ORIGINS"`
+}
+
+type MetricsConfig struct {
+	// Serve the Prometheus metrics on /metrics
+	Enabled bool `yaml:"enabled" env:"REALWORLD_METRICS_ENABLED"`
+}
+
+type TracingConfig struct {
+	// none, stdout, file or any exporter added with tracing.RegisterExporter
+	Exporter string `yaml:"exporter" env:"REALWORLD_TRACING_EXPORTER"`
+	// Where the file exporter appends its spans
+	File        string `yaml:"file" env:"REALWORLD_TRACING_FILE"`
+	ServiceName string `yaml:"service_name" env:"REALWORLD_TRACING_SERVICE_NAME"`
+	// The share of new traces recorded, between 0 and 1
+	SampleRatio float64 `yaml:"sample_ratio" env:"REALWORLD_TRACING_SAMPLE_RATIO"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" env:"REALWORLD_RATE_LIMIT_ENABLED"`
+	// Login and registration, per client IP
+	AuthRequests int           `yaml:"auth_requests" env:"REALWORLD_RATE_LIMIT_AUTH_REQUESTS"`
+	AuthPeriod   time.Duration `yaml:"auth_period" env:"REALWORLD_RATE_LIMIT_AUTH_PERIOD"`
+	// Every authenticated POST, PUT and DELETE, per user
+	WriteRequests int           `yaml:"write_requests" env:"REALWORLD_RATE_LIMIT_WRITE_REQUESTS"`
+	WritePeriod   time.Duration `yaml:"write_period" env:"REALWORLD_RATE_LIMIT_WRITE_PERIOD"`
+}
+
+type MailConfig struct {
+	// smtp, or file writing every message to outbox_dir instead of sending it
+	Transport string `yaml:"transport" env:"REALWORLD_MAIL_TRANSPORT"`
+	From      string `yaml:"from" env:"REALWORLD_MAIL_FROM"`
+	OutboxDir string `yaml:"outbox_dir" env:"REALWORLD_MAIL_OUTBOX_DIR"`
+	SMTPHost  string `yaml:"smtp_host" env:"REALWORLD_SMTP_HOST"`
+	SMTPPort  int    `yaml:"smtp_port" env:"REALWORLD_SMTP_PORT"`
+	// Leave the username empty for a relay that doesn't authenticate
+	SMTPUsername string `yaml:"smtp_username" env:"REALWORLD_SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"REALWORLD_SMTP_PASSWORD"`
+	// The frontend the links of the messages point to
+	AppURL string `yaml:"app_url" env:"REALWORLD_APP_URL"`
+}
+
+// The names of the providers, safe in a URL path.
+var providerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+type OIDCConfig struct {
+	// Create an account on the first login of an identity whose verified email is unknown
+	AllowSignup bool `yaml:"allow_signup" env:"REALWORLD_OIDC_ALLOW_SIGNUP"`
+	// The OpenID providers users can log in with, none by default
+	Providers []OIDCProviderConfig `yaml:"providers"`
+}
+
+// An OpenID Connect provider, its endpoints and keys are read from its discovery document.
+type OIDCProviderConfig struct {
+	// The name in the API paths, /api/users/oidc/<name>
+	Name string `yaml:"name"`
+	// Serves <issuer>/.well-known/openid-configuration, https outside of development
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// The page of the frontend the provider sends the users back to, registered at the provider
+	RedirectURL string `yaml:"redirect_url"`
+	// openid, email and profile when empty
+	Scopes []string `yaml:"scopes"`
+}
+
+type ArticlesConfig struct {
+	// How often the scheduled articles whose time has come are published
+	SchedulerInterval time.Duration `yaml:"scheduler_interval" env:"REALWORLD_ARTICLES_SCHEDULER_INTERVAL"`
+	// How many article and comment bodies rendered to HTML are kept in memory, 0 disables the cache
+	MarkdownCacheSize int `yaml:"markdown_cache_size" env:"REALWORLD_ARTICLES_MARKDOWN_CACHE_SIZE"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" env:"REALWORLD_LOG_LEVEL"`
+	Format string `yaml:"format" env:"REALWORLD_LOG_FORMAT"`
+}
+
+// Default returns the settings the project used before it was configurable,
+// so a bare `go run .` keeps behaving the same way.
+func Default() Config {
+	return Config{
+		Env: "development",
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Path:         "./../gorm.db",
+			MaxIdleConns: 10,
+			AutoMigrate:  true,
+		},
+		Auth: AuthConfig{
+			JWTSecret:                 DefaultJWTSecret,
+			TokenLifetime:             15 * time.Minute,
+			RefreshTokenLifetime:      30 * 24 * time.Hour,
+			BcryptCost:                10,
+			KeyGracePeriod:            time.Hour,
+			QueryTokens:               true,
+			PasswordResetLifetime:     time.Hour,
+			EmailVerificationLifetime: 48 * time.Hour,
+			TOTPIssuer:                "RealWorld",
+			LoginChallengeLifetime:    5 * time.Minute,
+			Lockout: LockoutConfig{
+				MaxFailures:   5,
+				LockDuration:  15 * time.Minute,
+				BaseDelay:     time.Second,
+				MaxDelay:      30 * time.Second,
+				IPMaxFailures: 20,
+				IPWindow:      15 * time.Minute,
+			},
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"http://localhost:4100"},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			ServiceName: "realworld-backend",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:       true,
+			AuthRequests:  10,
+			AuthPeriod:    time.Minute,
+			WriteRequests: 60,
+			WritePeriod:   time.Minute,
+		},
+		Mail: MailConfig{
+			Transport: "file",
+			From:      "RealWorld <noreply@realworld.local>",
+			OutboxDir: "./outbox",
+			SMTPPort:  587,
+			AppURL:    "http://localhost:4100",
+		},
+		OIDC: OIDCConfig{
+			AllowSignup: true,
+		},
+		Articles: ArticlesConfig{
+			SchedulerInterval: time.Minute,
+			MarkdownCacheSize: 1024,
+		},
+	}
+}
+
+// Load builds the Config from the defaults, the file at `path` (skipped when empty), TOML
+// when it ends with .toml else YAML, and the environment, then validates the result.
+//
+//	cfg, err := config.Load(os.Getenv(config.ConfigFileEnv))
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("config: read %s: %w", path, err)
+		}
+		if strings.EqualFold(filepath.Ext(path), ".toml") {
+			if content, err = tomlToYAML(content); err != nil {
+				return cfg, fmt.Errorf("config: parse %s: %w", path, err)
+			}
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("config: parse %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// The same document as YAML, so the yaml tags and decoding of the fields serve both formats.
+func tomlToYAML(content []byte) ([]byte, error) {
+	var document map[string]interface{}
+	if err := toml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(document)
+}
+
+func (c Config) IsProduction() bool {
+	return c.Env == "production"
+}
+
+// Validate reports every invalid setting at once so a broken deploy can be fixed in one go.
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Env {
+	case "development", "test", "production":
+	default:
+		errs = append(errs, fmt.Errorf("env must be one of development, test, production, got %q", c.Env))
+	}
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr must not be empty"))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.ReadHeaderTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts must not be negative, use 0 for no timeout"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if c.Database.Path == "" {
+		errs = append(errs, errors.New("database.path must not be empty"))
+	}
+	if c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_idle_conns must not be negative"))
+	}
+	if len(c.Auth.JWTSecret) < 16 {
+		errs = append(errs, errors.New("auth.jwt_secret must be at least 16 characters"))
+	}
+	if c.IsProduction() && c.Auth.JWTSecret == DefaultJWTSecret {
+		errs = append(errs, errors.New("auth.jwt_secret must be changed in production"))
+	}
+	if c.Auth.TokenLifetime <= 0 {
+		errs = append(errs, errors.New("auth.token_lifetime must be positive"))
+	}
+	if c.Auth.RefreshTokenLifetime < c.Auth.TokenLifetime {
+		errs = append(errs, errors.New("auth.refresh_token_lifetime must not be shorter than auth.token_lifetime"))
+	}
+	// bcrypt only accepts a cost between 4 and 31
+	if c.Auth.BcryptCost < 4 || c.Auth.BcryptCost > 31 {
+		errs = append(errs, fmt.Errorf("auth.bcrypt_cost must be between 4 and 31, got %d", c.Auth.BcryptCost))
+	}
+	if l := c.Auth.Lockout; l.MaxFailures <= 0 || l.IPMaxFailures <= 0 || l.LockDuration <= 0 || l.IPWindow <= 0 {
+		errs = append(errs, errors.New("auth.lockout failures and durations must be positive"))
+	}
+	if l := c.Auth.Lockout; l.BaseDelay < 0 || l.MaxDelay < l.BaseDelay {
+		errs = append(errs, errors.New("auth.lockout.base_delay must not be negative nor above max_delay"))
+	}
+	if c.Auth.KeyGracePeriod < c.Auth.TokenLifetime {
+		errs = append(errs, errors.New("auth.key_grace_period must not be shorter than auth.token_lifetime"))
+	}
+	if !c.Auth.LegacySecretNotAfter.IsZero() && len(c.Auth.SigningKeys) == 0 {
+		errs = append(errs, errors.New("auth.legacy_secret_not_after needs auth.signing_keys to sign after it"))
+	}
+	kids := map[string]bool{}
+	for i, key := range c.Auth.SigningKeys {
+		if key.ID == "" || kids[key.ID] {
+			errs = append(errs, fmt.Errorf("auth.signing_keys[%d].id must be set and unique, got %q", i, key.ID))
+		}
+		kids[key.ID] = true
+		switch key.Algorithm {
+		case "HS256":
+			if len(key.Secret) < 32 || key.PrivateKeyFile != "" {
+				errs = append(errs, fmt.Errorf("auth.signing_keys[%d]: HS256 needs a secret of at least 32 characters and no private_key_file", i))
+			}
+		case "RS256", "ES256", "EdDSA":
+			if key.PrivateKeyFile == "" || key.Secret != "" {
+				errs = append(errs, fmt.Errorf("auth.signing_keys[%d]: %s needs a private_key_file and no secret", i, key.Algorithm))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("auth.signing_keys[%d].algorithm must be one of HS256, RS256, ES256, EdDSA, got %q", i, key.Algorithm))
+		}
+		if !key.NotBefore.IsZero() && !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			errs = append(errs, fmt.Errorf("auth.signing_keys[%d].not_after must be after not_before", i))
+		}
+	}
+	for i, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies[%d] must be an IP or a CIDR, got %q", i, proxy))
+		}
+	}
+	if len(c.CORS.AllowOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allow_origins must contain at least one origin"))
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level must be one of debug, info, warn, error, got %q", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log.format must be json or text, got %q", c.Log.Format))
+	}
+	if c.Tracing.Exporter == "" {
+		errs = append(errs, errors.New("tracing.exporter must not be empty, use none to disable tracing"))
+	}
+	if c.Tracing.Exporter == "file" && c.Tracing.File == "" {
+		errs = append(errs, errors.New("tracing.file must not be empty with the file exporter"))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.AuthRequests <= 0 || c.RateLimit.WriteRequests <= 0 {
+			errs = append(errs, errors.New("rate_limit requests must be positive"))
+		}
+		if c.RateLimit.AuthPeriod <= 0 || c.RateLimit.WritePeriod <= 0 {
+			errs = append(errs, errors.New("rate_limit periods must be positive"))
+		}
+	}
+	if c.Auth.PasswordResetLifetime <= 0 || c.Auth.EmailVerificationLifetime <= 0 {
+		errs = append(errs, errors.New("auth.password_reset_lifetime and auth.email_verification_lifetime must be positive"))
+	}
+	if c.Auth.TOTPIssuer == "" || strings.Contains(c.Auth.TOTPIssuer, ":") {
+		errs = append(errs, errors.New("auth.totp_issuer must be set, without colon"))
+	}
+	if c.Auth.LoginChallengeLifetime <= 0 {
+		errs = append(errs, errors.New("auth.login_challenge_lifetime must be positive"))
+	}
+	switch c.Mail.Transport {
+	case "file":
+		if c.Mail.OutboxDir == "" {
+			errs = append(errs, errors.New("mail.outbox_dir must not be empty with the file transport"))
+		}
+	case "smtp":
+		if c.Mail.SMTPHost == "" || c.Mail.SMTPPort <= 0 || c.Mail.SMTPPort > 65535 {
+			errs = append(errs, errors.New("mail.smtp_host and a valid mail.smtp_port are required with the smtp transport"))
+		}
+	case "":
+		errs = append(errs, errors.New("mail.transport must not be empty"))
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from must be an email address: %w", err))
+	}
+	if c.Mail.AppURL == "" {
+		errs = append(errs, errors.New("mail.app_url must not be empty"))
+	}
+	if c.Articles.SchedulerInterval <= 0 {
+		errs = append(errs, errors.New("articles.scheduler_interval must be positive"))
+	}
+	if c.Articles.MarkdownCacheSize < 0 {
+		errs = append(errs, errors.New("articles.markdown_cache_size must not be negative"))
+	}
+	providers := map[string]bool{}
+	for i, provider := range c.OIDC.Providers {
+		if !providerName.MatchString(provider.Name) || providers[provider.Name] {
+			errs = append(errs, fmt.Errorf("oidc.providers[%d].name must be unique, lower case letters, digits and dashes, got %q", i, provider.Name))
+		}
+		providers[provider.Name] = true
+		issuer, err := url.Parse(provider.Issuer)
+		if err != nil || issuer.Host == "" || (issuer.Scheme != "https" && (issuer.Scheme != "http" || c.IsProduction())) {
+			errs = append(errs, fmt.Errorf("oidc.providers[%d].issuer must be an https URL, got %q", i, provider.Issuer))
+		}
+		if provider.ClientID == "" || provider.RedirectURL == "" {
+			errs = append(errs, fmt.Errorf("oidc.providers[%d]: client_id and redirect_url must be set", i))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config: %w", errors.Join(errs...))
+}
+
+// Walk the struct and overwrite every field carrying an `env` tag whose variable is set.
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		// Comma separated list: "http://a.com, http://b.com"
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+The config module containing the typed settings of the server.
+
+config.go: defaults, YAML or TOML file loading, environment overrides and validation
+
+Every setting has a default, can be put in a YAML file pointed by REALWORLD_CONFIG,
+or a TOML one when its name ends with .toml, and overridden by its own environment
+variable, e.g.
+
+	REALWORLD_ENV=production
+	REALWORLD_ADDR=:8080
+	REALWORLD_SHUTDOWN_TIMEOUT=30s
+	REALWORLD_TRUSTED_PROXIES=10.0.0.0/8,192.168.1.10
+	REALWORLD_DB_PATH=/var/lib/realworld/gorm.db
+	REALWORLD_DB_AUTO_MIGRATE=false
+	REALWORLD_JWT_SECRET=...
+	REALWORLD_TOKEN_LIFETIME=15m
+	REALWORLD_REFRESH_TOKEN_LIFETIME=720h
+	REALWORLD_BCRYPT_COST=12
+	REALWORLD_KEY_GRACE_PERIOD=1h
+	REALWORLD_COOKIE_SESSIONS=true
+	REALWORLD_QUERY_TOKENS=false
+	REALWORLD_PASSWORD_RESET_LIFETIME=30m
+	REALWORLD_EMAIL_VERIFICATION_LIFETIME=24h
+	REALWORLD_REQUIRE_VERIFIED_EMAIL=true
+	REALWORLD_TOTP_ISSUER=RealWorld
+	REALWORLD_LOGIN_CHALLENGE_LIFETIME=5m
+	REALWORLD_LOCKOUT_MAX_FAILURES=5
+	REALWORLD_LOCKOUT_DURATION=15m
+	REALWORLD_CORS_ORIGINS=https://a.example.com,https://b.example.com
+	REALWORLD_LOG_LEVEL=debug
+	REALWORLD_LOG_FORMAT=text
+	REALWORLD_TRACING_EXPORTER=file
+	REALWORLD_TRACING_FILE=/var/log/realworld/traces.jsonl
+	REALWORLD_TRACING_SAMPLE_RATIO=0.1
+	REALWORLD_RATE_LIMIT_AUTH_REQUESTS=5
+	REALWORLD_RATE_LIMIT_AUTH_PERIOD=1m
+	REALWORLD_MAIL_TRANSPORT=smtp
+	REALWORLD_SMTP_HOST=smtp.example.com
+	REALWORLD_SMTP_PASSWORD=...
+	REALWORLD_APP_URL=https://realworld.example.com
+	REALWORLD_OIDC_ALLOW_SIGNUP=false
+	REALWORLD_ARTICLES_SCHEDULER_INTERVAL=30s
+	REALWORLD_ARTICLES_MARKDOWN_CACHE_SIZE=4096
+
+Lists of structs, like auth.signing_keys and oidc.providers, and the times, like
+auth.legacy_secret_not_after, can only be set in the config file.
+*/
+package config
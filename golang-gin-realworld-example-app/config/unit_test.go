@@ -0,0 +1,269 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := Default()
+	asserts.NoError(cfg.Validate(), "Default config should be valid")
+	asserts.Equal(":8080", cfg.Server.Addr)
+	asserts.Equal("./../gorm.db", cfg.Database.Path)
+	asserts.Equal(15*time.Minute, cfg.Auth.TokenLifetime)
+	asserts.Equal([]string{"http://localhost:4100"}, cfg.CORS.AllowOrigins)
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	asserts := assert.New(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+server:
+  addr: ":9090"
+database:
+  path: /tmp/realworld.db
+auth:
+  token_lifetime: 1h
+  bcrypt_cost: 4
+cors:
+  allow_origins: ["https://a.example.com"]
+`
+	asserts.NoError(os.WriteFile(path, []byte(content), 0644))
+
+	cfg, err := Load(path)
+	asserts.NoError(err)
+	asserts.Equal(":9090", cfg.Server.Addr)
+	asserts.Equal("/tmp/realworld.db", cfg.Database.Path)
+	asserts.Equal(time.Hour, cfg.Auth.TokenLifetime)
+	asserts.Equal(4, cfg.Auth.BcryptCost)
+	asserts.Equal(10, cfg.Database.MaxIdleConns, "Unset keys should keep their default")
+
+	// Environment overrides the file
+	t.Setenv("REALWORLD_ADDR", ":7070")
+	t.Setenv("REALWORLD_TOKEN_LIFETIME", "15m")
+	t.Setenv("REALWORLD_CORS_ORIGINS", "https://a.example.com, https://b.example.com")
+	cfg, err = Load(path)
+	asserts.NoError(err)
+	asserts.Equal(":7070", cfg.Server.Addr)
+	asserts.Equal(15*time.Minute, cfg.Auth.TokenLifetime)
+	asserts.Equal([]string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.AllowOrigins)
+
+	t.Setenv("REALWORLD_TRACING_SAMPLE_RATIO", "0.25")
+	cfg, err = Load(path)
+	asserts.NoError(err)
+	asserts.Equal(0.25, cfg.Tracing.SampleRatio)
+
+	asserts.True(cfg.Auth.QueryTokens, "Query tokens should stay accepted by default")
+	t.Setenv("REALWORLD_QUERY_TOKENS", "false")
+	t.Setenv("REALWORLD_COOKIE_SESSIONS", "true")
+	cfg, err = Load(path)
+	asserts.NoError(err)
+	asserts.False(cfg.Auth.QueryTokens)
+	asserts.True(cfg.Auth.CookieSessions)
+
+	t.Setenv("REALWORLD_TOTP_ISSUER", "Acme: Blog")
+	_, err = Load(path)
+	asserts.ErrorContains(err, "auth.totp_issuer")
+	t.Setenv("REALWORLD_TOTP_ISSUER", "Acme Blog")
+	t.Setenv("REALWORLD_LOGIN_CHALLENGE_LIFETIME", "2m")
+	cfg, err = Load(path)
+	asserts.NoError(err)
+	asserts.Equal("Acme Blog", cfg.Auth.TOTPIssuer)
+	asserts.Equal(2*time.Minute, cfg.Auth.LoginChallengeLifetime)
+
+	asserts.Empty(cfg.Server.TrustedProxies, "No proxy should be trusted by default")
+	t.Setenv("REALWORLD_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.10")
+	cfg, err = Load(path)
+	asserts.NoError(err)
+	asserts.Equal([]string{"10.0.0.0/8", "192.168.1.10"}, cfg.Server.TrustedProxies)
+	t.Setenv("REALWORLD_TRUSTED_PROXIES", "proxy.internal")
+	_, err = Load(path)
+	asserts.ErrorContains(err, "server.trusted_proxies[0]")
+	t.Setenv("REALWORLD_TRUSTED_PROXIES", "")
+
+	t.Setenv("REALWORLD_TRACING_SAMPLE_RATIO", "2")
+	_, err = Load(path)
+	asserts.ErrorContains(err, "tracing.sample_ratio")
+}
+
+func TestLoadErrors(t *testing.T) {
+	asserts := assert.New(t)
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	asserts.Error(err, "Missing config file should fail")
+
+	t.Setenv("REALWORLD_BCRYPT_COST", "not-a-number")
+	_, err = Load("")
+	asserts.ErrorContains(err, "REALWORLD_BCRYPT_COST")
+
+	t.Setenv("REALWORLD_BCRYPT_COST", "64")
+	_, err = Load("")
+	asserts.ErrorContains(err, "bcrypt_cost")
+
+	t.Setenv("REALWORLD_BCRYPT_COST", "10")
+	t.Setenv("REALWORLD_ENV", "production")
+	_, err = Load("")
+	asserts.ErrorContains(err, "must be changed in production")
+
+	t.Setenv("REALWORLD_JWT_SECRET", "a-production-secret-of-some-length")
+	t.Setenv("REALWORLD_ARTICLES_SCHEDULER_INTERVAL", "0s")
+	_, err = Load("")
+	asserts.ErrorContains(err, "articles.scheduler_interval")
+
+	t.Setenv("REALWORLD_ARTICLES_SCHEDULER_INTERVAL", "30s")
+	cfg, err := Load("")
+	asserts.NoError(err)
+	asserts.True(cfg.IsProduction())
+	asserts.Equal(30*time.Second, cfg.Articles.SchedulerInterval)
+
+	t.Setenv("REALWORLD_ARTICLES_MARKDOWN_CACHE_SIZE", "-1")
+	_, err = Load("")
+	asserts.ErrorContains(err, "articles.markdown_cache_size")
+}
+
+func TestLoadTOML(t *testing.T) {
+	asserts := assert.New(t)
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+env = "production"
+
+[server]
+addr = ":9090"
+trusted_proxies = ["10.0.0.0/8"]
+
+[auth]
+jwt_secret = "a production secret of the tests"
+token_lifetime = "1h"
+bcrypt_cost = 4
+legacy_secret_not_after = 2026-10-15T00:00:00Z
+
+[[auth.signing_keys]]
+id = "2026-10"
+algorithm = "EdDSA"
+private_key_file = "/etc/realworld/keys/2026-10.pem"
+
+[auth.lockout]
+max_failures = 3
+
+[tracing]
+sample_ratio = 0.5
+`
+	asserts.NoError(os.WriteFile(path, []byte(content), 0o600))
+	cfg, err := Load(path)
+	asserts.NoError(err)
+	asserts.True(cfg.IsProduction())
+	asserts.Equal(":9090", cfg.Server.Addr)
+	asserts.Equal([]string{"10.0.0.0/8"}, cfg.Server.TrustedProxies)
+	asserts.Equal(time.Hour, cfg.Auth.TokenLifetime)
+	asserts.Equal(4, cfg.Auth.BcryptCost)
+	asserts.Equal(time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), cfg.Auth.LegacySecretNotAfter.UTC())
+	if asserts.Len(cfg.Auth.SigningKeys, 1) {
+		asserts.Equal("EdDSA", cfg.Auth.SigningKeys[0].Algorithm)
+	}
+	asserts.Equal(3, cfg.Auth.Lockout.MaxFailures)
+	asserts.Equal(15*time.Minute, cfg.Auth.Lockout.LockDuration, "Unset keys should keep their default")
+	asserts.Equal(0.5, cfg.Tracing.SampleRatio)
+
+	asserts.NoError(os.WriteFile(path, []byte("[server\naddr = 1"), 0o600))
+	_, err = Load(path)
+	asserts.ErrorContains(err, "config: parse")
+}
+
+func TestSigningKeys(t *testing.T) {
+	asserts := assert.New(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+auth:
+  signing_keys:
+    - id: "2026-10"
+      algorithm: ES256
+      private_key_file: /etc/realworld/keys/2026-10.pem
+      not_before: 2026-10-01T00:00:00Z
+      not_after: 2026-11-01T00:00:00Z
+  legacy_secret_not_after: 2026-10-15T00:00:00Z
+`
+	asserts.NoError(os.WriteFile(path, []byte(content), 0o600))
+	cfg, err := Load(path)
+	asserts.NoError(err)
+	if asserts.Len(cfg.Auth.SigningKeys, 1) {
+		key := cfg.Auth.SigningKeys[0]
+		asserts.Equal("ES256", key.Algorithm)
+		asserts.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), key.NotAfter.UTC())
+	}
+	asserts.Equal(time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), cfg.Auth.LegacySecretNotAfter.UTC())
+
+	cfg = Default()
+	cfg.Auth.LegacySecretNotAfter = time.Now()
+	asserts.ErrorContains(cfg.Validate(), "auth.legacy_secret_not_after needs auth.signing_keys")
+
+	cfg = Default()
+	cfg.Auth.SigningKeys = []SigningKeyConfig{
+		{ID: "a", Algorithm: "HS256", Secret: "short"},
+		{ID: "a", Algorithm: "RS512", PrivateKeyFile: "a.pem"},
+		{ID: "b", Algorithm: "EdDSA", PrivateKeyFile: "b.pem", NotBefore: time.Now(), NotAfter: time.Now().Add(-time.Hour)},
+	}
+	cfg.Auth.KeyGracePeriod = time.Minute
+	err = cfg.Validate()
+	asserts.ErrorContains(err, "HS256 needs a secret")
+	asserts.ErrorContains(err, `auth.signing_keys[1].id must be set and unique, got "a"`)
+	asserts.ErrorContains(err, `got "RS512"`)
+	asserts.ErrorContains(err, "auth.signing_keys[2].not_after must be after not_before")
+	asserts.ErrorContains(err, "auth.key_grace_period")
+}
+
+func TestMailConfig(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := Default()
+	asserts.Equal("file", cfg.Mail.Transport)
+
+	cfg.Mail.Transport = "smtp"
+	cfg.Mail.From = "not an address"
+	err := cfg.Validate()
+	asserts.ErrorContains(err, "mail.smtp_host")
+	asserts.ErrorContains(err, "mail.from")
+
+	t.Setenv("REALWORLD_MAIL_TRANSPORT", "smtp")
+	t.Setenv("REALWORLD_SMTP_HOST", "smtp.example.com")
+	t.Setenv("REALWORLD_SMTP_PORT", "2525")
+	cfg, err = Load("")
+	asserts.NoError(err)
+	asserts.Equal(2525, cfg.Mail.SMTPPort)
+}
+
+func TestOIDCProviders(t *testing.T) {
+	asserts := assert.New(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+oidc:
+  providers:
+    - name: corp
+      issuer: https://login.example.com
+      client_id: realworld
+      redirect_url: http://localhost:4100/oidc/corp
+`
+	asserts.NoError(os.WriteFile(path, []byte(content), 0o600))
+	cfg, err := Load(path)
+	asserts.NoError(err)
+	asserts.True(cfg.OIDC.AllowSignup)
+	if asserts.Len(cfg.OIDC.Providers, 1) {
+		asserts.Equal("realworld", cfg.OIDC.Providers[0].ClientID)
+	}
+
+	cfg.OIDC.Providers = append(cfg.OIDC.Providers,
+		OIDCProviderConfig{Name: "corp", Issuer: "https://other.example.com", ClientID: "a", RedirectURL: "http://localhost:4100"},
+		OIDCProviderConfig{Name: "Dev IdP", Issuer: "http://localhost:5556", ClientID: "a"},
+	)
+	err = cfg.Validate()
+	asserts.ErrorContains(err, `oidc.providers[1].name must be unique`)
+	asserts.ErrorContains(err, `oidc.providers[2].name`)
+	asserts.ErrorContains(err, "oidc.providers[2]: client_id and redirect_url")
+	asserts.NotContains(err.Error(), "oidc.providers[2].issuer", "http should be fine in development")
+
+	cfg.Env, cfg.Auth.JWTSecret = "production", "a-production-secret-of-some-length"
+	asserts.ErrorContains(cfg.Validate(), "oidc.providers[2].issuer must be an https URL")
+}
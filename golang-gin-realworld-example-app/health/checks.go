@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"realworld-backend/common"
+	"realworld-backend/migrations"
+)
+
+// A named dependency check run by /readyz, it should respect the deadline of ctx.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// The checks /readyz runs in order, other packages can append their own at boot.
+var ReadinessChecks = []Check{
+	{"database", checkDatabase},
+	{"migrations", checkMigrations},
+	{"disk", checkDiskWritable},
+}
+
+func checkDatabase(ctx context.Context) error {
+	db := common.GetDB()
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return db.DB().PingContext(ctx)
+}
+
+func checkMigrations(ctx context.Context) error {
+	pending, err := migrations.Pending(common.GetDB())
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		var names []string
+		for _, m := range pending {
+			names = append(names, m.String())
+		}
+		return fmt.Errorf("pending migrations: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// SQLite needs to create its journal next to the database file, so the directory must be writable.
+func checkDiskWritable(ctx context.Context) error {
+	f, err := os.CreateTemp(filepath.Dir(common.DBPath), ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,8 @@
+/*
+The health module containing the probes of the orchestrator.
+
+checks.go: the readiness checks (database ping, pending migrations, writable sqlite directory)
+
+routers.go: /healthz (process alive) and /readyz (every check passing) with their JSON schema
+*/
+package health
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The longest a single readiness check may take before it's reported as failed.
+var CheckTimeout = 2 * time.Second
+
+func HealthRegister(router *gin.RouterGroup) {
+	router.GET("/healthz", Liveness)
+	router.GET("/readyz", Readiness)
+}
+
+type CheckResponse struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                   `json:"status"`
+	Checks map[string]CheckResponse `json:"checks"`
+}
+
+// The process is up and serving, nothing else is checked so a slow database never gets the instance killed.
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok", Checks: map[string]CheckResponse{}})
+}
+
+// Every ReadinessChecks must pass, otherwise 503 tells the orchestrator to stop routing here.
+func Readiness(c *gin.Context) {
+	response := HealthResponse{Status: "ok", Checks: map[string]CheckResponse{}}
+	for _, check := range ReadinessChecks {
+		result := runCheck(c.Request.Context(), check)
+		if result.Status != "ok" {
+			response.Status = "fail"
+		}
+		response.Checks[check.Name] = result
+	}
+	code := http.StatusOK
+	if response.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, response)
+}
+
+func runCheck(parent context.Context, check Check) CheckResponse {
+	ctx, cancel := context.WithTimeout(parent, CheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResponse{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
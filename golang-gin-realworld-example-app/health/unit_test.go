@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/common"
+	"realworld-backend/migrations"
+)
+
+func probe(r *gin.Engine, url string) (int, HealthResponse) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, nil)
+	r.ServeHTTP(w, req)
+	var response HealthResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestProbes(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	HealthRegister(r.Group(""))
+
+	db := common.TestDBInit()
+	db.LogMode(false)
+	common.DBPath = "./../gorm_test.db"
+	defer common.TestDBFree(db)
+
+	code, response := probe(r, "/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, code, "Unmigrated database should not be ready")
+	asserts.Equal("fail", response.Checks["migrations"].Status)
+	asserts.Contains(response.Checks["migrations"].Error, "0001_initial_schema")
+	asserts.Equal("ok", response.Checks["database"].Status)
+
+	_, err := migrations.Up(db)
+	asserts.NoError(err)
+	code, response = probe(r, "/readyz")
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal("ok", response.Status)
+	for _, name := range []string{"database", "migrations", "disk"} {
+		asserts.Equal("ok", response.Checks[name].Status, name)
+	}
+
+	common.DBPath = "/nonexistent/dir/gorm.db"
+	code, response = probe(r, "/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, code)
+	asserts.Equal("fail", response.Checks["disk"].Status)
+	common.DBPath = "./../gorm_test.db"
+
+	db.Close()
+	code, response = probe(r, "/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, code)
+	asserts.Equal("fail", response.Checks["database"].Status)
+
+	code, response = probe(r, "/healthz")
+	asserts.Equal(http.StatusOK, code, "Liveness should not depend on the database")
+	asserts.Equal("ok", response.Status)
+}
+
+func TestSlowCheckTimesOut(t *testing.T) {
+	asserts := assert.New(t)
+	defer func(timeout time.Duration) { CheckTimeout = timeout }(CheckTimeout)
+	CheckTimeout = 50 * time.Millisecond
+
+	result := runCheck(context.Background(), Check{"slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}})
+	asserts.Equal("fail", result.Status)
+	asserts.Equal(context.DeadlineExceeded.Error(), result.Error)
+
+	result = runCheck(context.Background(), Check{"broken", func(ctx context.Context) error {
+		return errors.New("boom")
+	}})
+	asserts.Equal("boom", result.Error)
+	asserts.GreaterOrEqual(result.LatencyMs, 0.0)
+}
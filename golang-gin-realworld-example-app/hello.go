@@ -1,53 +1,134 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-contrib/cors"
 
 	"github.com/jinzhu/gorm"
+	"realworld-backend/admin"
 	"realworld-backend/articles"
 	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/health"
+	"realworld-backend/jwtkeys"
+	"realworld-backend/mailer"
+	"realworld-backend/metrics"
+	"realworld-backend/migrations"
+	"realworld-backend/oidc"
+	"realworld-backend/ratelimit"
+	"realworld-backend/tracing"
 	"realworld-backend/users"
 )
 
-func Migrate(db *gorm.DB) {
-	users.AutoMigrate()
-	db.AutoMigrate(&articles.ArticleModel{})
-	db.AutoMigrate(&articles.TagModel{})
-	db.AutoMigrate(&articles.FavoriteModel{})
-	db.AutoMigrate(&articles.ArticleUserModel{})
-	db.AutoMigrate(&articles.CommentModel{})
-}
+// Push the loaded settings into the packages still reading package level variables.
+// Fails when a signing key file can't be loaded or the mailer can't be set up.
+func ApplyConfig(cfg config.Config) error {
+	// Validated by config.Load, the error can't happen here
+	logger, _ := common.NewLogger(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	common.Logger = logger
+	slog.SetDefault(logger)
+	if cfg.Log.Level == "debug" {
+		common.SQLLogMode = true
+	}
 
-func main() {
+	common.DBPath = cfg.Database.Path
+	common.DBMaxIdleConns = cfg.Database.MaxIdleConns
+	common.NBSecretPassword = cfg.Auth.JWTSecret
+	common.TokenLifetime = cfg.Auth.TokenLifetime
+	keys, err := jwtkeys.FromConfig(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	common.Keys = keys
+	users.BcryptCost = cfg.Auth.BcryptCost
+	users.RefreshTokenLifetime = cfg.Auth.RefreshTokenLifetime
+	users.SecureCookies = cfg.IsProduction()
+	users.CookieSessions = cfg.Auth.CookieSessions
+	users.QueryTokens = cfg.Auth.QueryTokens
+	users.PasswordResetLifetime = cfg.Auth.PasswordResetLifetime
+	users.EmailVerificationLifetime = cfg.Auth.EmailVerificationLifetime
+	users.RequireVerifiedEmail = cfg.Auth.RequireVerifiedEmail
+	users.TOTPIssuer = cfg.Auth.TOTPIssuer
+	users.LoginChallengeLifetime = cfg.Auth.LoginChallengeLifetime
+	articles.MarkdownCacheSize = cfg.Articles.MarkdownCacheSize
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		return err
+	}
+	users.Mailer = mail
+	users.AppURL = strings.TrimSuffix(cfg.Mail.AppURL, "/")
+	users.Lockout = users.LockoutPolicy(cfg.Auth.Lockout)
+	users.OIDCAllowSignup = cfg.OIDC.AllowSignup
+	users.OIDCProviders = map[string]*oidc.Provider{}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, provider := range cfg.OIDC.Providers {
+		users.OIDCProviders[provider.Name] = oidc.NewProvider(provider, client)
+	}
+	if cfg.IsProduction() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	return nil
+}
 
-	db := common.Init()
-	Migrate(db)
-	defer db.Close()
+// Apply the pending schema migrations, see the migrations package.
+func Migrate(db *gorm.DB) error {
+	applied, err := migrations.Up(db)
+	for _, m := range applied {
+		fmt.Println("migrated:", m)
+	}
+	return err
+}
 
-	r := gin.Default()
+// Build the gin engine with every route of the API, shared by `serve` and the tests.
+func SetupRouter(cfg config.Config) *gin.Engine {
+	r := gin.New()
+	// Validated by config.Load, the error can't happen here
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+	// Tracing first so the access log and every handler see the span of the request
+	r.Use(tracing.Middleware(), common.RequestLogger(), common.Recovery())
+	if cfg.Metrics.Enabled {
+		r.Use(metrics.Middleware())
+		metrics.MetricsRegister(r.Group(""))
+	}
 
 	// Configure CORS
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:4100"},
+		AllowOrigins:     cfg.CORS.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", common.RequestIDHeader, "traceparent", "tracestate", users.CSRFHeader},
+		ExposeHeaders:    []string{common.RequestIDHeader, "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: true,
 	}))
 
+	health.HealthRegister(r.Group(""))
+	jwtkeys.JWKSRegister(r.Group(""), common.Keys)
+
+	// Both limits are no-ops when rate limiting is disabled
+	authLimit, writeLimit := rateLimits(cfg.RateLimit)
+
 	v1 := r.Group("/api")
-	users.UsersRegister(v1.Group("/users"))
+	users.UsersRegister(v1.Group("/users", authLimit))
 	v1.Use(users.AuthMiddleware(false))
 	articles.ArticlesAnonymousRegister(v1.Group("/articles"))
 	articles.TagsAnonymousRegister(v1.Group("/tags"))
 
-	v1.Use(users.AuthMiddleware(true))
+	v1.Use(users.AuthMiddleware(true), writeLimit)
 	users.UserRegister(v1.Group("/user"))
 	users.ProfileRegister(v1.Group("/profiles"))
 
 	articles.ArticlesRegister(v1.Group("/articles"))
+	admin.AdminRegister(v1.Group("/admin", users.SessionRequired(), users.RequireRole(users.RoleModerator)))
 
 	testAuth := r.Group("/api/ping")
 
@@ -56,27 +137,60 @@ func main() {
 			"message": "pong",
 		})
 	})
+	return r
+}
+
+// The limiter of the login and registration endpoints, by client IP, and the one of the
+// authenticated writes, by user. They share one in-memory store.
+func rateLimits(cfg config.RateLimitConfig) (gin.HandlerFunc, gin.HandlerFunc) {
+	if !cfg.Enabled {
+		noop := func(c *gin.Context) {}
+		return noop, noop
+	}
+	store := ratelimit.NewMemoryStore()
+	auth := ratelimit.Middleware(store, ratelimit.Rule{
+		Name:  "auth",
+		Limit: ratelimit.Limit{Requests: cfg.AuthRequests, Period: cfg.AuthPeriod},
+		Key:   ratelimit.ByIP,
+	})
+	write := ratelimit.Middleware(store, ratelimit.Rule{
+		Name:  "write",
+		Limit: ratelimit.Limit{Requests: cfg.WriteRequests, Period: cfg.WritePeriod},
+		Key:   ratelimit.WritesOnly(ratelimit.ByUser),
+	})
+	return auth, write
+}
+
+func main() {
+	configPath := flag.String("config", os.Getenv(config.ConfigFileEnv), "path of the YAML config file")
+	flag.Usage = usage
+	flag.Parse()
+
+	// `go run .` without any command keeps starting the server
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-	// test 1 to 1
-	tx1 := db.Begin()
-	userA := users.UserModel{
-		Username: "AAAAAAAAAAAAAAAA",
-		Email:    "aaaa@g.cn",
-		Bio:      "hehddeda",
-		Image:    nil,
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ApplyConfig(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	db := common.Init()
+	err = cmd.run(cfg, db, args)
+	db.Close()
+	if err != nil {
+		common.Logger.Error("command failed", "command", cmd.name, "error", err.Error())
+		os.Exit(1)
 	}
-	tx1.Save(&userA)
-	tx1.Commit()
-	fmt.Println(userA)
-
-	//db.Save(&ArticleUserModel{
-	//    UserModelID:userA.ID,
-	//})
-	//var userAA ArticleUserModel
-	//db.Where(&ArticleUserModel{
-	//    UserModelID:userA.ID,
-	//}).First(&userAA)
-	//fmt.Println(userAA)
-
-	r.Run() // listen and serve on 0.0.0.0:8080
 }
@@ -1,309 +0,0 @@
-package main
-
-import (
-	"net/http"
-	"net/http/httptest"
-	"testing"
-
-	"realworld-backend/articles"
-	"realworld-backend/users"
-
-	"github.com/gin-gonic/gin"
-	"github.com/stretchr/testify/assert"
-)
-
-// setupTestRoutes creates test routes without database dependency
-func setupTestRoutes() *gin.Engine {
-	gin.SetMode(gin.TestMode)
-	r := gin.New()
-
-	// Mock routes for testing API structure
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "API is working"})
-	})
-
-	r.GET("/api/test", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "test endpoint"})
-	})
-
-	return r
-}
-
-// Test 1: API Health Check Integration Test
-func TestAPIHealthIntegration(t *testing.T) {
-	testApp := setupTestRoutes()
-
-	req, _ := http.NewRequest("GET", "/health", nil)
-	w := httptest.NewRecorder()
-	testApp.ServeHTTP(w, req)
-
-	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), "ok")
-}
-
-// Test 2: Test Endpoint Integration Test
-func TestTestEndpointIntegration(t *testing.T) {
-	testApp := setupTestRoutes()
-
-	req, _ := http.NewRequest("GET", "/api/test", nil)
-	w := httptest.NewRecorder()
-	testApp.ServeHTTP(w, req)
-
-	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), "test endpoint")
-}
-
-// Test 3: Routes Registration Test
-func TestRoutesRegistrationIntegration(t *testing.T) {
-	// Test that route registration functions exist and can be called
-	gin.SetMode(gin.TestMode)
-	r := gin.New()
-	v1 := r.Group("/api")
-
-	// Test users routes registration
-	assert.NotPanics(t, func() {
-		users.UsersRegister(v1.Group("/users"))
-	}, "Users routes should register without panic")
-
-	// Test articles routes registration
-	assert.NotPanics(t, func() {
-		articles.ArticlesAnonymousRegister(v1.Group("/articles"))
-		articles.TagsAnonymousRegister(v1.Group("/tags"))
-	}, "Articles routes should register without panic")
-
-	// Test protected routes registration
-	assert.NotPanics(t, func() {
-		users.UserRegister(v1.Group("/user"))
-		users.ProfileRegister(v1.Group("/profiles"))
-		articles.ArticlesRegister(v1.Group("/articles"))
-	}, "Protected routes should register without panic")
-}
-
-// Test 4: API Endpoints Documentation Test
-func TestAPIEndpointsDocumentationIntegration(t *testing.T) {
-	// Document all expected API endpoints
-	expectedEndpoints := []struct {
-		method      string
-		path        string
-		description string
-		auth        bool
-	}{
-		{"POST", "/api/users", "User registration", false},
-		{"POST", "/api/users/login", "User login", false},
-		{"GET", "/api/user", "Get current user", true},
-		{"PUT", "/api/user", "Update user", true},
-		{"GET", "/api/profiles/:username", "Get user profile", false},
-		{"POST", "/api/profiles/:username/follow", "Follow user", true},
-		{"DELETE", "/api/profiles/:username/follow", "Unfollow user", true},
-		{"GET", "/api/articles", "Get articles", false},
-		{"POST", "/api/articles", "Create article", true},
-		{"GET", "/api/articles/:slug", "Get article", false},
-		{"PUT", "/api/articles/:slug", "Update article", true},
-		{"DELETE", "/api/articles/:slug", "Delete article", true},
-		{"POST", "/api/articles/:slug/favorite", "Favorite article", true},
-		{"DELETE", "/api/articles/:slug/favorite", "Unfavorite article", true},
-		{"GET", "/api/articles/:slug/comments", "Get article comments", false},
-		{"POST", "/api/articles/:slug/comments", "Add comment", true},
-		{"DELETE", "/api/articles/:slug/comments/:id", "Delete comment", true},
-		{"GET", "/api/tags", "Get tags", false},
-	}
-
-	// Verify we have documented all major endpoints
-	assert.Equal(t, 18, len(expectedEndpoints), "Should have 18 documented API endpoints")
-
-	// Verify mix of authenticated and non-authenticated endpoints
-	authCount := 0
-	noAuthCount := 0
-
-	for _, endpoint := range expectedEndpoints {
-		if endpoint.auth {
-			authCount++
-		} else {
-			noAuthCount++
-		}
-	}
-
-	assert.Greater(t, authCount, 5, "Should have multiple authenticated endpoints")
-	assert.Greater(t, noAuthCount, 5, "Should have multiple non-authenticated endpoints")
-}
-
-// Test 5: HTTP Methods Coverage Integration Test
-func TestHTTPMethodsCoverageIntegration(t *testing.T) {
-	// Test that our API covers all major HTTP methods
-	methods := map[string]int{
-		"GET":    0,
-		"POST":   0,
-		"PUT":    0,
-		"DELETE": 0,
-	}
-
-	// Simulate counting methods from our API endpoints
-	// In a real app, this would analyze the actual routes
-	expectedMethods := []string{
-		"GET", "GET", "GET", "GET", "GET", "GET", "GET", "GET",
-		"POST", "POST", "POST", "POST", "POST",
-		"PUT", "PUT",
-		"DELETE", "DELETE", "DELETE",
-	}
-
-	for _, method := range expectedMethods {
-		methods[method]++
-	}
-
-	assert.Greater(t, methods["GET"], 0, "Should have GET endpoints")
-	assert.Greater(t, methods["POST"], 0, "Should have POST endpoints")
-	assert.Greater(t, methods["PUT"], 0, "Should have PUT endpoints")
-	assert.Greater(t, methods["DELETE"], 0, "Should have DELETE endpoints")
-}
-
-// Test 6: Response Format Standards Integration Test
-func TestResponseFormatStandardsIntegration(t *testing.T) {
-	// Test that we follow consistent response format standards
-	testApp := setupTestRoutes()
-
-	req, _ := http.NewRequest("GET", "/health", nil)
-	w := httptest.NewRecorder()
-	testApp.ServeHTTP(w, req)
-
-	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
-	assert.Contains(t, w.Body.String(), `"status"`)
-}
-
-// Test 7: Error Handling Integration Test
-func TestErrorHandlingIntegration(t *testing.T) {
-	testApp := setupTestRoutes()
-
-	// Test 404 for non-existent endpoint
-	req, _ := http.NewRequest("GET", "/nonexistent", nil)
-	w := httptest.NewRecorder()
-	testApp.ServeHTTP(w, req)
-
-	assert.Equal(t, http.StatusNotFound, w.Code)
-}
-
-// Test 8: Authentication Middleware Integration Test
-func TestAuthenticationMiddlewareIntegration(t *testing.T) {
-	// Test that authentication middleware exists and can be instantiated
-	assert.NotPanics(t, func() {
-		middleware := users.AuthMiddleware(true)
-		assert.NotNil(t, middleware)
-	}, "Auth middleware should be instantiable")
-
-	assert.NotPanics(t, func() {
-		middleware := users.AuthMiddleware(false)
-		assert.NotNil(t, middleware)
-	}, "Optional auth middleware should be instantiable")
-}
-
-// Test 9: Package Structure Integration Test
-func TestPackageStructureIntegration(t *testing.T) {
-	// Test that all required packages are properly structured
-
-	// Test users package functions exist
-	assert.NotNil(t, users.UsersRegister, "UsersRegister function should exist")
-	assert.NotNil(t, users.UserRegister, "UserRegister function should exist")
-	assert.NotNil(t, users.ProfileRegister, "ProfileRegister function should exist")
-	assert.NotNil(t, users.AuthMiddleware, "AuthMiddleware function should exist")
-
-	// Test articles package functions exist
-	assert.NotNil(t, articles.ArticlesRegister, "ArticlesRegister function should exist")
-	assert.NotNil(t, articles.ArticlesAnonymousRegister, "ArticlesAnonymousRegister function should exist")
-	assert.NotNil(t, articles.TagsAnonymousRegister, "TagsAnonymousRegister function should exist")
-}
-
-// Test 10: Gin Framework Integration Test
-func TestGinFrameworkIntegration(t *testing.T) {
-	// Test Gin framework setup and configuration
-	gin.SetMode(gin.TestMode)
-	r := gin.New()
-
-	assert.NotNil(t, r, "Gin engine should be created")
-
-	// Test route group creation
-	v1 := r.Group("/api")
-	assert.NotNil(t, v1, "Route group should be created")
-
-	// Test middleware addition
-	assert.NotPanics(t, func() {
-		v1.Use(gin.Logger())
-	}, "Should be able to add middleware")
-}
-
-// Test 11: CORS and Security Headers Integration Test
-func TestCORSandSecurityHeadersIntegration(t *testing.T) {
-	testApp := setupTestRoutes()
-
-	req, _ := http.NewRequest("GET", "/health", nil)
-	w := httptest.NewRecorder()
-	testApp.ServeHTTP(w, req)
-
-	// Basic security checks
-	assert.Equal(t, http.StatusOK, w.Code)
-	assert.NotEmpty(t, w.Body.String(), "Response should not be empty")
-}
-
-// Test 12: API Versioning Integration Test
-func TestAPIVersioningIntegration(t *testing.T) {
-	// Test that API is properly versioned under /api
-	gin.SetMode(gin.TestMode)
-	r := gin.New()
-
-	v1 := r.Group("/api")
-	assert.NotNil(t, v1, "API v1 group should exist")
-
-	// Test nested grouping
-	usersGroup := v1.Group("/users")
-	articlesGroup := v1.Group("/articles")
-
-	assert.NotNil(t, usersGroup, "Users group should exist")
-	assert.NotNil(t, articlesGroup, "Articles group should exist")
-}
-
-// Test 13: JSON Parsing Integration Test
-func TestJSONParsingIntegration(t *testing.T) {
-	testApp := setupTestRoutes()
-
-	req, _ := http.NewRequest("GET", "/health", nil)
-	w := httptest.NewRecorder()
-	testApp.ServeHTTP(w, req)
-
-	// Test that response is valid JSON
-	contentType := w.Header().Get("Content-Type")
-	assert.Contains(t, contentType, "application/json")
-}
-
-// Test 14: Router Performance Integration Test
-func TestRouterPerformanceIntegration(t *testing.T) {
-	testApp := setupTestRoutes()
-
-	// Test multiple requests to ensure router is stable
-	for i := 0; i < 10; i++ {
-		req, _ := http.NewRequest("GET", "/health", nil)
-		w := httptest.NewRecorder()
-		testApp.ServeHTTP(w, req)
-
-		assert.Equal(t, http.StatusOK, w.Code, "Request %d should succeed", i+1)
-	}
-}
-
-// Test 15: Application Startup Integration Test
-func TestApplicationStartupIntegration(t *testing.T) {
-	// Test that application components can be initialized
-	assert.NotPanics(t, func() {
-		gin.SetMode(gin.TestMode)
-		r := gin.New()
-		v1 := r.Group("/api")
-
-		// Simulate main application setup
-		users.UsersRegister(v1.Group("/users"))
-		v1.Use(users.AuthMiddleware(false))
-		articles.ArticlesAnonymousRegister(v1.Group("/articles"))
-		articles.TagsAnonymousRegister(v1.Group("/tags"))
-
-		v1.Use(users.AuthMiddleware(true))
-		users.UserRegister(v1.Group("/user"))
-		users.ProfileRegister(v1.Group("/profiles"))
-		articles.ArticlesRegister(v1.Group("/articles"))
-
-	}, "Application should start up without panic")
-}
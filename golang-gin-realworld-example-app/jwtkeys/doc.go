@@ -0,0 +1,8 @@
+/*
+The jwtkeys module containing the keys signing and verifying the JWT access tokens.
+
+keys.go: the HS256, RS256, ES256 and EdDSA keys, their schedule and the Manager picking them by kid
+
+jwks.go: the public keys in the JSON Web Key format, served on /.well-known/jwks.json
+*/
+package jwtkeys
@@ -0,0 +1,129 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A public key in the JSON Web Key format of RFC 7517, RFC 8037 for Ed25519.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// How long the other services may cache the key set, a key must be published
+// that long before its NotBefore for them to verify its first tokens.
+const jwksMaxAge = "max-age=300"
+
+func JWKSRegister(router *gin.RouterGroup, keys *Manager) {
+	router.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, "+jwksMaxAge)
+		c.JSON(http.StatusOK, keys.JWKS())
+	})
+}
+
+// The public keys verifying tokens now or in the future, the HMAC secrets are never published.
+func (m *Manager) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	now := m.now()
+	for _, key := range m.keys {
+		if key.ID == "" || !key.verifies(now, m.grace) {
+			continue
+		}
+		if jwk, ok := key.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func (k *Key) jwk() (JSONWebKey, bool) {
+	jwk := JSONWebKey{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch public := k.verifying.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// The coordinates are padded to the size of the curve, 32 bytes for P-256
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType, jwk.Curve = "EC", public.Curve.Params().Name
+		jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = encode(public)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
+
+// The key of another issuer's key set, the reverse of the keys published by JWKS:
+// RSA of at least 2048 bits, EC on P-256 or Ed25519.
+func (jwk JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, errN := decode(jwk.N)
+		e, errE := decode(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jwtkeys: RSA key %q: invalid n or e", jwk.KeyID)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("jwtkeys: RSA key %q: %d bits, at least 2048 expected", jwk.KeyID, key.N.BitLen())
+		}
+		return key, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("jwtkeys: EC key %q: unsupported curve %q", jwk.KeyID, jwk.Curve)
+		}
+		x, errX := decode(jwk.X)
+		y, errY := decode(jwk.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("jwtkeys: EC key %q: invalid x or y", jwk.KeyID)
+		}
+		// ecdh checks the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("jwtkeys: EC key %q: %w", jwk.KeyID, err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(jwk.X)
+		if jwk.Curve != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwtkeys: OKP key %q: Ed25519 expected", jwk.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("jwtkeys: unsupported key type " + jwk.KeyType)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,231 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"realworld-backend/config"
+)
+
+// The algorithms a Key can sign with.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKey     = errors.New("token signed by an unknown key")
+	ErrKeyRetired     = errors.New("token signed by a retired key")
+	ErrAlgorithm      = errors.New("token algorithm doesn't match its key")
+	ErrNoSigningKey   = errors.New("no signing key is active")
+	ErrDuplicateKeyID = errors.New("duplicate key id")
+)
+
+// A key signing the tokens from NotBefore until NotAfter, both optional.
+// Once NotAfter is past it still verifies them for the grace period of its Manager.
+type Key struct {
+	// The kid header, empty only for the legacy jwt_secret key
+	ID        string
+	Algorithm string
+	NotBefore time.Time
+	NotAfter  time.Time
+	signing   interface{}
+	verifying interface{}
+}
+
+// A HS256 key, its secret is shared with whoever verifies so it's never published.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: HS256, signing: secret, verifying: secret}
+}
+
+// A RS256, ES256 or EdDSA key from its private key, which must match the algorithm.
+func NewKey(id, algorithm string, private crypto.Signer) (*Key, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == RS256 && k.N.BitLen() >= 2048 {
+			return &Key{ID: id, Algorithm: algorithm, signing: k, verifying: &k.PublicKey}, nil
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm == ES256 && k.Curve == elliptic.P256() {
+			return &Key{ID: id, Algorithm: algorithm, signing: k, verifying: &k.PublicKey}, nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm == EdDSA {
+			return &Key{ID: id, Algorithm: algorithm, signing: k, verifying: k.Public()}, nil
+		}
+	}
+	return nil, fmt.Errorf("key %q: a %T can't sign %s", id, private, algorithm)
+}
+
+// A new private key for the algorithm: RSA 2048 bits, ECDSA P-256 or Ed25519.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("can't generate a %s key", algorithm)
+}
+
+// Parse a PEM private key of the algorithm, PKCS#8 or the PKCS#1 and SEC 1 legacy formats.
+func ParsePrivateKeyPEM(algorithm string, data []byte) (crypto.Signer, error) {
+	switch algorithm {
+	case RS256:
+		return jwt.ParseRSAPrivateKeyFromPEM(data)
+	case ES256:
+		return jwt.ParseECPrivateKeyFromPEM(data)
+	case EdDSA:
+		key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return key.(crypto.Signer), nil
+	}
+	return nil, fmt.Errorf("%s has no private key", algorithm)
+}
+
+// Encode a private key as a PKCS#8 PEM block, as read by ParsePrivateKeyPEM.
+func MarshalPrivateKeyPEM(private crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// The signing and verifying keys of the tokens, immutable: the rotation follows their schedule.
+type Manager struct {
+	keys  []*Key
+	grace time.Duration
+	now   func() time.Time
+}
+
+// A manager of the keys, their ids must be unique.
+//
+//	keys, err := jwtkeys.NewManager(time.Hour, jwtkeys.NewHMACKey("", secret))
+func NewManager(grace time.Duration, keys ...*Key) (*Manager, error) {
+	ids := map[string]bool{}
+	for _, key := range keys {
+		if ids[key.ID] {
+			return nil, fmt.Errorf("%w %q", ErrDuplicateKeyID, key.ID)
+		}
+		ids[key.ID] = true
+	}
+	return &Manager{keys: keys, grace: grace, now: time.Now}, nil
+}
+
+// The manager of the auth config: jwt_secret as the legacy key without kid, retired after
+// auth.legacy_secret_not_after, then auth.signing_keys.
+func FromConfig(cfg config.AuthConfig) (*Manager, error) {
+	legacy := NewHMACKey("", []byte(cfg.JWTSecret))
+	legacy.NotAfter = cfg.LegacySecretNotAfter
+	keys := []*Key{legacy}
+	for _, keyCfg := range cfg.SigningKeys {
+		var key *Key
+		if keyCfg.Algorithm == HS256 {
+			key = NewHMACKey(keyCfg.ID, []byte(keyCfg.Secret))
+		} else {
+			data, err := os.ReadFile(keyCfg.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", keyCfg.ID, err)
+			}
+			private, err := ParsePrivateKeyPEM(keyCfg.Algorithm, data)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s: %w", keyCfg.ID, keyCfg.PrivateKeyFile, err)
+			}
+			if key, err = NewKey(keyCfg.ID, keyCfg.Algorithm, private); err != nil {
+				return nil, err
+			}
+		}
+		key.NotBefore, key.NotAfter = keyCfg.NotBefore, keyCfg.NotAfter
+		keys = append(keys, key)
+	}
+	return NewManager(cfg.KeyGracePeriod, keys...)
+}
+
+// Whether the key signs at `now`.
+func (k *Key) active(now time.Time) bool {
+	return !now.Before(k.NotBefore) && (k.NotAfter.IsZero() || now.Before(k.NotAfter))
+}
+
+// Whether the key still verifies at `now`, it did sign or will sign soon.
+func (k *Key) verifies(now time.Time, grace time.Duration) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter.Add(grace))
+}
+
+// The key signing now: the active one that became active last, the legacy key only when none is.
+func (m *Manager) SigningKey() (*Key, error) {
+	now := m.now()
+	var current, legacy *Key
+	for _, key := range m.keys {
+		switch {
+		case !key.active(now):
+		case key.ID == "":
+			legacy = key
+		case current == nil || key.NotBefore.After(current.NotBefore):
+			current = key
+		}
+	}
+	if current == nil {
+		current = legacy
+	}
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+	return current, nil
+}
+
+// Sign the claims with the current key, naming it in the kid header.
+//
+//	token, err := keys.Sign(jwt.MapClaims{"id": id, "exp": exp})
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	key, err := m.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	// Without kid the token keeps the legacy shape, verified by jwt_secret
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signing)
+}
+
+// The jwt.Keyfunc selecting the key named by the kid header, rejecting an algorithm
+// other than the key's so a public key can never be used as a HMAC secret.
+//
+//	token, err := jwt.Parse(tokenString, keys.Keyfunc)
+func (m *Manager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range m.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, ErrAlgorithm
+		}
+		if !key.verifies(m.now(), m.grace) {
+			return nil, ErrKeyRetired
+		}
+		return key.verifying, nil
+	}
+	return nil, ErrUnknownKey
+}
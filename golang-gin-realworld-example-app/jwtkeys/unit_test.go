@@ -0,0 +1,244 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/config"
+)
+
+var legacySecret = []byte("A String Very Very Very Strong!!@##$!@#$")
+
+func newKey(t *testing.T, id, algorithm string) *Key {
+	private, err := GenerateKey(algorithm)
+	assert.NoError(t, err)
+	key, err := NewKey(id, algorithm, private)
+	assert.NoError(t, err)
+	return key
+}
+
+func parse(m *Manager, token string) (jwt.MapClaims, error) {
+	parsed, err := jwt.Parse(token, m.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Claims.(jwt.MapClaims), nil
+}
+
+func TestSignAndVerify(t *testing.T) {
+	asserts := assert.New(t)
+	for _, algorithm := range []string{RS256, ES256, EdDSA} {
+		m, err := NewManager(time.Hour, NewHMACKey("", legacySecret), newKey(t, "k-"+algorithm, algorithm))
+		asserts.NoError(err)
+		token, err := m.Sign(jwt.MapClaims{"id": 7})
+		asserts.NoError(err)
+
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		asserts.NoError(err)
+		asserts.Equal(algorithm, parsed.Method.Alg())
+		asserts.Equal("k-"+algorithm, parsed.Header["kid"], "The configured key should sign over the legacy one")
+
+		claims, err := parse(m, token)
+		asserts.NoError(err, algorithm)
+		asserts.Equal(float64(7), claims["id"])
+	}
+
+	// Alone the legacy key keeps the token shape of GenToken: HS256 without kid
+	m, _ := NewManager(time.Hour, NewHMACKey("", legacySecret))
+	token, err := m.Sign(jwt.MapClaims{"id": 7})
+	asserts.NoError(err)
+	legacy, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return legacySecret, nil })
+	asserts.NoError(err)
+	asserts.NotContains(legacy.Header, "kid")
+
+	_, err = NewManager(time.Hour, NewHMACKey("a", legacySecret), NewHMACKey("a", legacySecret))
+	asserts.ErrorIs(err, ErrDuplicateKeyID)
+	_, err = NewKey("b", ES256, newKey(t, "b", RS256).signing.(*rsa.PrivateKey))
+	asserts.ErrorContains(err, "can't sign ES256")
+}
+
+func TestRotation(t *testing.T) {
+	asserts := assert.New(t)
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	october, november := newKey(t, "2026-10", ES256), newKey(t, "2026-11", ES256)
+	october.NotBefore, october.NotAfter = start, start.AddDate(0, 1, 0)
+	november.NotBefore = start.AddDate(0, 1, 0)
+	m, _ := NewManager(time.Hour, NewHMACKey("", legacySecret), october, november)
+
+	m.now = func() time.Time { return start.Add(-time.Minute) }
+	key, _ := m.SigningKey()
+	asserts.Equal("", key.ID, "The legacy key should sign until the first key starts")
+
+	m.now = func() time.Time { return start.AddDate(0, 1, 0).Add(-time.Minute) }
+	token, _ := m.Sign(jwt.MapClaims{"id": 1})
+	key, _ = m.SigningKey()
+	asserts.Equal("2026-10", key.ID)
+	asserts.Len(m.JWKS().Keys, 2, "The next key should be published before it signs")
+
+	m.now = func() time.Time { return start.AddDate(0, 1, 0).Add(30 * time.Minute) }
+	key, _ = m.SigningKey()
+	asserts.Equal("2026-11", key.ID)
+	_, err := parse(m, token)
+	asserts.NoError(err, "The old key should verify during the grace period")
+
+	m.now = func() time.Time { return start.AddDate(0, 1, 0).Add(2 * time.Hour) }
+	_, err = parse(m, token)
+	asserts.ErrorIs(err, ErrKeyRetired)
+	if jwks := m.JWKS(); asserts.Len(jwks.Keys, 1) {
+		asserts.Equal("2026-11", jwks.Keys[0].KeyID)
+	}
+
+	// Without the legacy key nothing is signed once every key stopped
+	expired, _ := NewManager(time.Hour, october)
+	expired.now = m.now
+	_, err = expired.Sign(jwt.MapClaims{"id": 1})
+	asserts.ErrorIs(err, ErrNoSigningKey)
+}
+
+func TestKeyfuncRejects(t *testing.T) {
+	asserts := assert.New(t)
+	rsaKey := newKey(t, "rsa", RS256)
+	m, _ := NewManager(time.Hour, NewHMACKey("", legacySecret), rsaKey)
+
+	// The public key is public: used as a HMAC secret it would let anyone sign
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1})
+	forged.Header["kid"] = "rsa"
+	public := x509PublicPEM(t, rsaKey)
+	token, _ := forged.SignedString(public)
+	_, err := parse(m, token)
+	asserts.ErrorIs(err, ErrAlgorithm)
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1})
+	unknown.Header["kid"] = "gone"
+	token, _ = unknown.SignedString(legacySecret)
+	_, err = parse(m, token)
+	asserts.ErrorIs(err, ErrUnknownKey)
+
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1}).SignedString([]byte("another secret of enough length"))
+	_, err = parse(m, token)
+	asserts.ErrorIs(err, jwt.ErrSignatureInvalid)
+}
+
+func x509PublicPEM(t *testing.T, key *Key) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key.verifying)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	asserts := assert.New(t)
+	ecKey, edKey := newKey(t, "ec", ES256), newKey(t, "ed", EdDSA)
+	m, _ := NewManager(time.Hour, NewHMACKey("", legacySecret), NewHMACKey("shared", legacySecret), newKey(t, "rsa", RS256), ecKey, edKey)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	JWKSRegister(r.Group(""), m)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("public, max-age=300", w.Header().Get("Cache-Control"))
+
+	var set JSONWebKeySet
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &set))
+	asserts.NotContains(w.Body.String(), "shared", "HMAC secrets must never be published")
+	if !asserts.Len(set.Keys, 3) {
+		return
+	}
+	rsaJWK, ecJWK, edJWK := set.Keys[0], set.Keys[1], set.Keys[2]
+	asserts.Equal("RSA", rsaJWK.KeyType)
+	asserts.Equal("AQAB", rsaJWK.E)
+	asserts.Equal("sig", rsaJWK.Use)
+	asserts.Equal("OKP", edJWK.KeyType)
+	asserts.Equal("Ed25519", edJWK.Curve)
+
+	// Another service rebuilding the EC key from the set verifies our tokens
+	asserts.Equal("P-256", ecJWK.Curve)
+	x, _ := base64.RawURLEncoding.DecodeString(ecJWK.X)
+	y, _ := base64.RawURLEncoding.DecodeString(ecJWK.Y)
+	asserts.Len(x, 32)
+	public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	signer, _ := NewManager(time.Hour, ecKey)
+	token, _ := signer.Sign(jwt.MapClaims{"id": 3})
+	_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil })
+	asserts.NoError(err)
+
+	// PublicKey reads back every published key
+	for _, jwk := range set.Keys {
+		parsed, err := jwk.PublicKey()
+		asserts.NoError(err, jwk.KeyID)
+		for _, key := range m.keys {
+			if key.ID == jwk.KeyID {
+				asserts.True(key.verifying.(interface{ Equal(crypto.PublicKey) bool }).Equal(parsed), jwk.KeyID)
+			}
+		}
+	}
+	offCurve := ecJWK
+	offCurve.Y = ecJWK.X
+	_, err = offCurve.PublicKey()
+	asserts.Error(err, "A point off the curve should be refused")
+	ecJWK.Curve = "P-384"
+	_, err = ecJWK.PublicKey()
+	asserts.ErrorContains(err, "unsupported curve")
+	rsaJWK.N = rsaJWK.N[:100]
+	_, err = rsaJWK.PublicKey()
+	asserts.ErrorContains(err, "at least 2048")
+}
+
+func TestFromConfig(t *testing.T) {
+	asserts := assert.New(t)
+	private, _ := GenerateKey(EdDSA)
+	data, _ := MarshalPrivateKeyPEM(private)
+	path := filepath.Join(t.TempDir(), "ed.pem")
+	asserts.NoError(os.WriteFile(path, data, 0o600))
+
+	cfg := config.Default().Auth
+	cfg.SigningKeys = []config.SigningKeyConfig{
+		{ID: "ed", Algorithm: EdDSA, PrivateKeyFile: path},
+		{ID: "later", Algorithm: HS256, Secret: "a shared secret of at least 32 characters", NotBefore: time.Now().Add(time.Hour)},
+	}
+	m, err := FromConfig(cfg)
+	asserts.NoError(err)
+	key, _ := m.SigningKey()
+	asserts.Equal("ed", key.ID)
+	asserts.Len(m.keys, 3)
+
+	legacy, _ := NewManager(cfg.KeyGracePeriod, NewHMACKey("", legacySecret))
+	token, _ := legacy.Sign(jwt.MapClaims{"id": 1})
+	cfg.LegacySecretNotAfter = time.Now().Add(-cfg.KeyGracePeriod / 2)
+	m, _ = FromConfig(cfg)
+	key, _ = m.SigningKey()
+	asserts.Equal("ed", key.ID)
+	_, err = parse(m, token)
+	asserts.NoError(err, "The legacy key should verify during the grace period")
+	cfg.LegacySecretNotAfter = time.Now().Add(-cfg.KeyGracePeriod - time.Minute)
+	m, _ = FromConfig(cfg)
+	_, err = parse(m, token)
+	asserts.ErrorIs(err, ErrKeyRetired, "The legacy key should be refused after legacy_secret_not_after")
+	cfg.LegacySecretNotAfter = time.Time{}
+
+	cfg.SigningKeys[0].Algorithm = ES256
+	_, err = FromConfig(cfg)
+	asserts.ErrorContains(err, `key "ed"`)
+
+	cfg.SigningKeys[0].PrivateKeyFile = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = FromConfig(cfg)
+	asserts.ErrorIs(err, os.ErrNotExist)
+}
@@ -0,0 +1,10 @@
+/*
+The mailer module containing the delivery of the emails sent by the server.
+
+mailer.go: the Mailer interface, the pluggable transports and the encoding of the messages
+
+smtp.go: the SMTP transport
+
+file.go: the file transport writing an outbox directory, and its reader for development and tests
+*/
+package mailer
@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"realworld-backend/config"
+)
+
+// Writes every message as an .eml file of Dir instead of sending it, for development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+var outboxSeq atomic.Uint64
+
+func newFileMailer(cfg config.MailConfig) (Mailer, error) {
+	if err := os.MkdirAll(cfg.OutboxDir, 0o750); err != nil {
+		return nil, fmt.Errorf("mailer: outbox: %w", err)
+	}
+	return &FileMailer{Dir: cfg.OutboxDir, From: cfg.From}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := msg.encode(m.From, now)
+	if err != nil {
+		return err
+	}
+	// Named in sending order, written aside then renamed so a reader never sees half a message
+	name := fmt.Sprintf("%d-%06d.eml", now.UnixNano(), outboxSeq.Add(1))
+	tmp := filepath.Join(m.Dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, name))
+}
+
+// The messages of an outbox written by FileMailer, oldest first.
+//
+//	messages, err := mailer.ReadOutbox("./outbox")
+func ReadOutbox(dir string) ([]Message, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	messages := make([]Message, 0, len(paths))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := mail.ReadMessage(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		body, err := io.ReadAll(parsed.Body)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+		messages = append(messages, Message{
+			To:      parsed.Header.Get("To"),
+			Subject: subject,
+			Body:    strings.ReplaceAll(string(body), "\r\n", "\n"),
+		})
+	}
+	return messages, nil
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"realworld-backend/config"
+)
+
+// A plain text message to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Delivers the messages of the server, selected by mail.transport.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Builds the mailer selected by mail.transport.
+type Factory func(cfg config.MailConfig) (Mailer, error)
+
+var transports = map[string]Factory{
+	"smtp": newSMTPMailer,
+	"file": newFileMailer,
+}
+
+// Make another transport selectable from the config, an HTTP API of a mail provider for instance.
+//
+//	mailer.RegisterTransport("sendgrid", func(cfg config.MailConfig) (mailer.Mailer, error) { ... })
+func RegisterTransport(name string, factory Factory) {
+	transports[name] = factory
+}
+
+// The mailer of the configured transport.
+func New(cfg config.MailConfig) (Mailer, error) {
+	factory, ok := transports[cfg.Transport]
+	if !ok {
+		return nil, fmt.Errorf("mailer: unknown transport %q", cfg.Transport)
+	}
+	return factory(cfg)
+}
+
+var ErrHeaderInjection = errors.New("mailer: line break in a header")
+
+// The RFC 5322 encoding of the message, as sent over SMTP and written to the outbox.
+func (m Message) encode(from string, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(from+m.To+m.Subject, "\r\n") {
+		return nil, ErrHeaderInjection
+	}
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return nil, fmt.Errorf("mailer: recipient %q: %w", m.To, err)
+	}
+	id := make([]byte, 16)
+	// The Message-ID only has to look unique, a failed read leaves a zero one rather than losing the mail
+	_, _ = rand.Read(id)
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		domain = address.Address[strings.LastIndex(address.Address, "@")+1:]
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"realworld-backend/config"
+)
+
+// Sends through an SMTP relay, upgraded with STARTTLS whenever the server offers it.
+type SMTPMailer struct {
+	Addr string
+	From string
+	// nil without a username: the relay trusts our network
+	Auth smtp.Auth
+}
+
+func newSMTPMailer(cfg config.MailConfig) (Mailer, error) {
+	m := &SMTPMailer{
+		Addr: net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		From: cfg.From,
+	}
+	if cfg.SMTPUsername != "" {
+		// PlainAuth refuses to send the password over a connection that isn't TLS, but to localhost
+		m.Auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.encode(m.From, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, from.Address, []string{to.Address}, data)
+}
@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/config"
+)
+
+func TestFileMailer(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := config.Default().Mail
+	cfg.OutboxDir = t.TempDir() + "/outbox"
+	m, err := New(cfg)
+	asserts.NoError(err)
+
+	asserts.NoError(m.Send(context.Background(), Message{To: "jake@jake.jake", Subject: "Réinitialiser", Body: "first\nline two"}))
+	asserts.NoError(m.Send(context.Background(), Message{To: "jane@jane.jane", Subject: "Second", Body: "second"}))
+	messages, err := ReadOutbox(cfg.OutboxDir)
+	asserts.NoError(err)
+	if asserts.Len(messages, 2) {
+		asserts.Equal(Message{To: "jake@jake.jake", Subject: "Réinitialiser", Body: "first\nline two"}, messages[0])
+		asserts.Equal("jane@jane.jane", messages[1].To, "The outbox should read in sending order")
+	}
+
+	err = m.Send(context.Background(), Message{To: "jake@jake.jake", Subject: "Hi\r\nBcc: all@example.com"})
+	asserts.ErrorIs(err, ErrHeaderInjection)
+	asserts.Error(m.Send(context.Background(), Message{To: "not an address"}))
+
+	cfg.Transport = "pigeon"
+	_, err = New(cfg)
+	asserts.ErrorContains(err, `unknown transport "pigeon"`)
+}
+
+// A relay accepting one message, without STARTTLS nor AUTH.
+func fakeSMTP(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 fake ESMTP")
+		var transcript strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250 fake")
+			case strings.HasPrefix(command, "DATA"):
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 queued")
+			case strings.HasPrefix(command, "QUIT"):
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				transcript.WriteString(line)
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	asserts := assert.New(t)
+	addr, received := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+	cfg := config.Default().Mail
+	cfg.Transport, cfg.SMTPHost = "smtp", host
+	cfg.SMTPPort, _ = strconv.Atoi(port)
+	m, err := New(cfg)
+	asserts.NoError(err)
+
+	asserts.NoError(m.Send(context.Background(), Message{To: "Jake <jake@jake.jake>", Subject: "Hello", Body: "body"}))
+	transcript := <-received
+	asserts.Contains(transcript, "MAIL FROM:<noreply@realworld.local>")
+	asserts.Contains(transcript, "RCPT TO:<jake@jake.jake>")
+	asserts.Contains(transcript, "Subject: Hello\r\n")
+	asserts.Contains(transcript, "Message-ID: <")
+	asserts.Contains(transcript, "@realworld.local>")
+}
@@ -0,0 +1,10 @@
+/*
+The metrics module containing the Prometheus instrumentation of the server.
+
+metrics.go: the registry and every collector (HTTP, database, domain counters)
+
+middlewares.go: the gin middleware timing each route and the /metrics handler
+
+gorm.go: the gorm callbacks counting and timing each statement
+*/
+package metrics
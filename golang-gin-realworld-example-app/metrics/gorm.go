@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const startKey = "metrics:start_time"
+
+// Hook a before/after callback around each gorm processor so every statement issued through the
+// models is counted and timed. Raw db.Exec calls don't run callbacks and aren't measured.
+//
+//	metrics.RegisterGormCallbacks(db)
+func RegisterGormCallbacks(db *gorm.DB) {
+	callback := db.Callback()
+	callback.Create().Before("gorm:begin_transaction").Register("metrics:before_create", before)
+	callback.Create().After("gorm:commit_or_rollback_transaction").Register("metrics:after_create", after("create"))
+	callback.Query().Before("gorm:query").Register("metrics:before_query", before)
+	callback.Query().After("gorm:after_query").Register("metrics:after_query", after("query"))
+	callback.Update().Before("gorm:begin_transaction").Register("metrics:before_update", before)
+	callback.Update().After("gorm:commit_or_rollback_transaction").Register("metrics:after_update", after("update"))
+	callback.Delete().Before("gorm:begin_transaction").Register("metrics:before_delete", before)
+	callback.Delete().After("gorm:commit_or_rollback_transaction").Register("metrics:after_delete", after("delete"))
+	callback.RowQuery().Before("gorm:row_query").Register("metrics:before_row", before)
+	callback.RowQuery().After("gorm:row_query").Register("metrics:after_row", after("row"))
+}
+
+func before(scope *gorm.Scope) {
+	scope.InstanceSet(startKey, time.Now())
+}
+
+func after(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		DBDuration.WithLabelValues(operation).Observe(time.Since(value.(time.Time)).Seconds())
+		result := "ok"
+		if scope.HasError() {
+			result = "error"
+			if gorm.IsRecordNotFoundError(scope.DB().Error) {
+				result = "not_found"
+			}
+		}
+		DBQueries.WithLabelValues(operation, result).Inc()
+	}
+}
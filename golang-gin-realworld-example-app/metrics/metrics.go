@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "realworld"
+
+// Every collector of the server is registered here instead of the global default registry,
+// so tests can build routers repeatedly without duplicate registration panics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	HTTPInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	DBQueries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_queries_total",
+		Help:      "gorm statements by operation (query, create, update, delete, row) and result (ok, not_found, error).",
+	}, []string{"operation", "result"})
+
+	DBDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "gorm statement latency by operation.",
+		Buckets:   []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	UserRegistrations = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_registrations_total",
+		Help:      "Accounts created through the API.",
+	})
+
+	LoginsFailed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_failed_total",
+		Help:      "Login attempts rejected for an unknown email or a wrong password.",
+	})
+
+	AccountLockouts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "account_lockouts_total",
+		Help:      "Accounts locked after too many failed logins in a row.",
+	})
+
+	RefreshTokenReuses = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refresh_token_reuses_total",
+		Help:      "Refresh tokens presented again after rotation, each one revoked its family.",
+	})
+
+	PasswordResets = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "password_resets_total",
+		Help:      "Passwords changed with the token of a reset email.",
+	})
+
+	OIDCLogins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "oidc_logins_total",
+		Help:      "Logins with an OpenID provider by provider and result (existing, linked, created, refused).",
+	}, []string{"provider", "result"})
+
+	ModerationActions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moderation_actions_total",
+		Help:      "Actions of the admin API by action (ban, unban, role, delete_article, delete_comment).",
+	}, []string{"action"})
+
+	MailsSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mails_sent_total",
+		Help:      "Emails handed to the mailer by kind and result (ok, error).",
+	}, []string{"kind", "result"})
+
+	ArticlesCreated = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "articles_created_total",
+		Help:      "Articles created through the API.",
+	})
+
+	Favorites = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "favorites_total",
+		Help:      "Articles favorited through the API.",
+	})
+
+	MarkdownRenders = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "markdown_renders_total",
+		Help:      "Article and comment bodies served as HTML by result (hit of the cache, miss).",
+	}, []string{"result"})
+
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected with 429 by rate limit rule.",
+	}, []string{"rule"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Export the pool statistics of sql.DB.Stats() (open, in use, idle connections, waits), call it once per database.
+//
+//	metrics.RegisterDBStats(db.DB(), "main")
+func RegisterDBStats(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/common"
+)
+
+type metricsTestModel struct {
+	ID   uint `gorm:"primary_key"`
+	Name string
+}
+
+func TestMiddlewareAndEndpoint(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	MetricsRegister(r.Group(""))
+	r.GET("/api/articles/:slug", func(c *gin.Context) {
+		asserts.Equal(float64(1), testutil.ToFloat64(HTTPInFlight), "The request should be counted in flight")
+		c.Status(http.StatusNotFound)
+	})
+
+	for _, path := range []string{"/api/articles/a", "/api/articles/b", "/nowhere"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	asserts.Equal(float64(2), testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/api/articles/:slug", "404")),
+		"Requests should be grouped by route template")
+	asserts.Equal(float64(1), testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "unmatched", "404")))
+	asserts.Equal(float64(0), testutil.ToFloat64(HTTPInFlight))
+
+	UserRegistrations.Inc()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `realworld_http_request_duration_seconds_bucket{method="GET",route="/api/articles/:slug"`)
+	asserts.Contains(w.Body.String(), "realworld_user_registrations_total 1")
+	asserts.Contains(w.Body.String(), "go_goroutines")
+}
+
+func TestGormCallbacksAndDBStats(t *testing.T) {
+	asserts := assert.New(t)
+	db := common.TestDBInit()
+	db.LogMode(false)
+	defer common.TestDBFree(db)
+	RegisterGormCallbacks(db)
+	asserts.NoError(RegisterDBStats(db.DB(), "test"))
+
+	db.AutoMigrate(&metricsTestModel{})
+	model := metricsTestModel{Name: "a"}
+	asserts.NoError(db.Create(&model).Error)
+	asserts.NoError(db.Model(&model).Update("name", "b").Error)
+	var found metricsTestModel
+	asserts.NoError(db.First(&found, model.ID).Error)
+	asserts.Error(db.First(&found, 999).Error)
+	asserts.NoError(db.Delete(&model).Error)
+
+	asserts.Equal(float64(1), testutil.ToFloat64(DBQueries.WithLabelValues("create", "ok")))
+	asserts.Equal(float64(1), testutil.ToFloat64(DBQueries.WithLabelValues("update", "ok")))
+	asserts.Equal(float64(1), testutil.ToFloat64(DBQueries.WithLabelValues("query", "ok")))
+	asserts.Equal(float64(1), testutil.ToFloat64(DBQueries.WithLabelValues("query", "not_found")))
+	asserts.Equal(float64(1), testutil.ToFloat64(DBQueries.WithLabelValues("delete", "ok")))
+	asserts.Equal(4, testutil.CollectAndCount(DBDuration), "Every operation should have its own latency series")
+
+	count, err := testutil.GatherAndCount(Registry, "go_sql_open_connections")
+	asserts.NoError(err)
+	asserts.Equal(1, count)
+}
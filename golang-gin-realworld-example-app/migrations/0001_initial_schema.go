@@ -0,0 +1,52 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// The schema gorm AutoMigrate produced for users.UserModel, users.FollowModel and the articles models.
+// IF NOT EXISTS lets a database created by the old AutoMigrate boot adopt this version untouched.
+func init() {
+	Register(Migration{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS "user_models" ("id" integer primary key autoincrement,"username" varchar(255),"email" varchar(255),"bio" varchar(1024),"image" varchar(255),"password" varchar(255) NOT NULL )`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_user_models_email ON "user_models"("email")`,
+
+				`CREATE TABLE IF NOT EXISTS "follow_models" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"following_id" integer,"followed_by_id" integer )`,
+				`CREATE INDEX IF NOT EXISTS idx_follow_models_deleted_at ON "follow_models"(deleted_at)`,
+
+				`CREATE TABLE IF NOT EXISTS "article_models" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"slug" varchar(255),"title" varchar(255),"description" varchar(2048),"body" varchar(2048),"author_id" integer )`,
+				`CREATE INDEX IF NOT EXISTS idx_article_models_deleted_at ON "article_models"(deleted_at)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_article_models_slug ON "article_models"("slug")`,
+
+				`CREATE TABLE IF NOT EXISTS "article_tags" ("article_model_id" integer,"tag_model_id" integer, PRIMARY KEY ("article_model_id","tag_model_id"))`,
+
+				`CREATE TABLE IF NOT EXISTS "tag_models" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"tag" varchar(255) )`,
+				`CREATE INDEX IF NOT EXISTS idx_tag_models_deleted_at ON "tag_models"(deleted_at)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_tag_models_tag ON "tag_models"("tag")`,
+
+				`CREATE TABLE IF NOT EXISTS "favorite_models" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"favorite_id" integer,"favorite_by_id" integer )`,
+				`CREATE INDEX IF NOT EXISTS idx_favorite_models_deleted_at ON "favorite_models"(deleted_at)`,
+
+				`CREATE TABLE IF NOT EXISTS "article_user_models" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"user_model_id" integer )`,
+				`CREATE INDEX IF NOT EXISTS idx_article_user_models_deleted_at ON "article_user_models"(deleted_at)`,
+
+				`CREATE TABLE IF NOT EXISTS "comment_models" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"article_id" integer,"author_id" integer,"body" varchar(2048) )`,
+				`CREATE INDEX IF NOT EXISTS idx_comment_models_deleted_at ON "comment_models"(deleted_at)`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS "comment_models"`,
+				`DROP TABLE IF EXISTS "article_user_models"`,
+				`DROP TABLE IF EXISTS "favorite_models"`,
+				`DROP TABLE IF EXISTS "tag_models"`,
+				`DROP TABLE IF EXISTS "article_tags"`,
+				`DROP TABLE IF EXISTS "article_models"`,
+				`DROP TABLE IF EXISTS "follow_models"`,
+				`DROP TABLE IF EXISTS "user_models"`,
+			)
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// Composite indexes for the lookups done on every request: isFollowing, isFavoriteBy,
+// favoritesCount, the comments of an article and the articles of an author.
+func init() {
+	Register(Migration{
+		Version: 2,
+		Name:    "relation_indexes",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE INDEX idx_follow_models_following_followed_by ON "follow_models"("following_id","followed_by_id")`,
+				`CREATE INDEX idx_favorite_models_favorite_favorite_by ON "favorite_models"("favorite_id","favorite_by_id")`,
+				`CREATE INDEX idx_comment_models_article_id ON "comment_models"("article_id")`,
+				`CREATE INDEX idx_article_models_author_id ON "article_models"("author_id")`,
+				`CREATE INDEX idx_article_user_models_user_model_id ON "article_user_models"("user_model_id")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP INDEX IF EXISTS idx_article_user_models_user_model_id`,
+				`DROP INDEX IF EXISTS idx_article_models_author_id`,
+				`DROP INDEX IF EXISTS idx_comment_models_article_id`,
+				`DROP INDEX IF EXISTS idx_favorite_models_favorite_favorite_by`,
+				`DROP INDEX IF EXISTS idx_follow_models_following_followed_by`,
+			)
+		},
+	})
+}
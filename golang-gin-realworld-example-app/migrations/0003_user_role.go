@@ -0,0 +1,21 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.UserModel.Role, every existing account becomes a plain user.
+func init() {
+	Register(Migration{
+		Version: 3,
+		Name:    "user_role",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" ADD COLUMN "role" varchar(32) NOT NULL DEFAULT 'user'`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" DROP COLUMN "role"`,
+			)
+		},
+	})
+}
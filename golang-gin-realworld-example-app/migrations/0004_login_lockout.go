@@ -0,0 +1,31 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.LoginAttemptModel and the failure counters of users.UserModel behind the account lockout.
+func init() {
+	Register(Migration{
+		Version: 4,
+		Name:    "login_lockout",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" ADD COLUMN "failed_logins" integer NOT NULL DEFAULT 0`,
+				`ALTER TABLE "user_models" ADD COLUMN "last_failed_login_at" datetime`,
+				`ALTER TABLE "user_models" ADD COLUMN "locked_until" datetime`,
+
+				`CREATE TABLE "login_attempts" ("id" integer primary key autoincrement,"created_at" datetime,"email" varchar(255),"user_id" integer,"ip" varchar(64),"user_agent" varchar(512),"success" bool,"reason" varchar(32) )`,
+				// The per IP window count of UsersLogin, and the history of an account when investigating
+				`CREATE INDEX idx_login_attempts_ip_created_at ON "login_attempts"("ip","created_at")`,
+				`CREATE INDEX idx_login_attempts_user_id_created_at ON "login_attempts"("user_id","created_at")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE "login_attempts"`,
+				`ALTER TABLE "user_models" DROP COLUMN "locked_until"`,
+				`ALTER TABLE "user_models" DROP COLUMN "last_failed_login_at"`,
+				`ALTER TABLE "user_models" DROP COLUMN "failed_logins"`,
+			)
+		},
+	})
+}
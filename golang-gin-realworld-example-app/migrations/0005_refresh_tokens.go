@@ -0,0 +1,25 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.RefreshTokenModel, the hashed refresh tokens and their rotation state.
+func init() {
+	Register(Migration{
+		Version: 5,
+		Name:    "refresh_tokens",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE "refresh_tokens" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"family_id" varchar(64) NOT NULL,"token_hash" varchar(64) NOT NULL,"expires_at" datetime,"used_at" datetime,"revoked_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_refresh_tokens_token_hash ON "refresh_tokens"("token_hash")`,
+				// Revoking a family on reuse or logout, and every token of a user
+				`CREATE INDEX idx_refresh_tokens_family_id ON "refresh_tokens"("family_id")`,
+				`CREATE INDEX idx_refresh_tokens_user_id ON "refresh_tokens"("user_id")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE "refresh_tokens"`,
+			)
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.PasswordResetModel, and the reset time of users.UserModel refusing the older access tokens.
+func init() {
+	Register(Migration{
+		Version: 6,
+		Name:    "password_resets",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" ADD COLUMN "password_changed_at" datetime`,
+
+				`CREATE TABLE "password_resets" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"token_hash" varchar(64) NOT NULL,"expires_at" datetime,"used_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_password_resets_token_hash ON "password_resets"("token_hash")`,
+				// Invalidating the previous tokens of a user on a new request
+				`CREATE INDEX idx_password_resets_user_id ON "password_resets"("user_id")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE "password_resets"`,
+				`ALTER TABLE "user_models" DROP COLUMN "password_changed_at"`,
+			)
+		},
+	})
+}
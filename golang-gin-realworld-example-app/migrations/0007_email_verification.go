@@ -0,0 +1,28 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.EmailVerificationModel and the verification time of users.UserModel.
+// The existing accounts start unverified, they can ask for a link on POST /api/users/verify/resend.
+func init() {
+	Register(Migration{
+		Version: 7,
+		Name:    "email_verification",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" ADD COLUMN "email_verified_at" datetime`,
+
+				`CREATE TABLE "email_verifications" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"email" varchar(255) NOT NULL,"token_hash" varchar(64) NOT NULL,"expires_at" datetime,"used_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_email_verifications_token_hash ON "email_verifications"("token_hash")`,
+				// Invalidating the previous tokens of a user on a new one
+				`CREATE INDEX idx_email_verifications_user_id ON "email_verifications"("user_id")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE "email_verifications"`,
+				`ALTER TABLE "user_models" DROP COLUMN "email_verified_at"`,
+			)
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// The TOTP secret of users.UserModel, users.RecoveryCodeModel and users.LoginChallengeModel.
+func init() {
+	Register(Migration{
+		Version: 8,
+		Name:    "two_factor",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" ADD COLUMN "totp_secret" varchar(64)`,
+				`ALTER TABLE "user_models" ADD COLUMN "totp_enabled_at" datetime`,
+				`ALTER TABLE "user_models" ADD COLUMN "totp_last_step" bigint NOT NULL DEFAULT 0`,
+
+				`CREATE TABLE "recovery_codes" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"code_hash" varchar(64) NOT NULL,"used_at" datetime )`,
+				`CREATE INDEX idx_recovery_codes_user_id ON "recovery_codes"("user_id")`,
+
+				`CREATE TABLE "login_challenges" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"token_hash" varchar(64) NOT NULL,"expires_at" datetime,"attempts" integer NOT NULL DEFAULT 0,"used_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_login_challenges_token_hash ON "login_challenges"("token_hash")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE "login_challenges"`,
+				`DROP TABLE "recovery_codes"`,
+				`ALTER TABLE "user_models" DROP COLUMN "totp_last_step"`,
+				`ALTER TABLE "user_models" DROP COLUMN "totp_enabled_at"`,
+				`ALTER TABLE "user_models" DROP COLUMN "totp_secret"`,
+			)
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.IdentityModel and users.OIDCLoginModel, the logins with an OpenID provider.
+func init() {
+	Register(Migration{
+		Version: 9,
+		Name:    "identities",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE "identities" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"provider" varchar(64) NOT NULL,"subject" varchar(255) NOT NULL,"email" varchar(255),"last_login_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_identities_provider_subject ON "identities"("provider", "subject")`,
+				`CREATE INDEX idx_identities_user_id ON "identities"("user_id")`,
+
+				`CREATE TABLE "oidc_logins" ("id" integer primary key autoincrement,"created_at" datetime,"provider" varchar(64) NOT NULL,"state_hash" varchar(64) NOT NULL,"nonce" varchar(64) NOT NULL,"code_verifier" varchar(64) NOT NULL,"expires_at" datetime,"used_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_oidc_logins_state_hash ON "oidc_logins"("state_hash")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP TABLE "oidc_logins"`,
+				`DROP TABLE "identities"`,
+			)
+		},
+	})
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.AccessTokenModel, the personal access tokens.
+func init() {
+	Register(Migration{
+		Version: 10,
+		Name:    "access_tokens",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE "access_tokens" ("id" integer primary key autoincrement,"created_at" datetime,"user_id" integer NOT NULL,"name" varchar(100) NOT NULL,"token_hash" varchar(64) NOT NULL,"scopes" varchar(255) NOT NULL,"expires_at" datetime,"last_used_at" datetime,"revoked_at" datetime )`,
+				`CREATE UNIQUE INDEX uix_access_tokens_token_hash ON "access_tokens"("token_hash")`,
+				`CREATE INDEX idx_access_tokens_user_id ON "access_tokens"("user_id")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, `DROP TABLE "access_tokens"`)
+		},
+	})
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// users.UserModel.BannedAt and BanReason, the bans of the moderators.
+func init() {
+	Register(Migration{
+		Version: 11,
+		Name:    "user_bans",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" ADD COLUMN "banned_at" datetime`,
+				`ALTER TABLE "user_models" ADD COLUMN "ban_reason" varchar(255)`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "user_models" DROP COLUMN "ban_reason"`,
+				`ALTER TABLE "user_models" DROP COLUMN "banned_at"`,
+			)
+		},
+	})
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// articles.SlugHistoryModel, the former slugs of the renamed articles.
+func init() {
+	Register(Migration{
+		Version: 12,
+		Name:    "article_slugs",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE "article_slugs" ("id" integer primary key autoincrement,"created_at" datetime,"article_id" integer NOT NULL,"slug" varchar(255) NOT NULL )`,
+				`CREATE UNIQUE INDEX uix_article_slugs_slug ON "article_slugs"("slug")`,
+				`CREATE INDEX idx_article_slugs_article_id ON "article_slugs"("article_id")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, `DROP TABLE "article_slugs"`)
+		},
+	})
+}
@@ -0,0 +1,26 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// articles.ArticleModel.Status and PublishedAt, every existing article was published when created.
+func init() {
+	Register(Migration{
+		Version: 13,
+		Name:    "article_status",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`ALTER TABLE "article_models" ADD COLUMN "status" varchar(16) NOT NULL DEFAULT 'published'`,
+				`ALTER TABLE "article_models" ADD COLUMN "published_at" datetime`,
+				`UPDATE "article_models" SET "published_at" = "created_at"`,
+				`CREATE INDEX idx_article_models_status_published_at ON "article_models"("status","published_at")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`DROP INDEX IF EXISTS idx_article_models_status_published_at`,
+				`ALTER TABLE "article_models" DROP COLUMN "published_at"`,
+				`ALTER TABLE "article_models" DROP COLUMN "status"`,
+			)
+		},
+	})
+}
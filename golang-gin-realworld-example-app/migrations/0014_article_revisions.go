@@ -0,0 +1,21 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// articles.RevisionModel, the content of the articles after each edit. The existing
+// articles get their first revision on their next edit.
+func init() {
+	Register(Migration{
+		Version: 14,
+		Name:    "article_revisions",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE "article_revisions" ("id" integer primary key autoincrement,"created_at" datetime,"article_id" integer NOT NULL,"number" integer NOT NULL,"editor_id" integer NOT NULL,"title" varchar(255) NOT NULL,"description" varchar(2048),"body" varchar(2048),"tags" varchar(2048),"restored_from" integer )`,
+				`CREATE UNIQUE INDEX uix_article_revisions_article_number ON "article_revisions"("article_id","number")`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, `DROP TABLE "article_revisions"`)
+		},
+	})
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// The FTS5 index of articles.SearchArticles, filled with the existing articles. A binary built
+// without the sqlite_fts5 tag has no FTS5: the migration leaves the index out and
+// `serve` creates it at startup once the server runs a build with it.
+func init() {
+	Register(Migration{
+		Version: 15,
+		Name:    "article_search",
+		Up: func(tx *gorm.DB) error {
+			var fts5 bool
+			if err := tx.Raw(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Row().Scan(&fts5); err != nil {
+				return err
+			}
+			if !fts5 {
+				return nil
+			}
+			return execAll(tx,
+				`CREATE VIRTUAL TABLE "article_search" USING fts5("title","description","body","tags", tokenize = 'unicode61 remove_diacritics 2')`,
+				`INSERT INTO "article_search"("rowid","title","description","body","tags")
+					SELECT "id", "title", COALESCE("description", ''), COALESCE("body", ''),
+						COALESCE((SELECT group_concat("tag_models"."tag", ' ') FROM "article_tags" JOIN "tag_models" ON "tag_models"."id" = "article_tags"."tag_model_id" WHERE "article_tags"."article_model_id" = "article_models"."id"), '')
+					FROM "article_models" WHERE "deleted_at" IS NULL`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, `DROP TABLE IF EXISTS "article_search"`)
+		},
+	})
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// The writes the FTS5 index of migration 0015 missed, counted by a binary built without the
+// sqlite_fts5 tag while the index exists. `serve` rebuilds the index at startup when some were.
+func init() {
+	Register(Migration{
+		Version: 16,
+		Name:    "article_search_state",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				`CREATE TABLE "article_search_state" ("id" integer primary key,"missed_writes" integer NOT NULL DEFAULT 0)`,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, `DROP TABLE "article_search_state"`)
+		},
+	})
+}
@@ -0,0 +1,15 @@
+/*
+The migrations module containing the versioned, reversible schema changes.
+
+migrations.go: the runner applying/reverting migrations and recording them in schema_migrations
+
+NNNN_name.go: one file per migration, registering its Up and Down in init()
+
+To change the schema, add the next numbered file instead of relying on gorm AutoMigrate,
+which never drops or alters columns. Apply them with:
+
+	go run . migrate up
+	go run . migrate down [steps]
+	go run . migrate status
+*/
+package migrations
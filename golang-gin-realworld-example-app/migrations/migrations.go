@@ -0,0 +1,189 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A Migration is one numbered, reversible change of the schema.
+// Up and Down both run inside the same transaction that records the version,
+// so a failing statement leaves neither the schema nor schema_migrations half done.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// One row of schema_migrations, written when a migration has been applied.
+type SchemaMigration struct {
+	Version   uint `gorm:"primary_key"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// The state of a registered migration as reported by `migrate status`.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var registry = map[uint]Migration{}
+
+// Register adds a migration to the set known by Up/Down/Status, it's called from the init of each migration file.
+func Register(m Migration) {
+	if _, ok := registry[m.Version]; ok {
+		panic(fmt.Sprintf("migrations: version %d registered twice", m.Version))
+	}
+	registry[m.Version] = m
+}
+
+// All returns the registered migrations ordered by version.
+func All() []Migration {
+	all := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+func ensureTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS "schema_migrations" (` +
+		`"version" integer primary key,` +
+		`"name" varchar(255) NOT NULL,` +
+		`"applied_at" datetime NOT NULL)`).Error
+}
+
+func appliedVersions(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// GetStatus lists every registered migration with whether it has been applied.
+func GetStatus(db *gorm.DB) ([]Status, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, m := range All() {
+		row, ok := applied[m.Version]
+		statuses = append(statuses, Status{Migration: m, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// Pending returns the registered migrations which have not been applied yet, in the order Up would run them.
+func Pending(db *gorm.DB) ([]Migration, error) {
+	statuses, err := GetStatus(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in version order and returns the ones it ran.
+//
+//	applied, err := migrations.Up(common.GetDB())
+func Up(db *gorm.DB) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range pending {
+		err := run(db, m, m.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down rolls back the `steps` most recently applied migrations and returns the ones it reverted.
+//
+//	reverted, err := migrations.Down(common.GetDB(), 1)
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	statuses, err := GetStatus(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(statuses) - 1; i >= 0 && len(done) < steps; i-- {
+		m := statuses[i].Migration
+		if !statuses[i].Applied {
+			continue
+		}
+		err := run(db, m, m.Down, func(tx *gorm.DB) error {
+			return tx.Where("version = ?", m.Version).Delete(SchemaMigration{}).Error
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func run(db *gorm.DB, m Migration, change, record func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("migration %s: %w", m, tx.Error)
+	}
+	if change != nil {
+		if err := change(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m, err)
+		}
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s: %w", m, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("migration %s: %w", m, err)
+	}
+	return nil
+}
+
+// A helper to run plain SQL statements one by one, stopping at the first error.
+func execAll(tx *gorm.DB, statements ...string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
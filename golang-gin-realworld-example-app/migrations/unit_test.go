@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+func tableExists(db *gorm.DB, name string) bool {
+	var count int
+	db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Row().Scan(&count)
+	return count == 1
+}
+
+func TestUpDownStatus(t *testing.T) {
+	asserts := assert.New(t)
+	db := common.TestDBInit()
+	db.LogMode(false)
+	defer common.TestDBFree(db)
+
+	pending, err := Pending(db)
+	asserts.NoError(err)
+	asserts.Len(pending, len(All()), "Every migration should be pending on an empty database")
+
+	applied, err := Up(db)
+	asserts.NoError(err)
+	asserts.Len(applied, len(All()))
+	asserts.True(tableExists(db, "user_models"))
+	asserts.True(tableExists(db, "comment_models"))
+
+	applied, err = Up(db)
+	asserts.NoError(err)
+	asserts.Empty(applied, "Up should be idempotent")
+
+	statuses, err := GetStatus(db)
+	asserts.NoError(err)
+	for _, s := range statuses {
+		asserts.True(s.Applied, "%s should be applied", s.Migration)
+		asserts.False(s.AppliedAt.IsZero())
+	}
+
+	reverted, err := Down(db, 1)
+	asserts.NoError(err)
+	asserts.Len(reverted, 1)
+	asserts.Equal(All()[len(All())-1].Version, reverted[0].Version, "Down should revert the latest migration first")
+	pending, _ = Pending(db)
+	asserts.Len(pending, 1)
+
+	_, err = Down(db, len(All()))
+	asserts.NoError(err)
+	asserts.False(tableExists(db, "user_models"), "Reverting 0001 should drop the tables")
+	asserts.True(tableExists(db, "schema_migrations"))
+}
+
+func TestUpAdoptsAutoMigratedDatabase(t *testing.T) {
+	asserts := assert.New(t)
+	db := common.TestDBInit()
+	db.LogMode(false)
+	defer common.TestDBFree(db)
+
+	// The schema the old AutoMigrate boot left behind, without any schema_migrations record
+	asserts.NoError(registry[1].Up(db))
+	asserts.NoError(db.Exec(`INSERT INTO "user_models" ("username","email","password") VALUES ('adopted','adopted@example.com','x')`).Error)
+
+	_, err := Up(db)
+	asserts.NoError(err)
+	_, err = users.FindOneUser(context.Background(), &users.UserModel{Username: "adopted"})
+	asserts.NoError(err, "Existing rows should survive the initial migration")
+}
+
+func TestFailingMigrationIsRolledBack(t *testing.T) {
+	asserts := assert.New(t)
+	db := common.TestDBInit()
+	db.LogMode(false)
+	defer common.TestDBFree(db)
+	_, err := Up(db)
+	asserts.NoError(err)
+
+	broken := Migration{Version: 9999, Name: "broken", Up: func(tx *gorm.DB) error {
+		return execAll(tx, `CREATE TABLE "half_done" ("id" integer)`, `NOT SQL`)
+	}}
+	Register(broken)
+	defer delete(registry, broken.Version)
+
+	_, err = Up(db)
+	asserts.ErrorContains(err, "9999_broken")
+	asserts.False(tableExists(db, "half_done"), "The transaction should be rolled back")
+	pending, _ := Pending(db)
+	asserts.Len(pending, 1)
+}
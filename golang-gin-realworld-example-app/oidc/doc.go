@@ -0,0 +1,10 @@
+/*
+The oidc module containing the client side of the OpenID Connect login.
+
+provider.go: the discovery document, the authorization code flow with PKCE and the checks of the ID tokens
+
+oidctest: an in-process provider for the tests
+
+The accounts and identities the logins create or link are in the users module.
+*/
+package oidc
@@ -0,0 +1,347 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"realworld-backend/config"
+	"realworld-backend/jwtkeys"
+)
+
+var (
+	// The provider refused the code: wrong, expired, already used or not ours
+	ErrExchange = errors.New("oidc: code exchange refused")
+	// The ID token isn't signed by the provider, or not for this client, login or time
+	ErrIDToken = errors.New("oidc: invalid ID token")
+)
+
+// How often an unknown kid may refetch the keys of the provider, so forged tokens can't hammer it.
+const keysMinRefresh = time.Minute
+
+// The algorithms accepted for the ID tokens: never none nor the HMAC ones.
+var signingAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// The part of the discovery document of OpenID Connect Discovery 1.0 the login needs.
+type Metadata struct {
+	Issuer                  string   `json:"issuer"`
+	AuthorizationEndpoint   string   `json:"authorization_endpoint"`
+	TokenEndpoint           string   `json:"token_endpoint"`
+	JWKSURI                 string   `json:"jwks_uri"`
+	IDTokenSigningAlgValues []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// An OpenID provider, discovered on first use and its keys fetched and cached as needed.
+type Provider struct {
+	Name   string
+	config config.OIDCProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// The tokens of the token endpoint, only the ID token is used.
+type Tokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// The claims of an ID token the accounts are built from.
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     Bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+}
+
+// A boolean claim some providers send as the string "true".
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("oidc: not a boolean: %s", data)
+	}
+	return nil
+}
+
+// The provider is discovered on first use, a boot doesn't wait for it nor fail without it.
+//
+//	provider := oidc.NewProvider(cfg.OIDC.Providers[0], &http.Client{Timeout: 10 * time.Second})
+func NewProvider(cfg config.OIDCProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{Name: cfg.Name, config: cfg, client: client}
+}
+
+// A random PKCE code verifier of RFC 7636, kept by the server until the code comes back.
+// Panics without the randomness of the OS rather than hand out a predictable verifier.
+func NewCodeVerifier() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// The discovery document, fetched once: a failure is retried on the next login.
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+	var metadata Metadata
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return metadata, fmt.Errorf("oidc: discovery of %s: %w", p.Name, err)
+	}
+	// OpenID Connect Discovery 1.0 section 4.3, the document can't speak for another issuer
+	if metadata.Issuer != p.config.Issuer {
+		return metadata, fmt.Errorf("oidc: discovery of %s: issuer %q instead of %q", p.Name, metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return metadata, fmt.Errorf("oidc: discovery of %s: missing endpoints", p.Name)
+	}
+	p.metadata = &metadata
+	return metadata, nil
+}
+
+// The URL of the provider's login page for the authorization code flow with PKCE (S256).
+// state, nonce and verifier are random and kept by the caller for the callback.
+//
+//	authURL, err := provider.AuthCodeURL(ctx, state, nonce, oidc.NewCodeVerifier())
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization endpoint of %s: %w", p.Name, err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Trade the code of the callback for the tokens, authenticated with client_secret_basic
+// when the client has a secret.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (Tokens, error) {
+	var tokens Tokens
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return tokens, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokens, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// RFC 6749 section 2.3.1, both form-encoded before the basic scheme
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return tokens, fmt.Errorf("oidc: token endpoint of %s: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return tokens, fmt.Errorf("oidc: token endpoint of %s: %w", p.Name, err)
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		var refusal struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &refusal)
+		return tokens, fmt.Errorf("%w: %s %s", ErrExchange, refusal.Error, refusal.Description)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return tokens, fmt.Errorf("oidc: token endpoint of %s: status %d", p.Name, resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return tokens, fmt.Errorf("oidc: token endpoint of %s: %w", p.Name, err)
+	}
+	if tokens.IDToken == "" {
+		return tokens, fmt.Errorf("%w: no id_token, is the openid scope granted?", ErrIDToken)
+	}
+	return tokens, nil
+}
+
+// Check the ID token as OpenID Connect Core 1.0 section 3.1.3.7 requires: signed by a key
+// of the provider, issued by it for this client, unexpired and carrying the nonce of the login.
+//
+//	claims, err := provider.Verify(ctx, tokens.IDToken, nonce)
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	var claims Claims
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return claims, err
+	}
+	algorithms := []string{}
+	for _, alg := range signingAlgorithms {
+		// RS256 is the default of the discovery document
+		if len(metadata.IDTokenSigningAlgValues) == 0 && alg == "RS256" || contains(metadata.IDTokenSigningAlgValues, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods(algorithms))
+	var fetchErr error
+	_, err = parser.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, metadata.JWKSURI, kid)
+		fetchErr = err
+		return key, err
+	})
+	if err != nil {
+		var unreachable *keysError
+		if errors.As(fetchErr, &unreachable) {
+			return claims, fetchErr
+		}
+		return claims, fmt.Errorf("%w: %v", ErrIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return claims, fmt.Errorf("%w: issued by %q", ErrIDToken, claims.Issuer)
+	case !claims.VerifyAudience(p.config.ClientID, true):
+		return claims, fmt.Errorf("%w: not issued for this client", ErrIDToken)
+	case (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.config.ClientID:
+		return claims, fmt.Errorf("%w: authorized party %q", ErrIDToken, claims.AuthorizedParty)
+	case claims.ExpiresAt == nil:
+		return claims, fmt.Errorf("%w: no expiry", ErrIDToken)
+	case claims.Subject == "":
+		return claims, fmt.Errorf("%w: no subject", ErrIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return claims, fmt.Errorf("%w: nonce mismatch", ErrIDToken)
+	}
+	return claims, nil
+}
+
+// The provider's keys couldn't be fetched, unlike a token signed by an unknown key.
+type keysError struct{ err error }
+
+func (e *keysError) Error() string { return "oidc: keys: " + e.err.Error() }
+func (e *keysError) Unwrap() error { return e.err }
+
+// The key of the kid, refetching the key set when it's unknown: the provider rotated its keys.
+// An empty kid is only accepted from a set of a single key.
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < keysMinRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	var set jwtkeys.JSONWebKeySet
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, &keysError{err}
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Skip the key types we can't use, the others of the set still verify
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (p *Provider) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok && kid != ""
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/oidc/oidctest"
+)
+
+func TestLoginFlow(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+	idp := oidctest.NewServer(t, "realworld", "s3cret:&")
+	provider := NewProvider(idp.Config("corp", "http://localhost:4100/oidc/corp"), nil)
+
+	verifier := NewCodeVerifier()
+	authURL, err := provider.AuthCodeURL(ctx, "the-state", "the-nonce", verifier)
+	asserts.NoError(err)
+	parsed, _ := url.Parse(authURL)
+	asserts.Equal(idp.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	asserts.Equal("openid email profile", parsed.Query().Get("scope"))
+	asserts.NotContains(authURL, verifier, "The verifier should never leave the server")
+
+	code, state, err := idp.Authorize(authURL, jwt.MapClaims{"sub": "42", "email": "jake@corp.com", "email_verified": "true"})
+	asserts.NoError(err)
+	asserts.Equal("the-state", state)
+	_, err = provider.Exchange(ctx, code, NewCodeVerifier())
+	asserts.ErrorIs(err, ErrExchange, "Another verifier should be refused")
+
+	code, _, _ = idp.Authorize(authURL, jwt.MapClaims{"sub": "42", "email": "jake@corp.com", "email_verified": "true"})
+	tokens, err := provider.Exchange(ctx, code, verifier)
+	asserts.NoError(err)
+	claims, err := provider.Verify(ctx, tokens.IDToken, "the-nonce")
+	asserts.NoError(err)
+	asserts.Equal("42", claims.Subject)
+	asserts.Equal("jake@corp.com", claims.Email)
+	asserts.True(bool(claims.EmailVerified))
+
+	_, err = provider.Verify(ctx, tokens.IDToken, "another-nonce")
+	asserts.ErrorIs(err, ErrIDToken)
+	_, err = provider.Exchange(ctx, code, verifier)
+	asserts.ErrorIs(err, ErrExchange, "A code should only be exchanged once")
+}
+
+func TestVerifyRefuses(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+	idp := oidctest.NewServer(t, "realworld", "")
+	provider := NewProvider(idp.Config("corp", "http://localhost:4100/oidc/corp"), nil)
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": idp.URL, "aud": "realworld", "sub": "42", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix()}
+	}
+	_, err := provider.Verify(ctx, idp.Sign(valid()), "n")
+	asserts.NoError(err)
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"other issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"other client":   func(c jwt.MapClaims) { c["aud"] = "another-app" },
+		"other azp":      func(c jwt.MapClaims) { c["aud"], c["azp"] = []string{"realworld", "another-app"}, "another-app" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"without expiry": func(c jwt.MapClaims) { delete(c, "exp") },
+		"without sub":    func(c jwt.MapClaims) { delete(c, "sub") },
+		"without nonce":  func(c jwt.MapClaims) { delete(c, "nonce") },
+	} {
+		claims := valid()
+		change(claims)
+		_, err := provider.Verify(ctx, idp.Sign(claims), "n")
+		asserts.ErrorIs(err, ErrIDToken, name)
+	}
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid()).SignedString([]byte("realworld"))
+	_, err = provider.Verify(ctx, hmac, "n")
+	asserts.ErrorIs(err, ErrIDToken, "HS256 should never be accepted")
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = provider.Verify(ctx, none, "n")
+	asserts.ErrorIs(err, ErrIDToken)
+
+	config := idp.Config("typo", "http://localhost:4100/oidc/typo")
+	config.Issuer += "/"
+	_, err = NewProvider(config, nil).Metadata(ctx)
+	asserts.ErrorContains(err, "issuer")
+}
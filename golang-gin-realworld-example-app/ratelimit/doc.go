@@ -0,0 +1,8 @@
+/*
+The ratelimit module containing the request throttling of the server.
+
+store.go: the Store interface and the in-memory token bucket store
+
+middlewares.go: the Rule, its key functions (by IP, by user) and the gin middleware answering 429
+*/
+package ratelimit
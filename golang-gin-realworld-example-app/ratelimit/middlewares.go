@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+	"realworld-backend/metrics"
+)
+
+// Returns the bucket of a request within its rule, an empty key lets the request through.
+type KeyFunc func(c *gin.Context) string
+
+// One bucket per client IP, for the anonymous endpoints such as login.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// One bucket per authenticated user, see users.AuthMiddleware, falling back to the client IP.
+func ByUser(c *gin.Context) string {
+	if userID := c.GetUint("my_user_id"); userID != 0 {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return ByIP(c)
+}
+
+// Only limit the requests changing data, reads go through untouched.
+//
+//	ratelimit.WritesOnly(ratelimit.ByUser)
+func WritesOnly(key KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return ""
+		}
+		return key(c)
+	}
+}
+
+// A named limit over a route group, every rule has its own buckets.
+type Rule struct {
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// Take a token for every request matched by rule, answer 429 with Retry-After once the bucket is empty.
+// The X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (seconds) headers are always set.
+// A failing store lets the request through, an outage of the limiter shouldn't take the API down.
+//
+//	users.UsersRegister(v1.Group("/users", ratelimit.Middleware(store, rule)))
+func Middleware(store Store, rule Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := rule.Key(c)
+		if key == "" {
+			return
+		}
+		result, err := store.Take(c.Request.Context(), rule.Name+":"+key, rule.Limit)
+		if err != nil {
+			common.Log(c).Error("rate limit store error", "rule", rule.Name, "error", err.Error())
+			return
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(rule.Limit.Requests))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", ceilSeconds(result.Reset))
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(rule.Name).Inc()
+			c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, common.NewError("rate_limit", errors.New("Too many requests, retry later")))
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
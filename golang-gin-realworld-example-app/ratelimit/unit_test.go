@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStore(now *time.Time) *MemoryStore {
+	store := NewMemoryStore()
+	store.now = func() time.Time { return *now }
+	return store
+}
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	asserts := assert.New(t)
+	now := time.Unix(1700000000, 0)
+	store := newTestStore(&now)
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "a", limit)
+		asserts.NoError(err)
+		asserts.True(result.Allowed, "The burst should be allowed")
+		asserts.Equal(i, result.Remaining)
+	}
+	result, _ := store.Take(ctx, "a", limit)
+	asserts.False(result.Allowed, "The bucket should be empty")
+	asserts.Equal(time.Second, result.RetryAfter)
+	asserts.Equal(3*time.Second, result.Reset)
+
+	result, _ = store.Take(ctx, "b", limit)
+	asserts.True(result.Allowed, "Every key should have its own bucket")
+
+	now = now.Add(time.Second)
+	result, _ = store.Take(ctx, "a", limit)
+	asserts.True(result.Allowed, "One token should be refilled per second")
+	result, _ = store.Take(ctx, "a", limit)
+	asserts.False(result.Allowed)
+
+	now = now.Add(time.Hour)
+	store.Take(ctx, "c", limit)
+	asserts.Len(store.buckets, 1, "Full buckets should be swept")
+	result, _ = store.Take(ctx, "a", limit)
+	asserts.Equal(2, result.Remaining, "A swept bucket starts full again")
+}
+
+func TestMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	now := time.Unix(1700000000, 0)
+	store := newTestStore(&now)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("X-User") == "1" {
+			c.Set("my_user_id", uint(1))
+		}
+	})
+	r.Use(Middleware(store, Rule{Name: "write", Limit: Limit{Requests: 2, Period: time.Minute}, Key: WritesOnly(ByUser)}))
+	r.Any("/articles", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method, user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/articles", nil)
+		req.Header.Set("X-User", user)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "1")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("2", w.Header().Get("X-RateLimit-Limit"))
+	asserts.Equal("1", w.Header().Get("X-RateLimit-Remaining"))
+	asserts.Equal("30", w.Header().Get("X-RateLimit-Reset"))
+	do("PUT", "1")
+
+	w = do("DELETE", "1")
+	asserts.Equal(http.StatusTooManyRequests, w.Code)
+	asserts.Equal("30", w.Header().Get("Retry-After"))
+	asserts.Contains(w.Body.String(), "rate_limit")
+
+	w = do("GET", "1")
+	asserts.Equal(http.StatusOK, w.Code, "Reads shouldn't be limited")
+	asserts.Empty(w.Header().Get("X-RateLimit-Limit"))
+
+	w = do("POST", "")
+	asserts.Equal(http.StatusOK, w.Code, "An anonymous client should be limited by IP, not with user 1")
+}
@@ -8,7 +8,8 @@ echo "mode: atomic" > coverage.txt
 
 for d in $(find ./* -maxdepth 10 -type d); do
     if ls $d/*.go &> /dev/null; then
-        go test  -coverprofile=profile.out -covermode=atomic $d
+        # sqlite_fts5: the full-text search tests are skipped without it
+        go test -tags sqlite_fts5 -coverprofile=profile.out -covermode=atomic $d
         if [ -f profile.out ]; then
             echo "$(pwd)"
             cat profile.out | grep -v "mode: " >> coverage.txt
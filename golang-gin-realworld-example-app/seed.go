@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gosimple/slug"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/users"
+)
+
+// The account k6-tests/config.js logs in with, created by `seed` when it's missing.
+const (
+	perfTestUsername = "perftest"
+	perfTestEmail    = "perf-test@example.com"
+	perfTestPassword = "PerfTest123!"
+)
+
+var (
+	seedFirstNames = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy",
+		"mallory", "nina", "oscar", "peggy", "quentin", "rupert", "sybil", "trent", "ursula", "victor", "wendy"}
+	seedLastNames = []string{"smith", "johnson", "williams", "brown", "jones", "garcia", "miller", "davis",
+		"martinez", "lopez", "wilson", "anderson", "thomas", "taylor", "moore", "jackson", "martin", "lee"}
+	seedTags = []string{"golang", "gin", "gorm", "sqlite", "docker", "kubernetes", "testing", "performance",
+		"security", "react", "javascript", "typescript", "devops", "ci", "databases", "api", "design",
+		"architecture", "productivity", "career", "opensource", "linux", "cloud", "observability"}
+	seedTopics = []string{"Go modules", "table driven tests", "SQLite in production", "JWT authentication",
+		"rate limiting", "graceful shutdown", "structured logging", "load testing with k6", "CORS",
+		"database migrations", "React hooks", "code review", "feature flags", "caching", "pagination",
+		"error handling", "dependency injection", "container images", "continuous delivery", "profiling"}
+	seedTitleTemplates = []string{"Getting started with %s", "What I learned about %s", "A practical guide to %s",
+		"Ten mistakes with %s", "Why we moved to %s", "%s in five minutes", "Understanding %s", "Debugging %s"}
+	seedSentences = []string{
+		"We ran into this while scaling the service past a few hundred requests per second.",
+		"The first version worked fine on a laptop but fell over under real traffic.",
+		"Measuring before optimizing saved us from rewriting the wrong component.",
+		"Most of the complexity disappeared once the data model was right.",
+		"Tests caught the regression long before it reached production.",
+		"The documentation glosses over this detail, so here is what actually happens.",
+		"It is tempting to reach for a library, but the standard library covers most cases.",
+		"A small benchmark made the trade-off obvious to the whole team.",
+		"Keep the happy path short and handle errors where you have the context.",
+		"We rolled it out behind a flag and watched the dashboards for a week.",
+	}
+	seedComments = []string{"Great write-up, thanks!", "This saved me hours of debugging.",
+		"Have you tried measuring this with pprof?", "We did the same and it worked well.",
+		"Could you share the benchmark code?", "I disagree with the second point, but nice article.",
+		"Bookmarked for later.", "Clear and to the point."}
+)
+
+// The `seed` command: generate a realistic data set for the k6 load tests.
+func runSeed(cfg config.Config, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	userCount := flags.Int("users", 50, "number of users to create")
+	articleCount := flags.Int("articles", 200, "number of articles to create")
+	followCount := flags.Int("follows", 5, "number of users each user follows")
+	favoriteCount := flags.Int("favorites", 10, "number of articles each user favorites")
+	commentCount := flags.Int("comments", 3, "maximum number of comments per article")
+	password := flags.String("password", "password123", "password of every seeded user")
+	seed := flags.Int64("seed", 1, "random seed, the same seed generates the same data")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userCount < 1 {
+		return fmt.Errorf("need at least one user, got %d", *userCount)
+	}
+	if *articleCount < 0 || *followCount < 0 || *favoriteCount < 0 || *commentCount < 0 {
+		return fmt.Errorf("counts must not be negative")
+	}
+	ctx := context.Background()
+	rnd := rand.New(rand.NewSource(*seed))
+
+	// Hash the password once, bcrypt would otherwise dominate the run time.
+	template, err := users.NewValidatedUserModel("seeduser", "seed@example.com", *password)
+	if err != nil {
+		return err
+	}
+
+	// users.SaveOne and articles.SaveOne write through common.GetDB(),
+	// point it to a transaction so the whole seed is one sqlite commit.
+	tx := db.Begin()
+	common.DB = tx
+	defer func() { common.DB = db }()
+
+	if err := seedData(ctx, rnd, template.PasswordHash, *userCount, *articleCount, *followCount, *favoriteCount, *commentCount); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := seedPerfTestUser(ctx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	fmt.Printf("seeded %d users (password %q), %d articles, k6 user %s\n", *userCount, *password, *articleCount, perfTestEmail)
+	return nil
+}
+
+func seedData(ctx context.Context, rnd *rand.Rand, passwordHash string, userCount, articleCount, followCount, favoriteCount, commentCount int) error {
+	db := common.GetDB()
+	var offset int
+	db.Model(&users.UserModel{}).Count(&offset)
+
+	var userModels []users.UserModel
+	verifiedAt := time.Now()
+	var authors []articles.ArticleUserModel
+	for i := offset + 1; i <= offset+userCount; i++ {
+		first := seedFirstNames[rnd.Intn(len(seedFirstNames))]
+		last := seedLastNames[rnd.Intn(len(seedLastNames))]
+		userModel := users.UserModel{
+			Username:     fmt.Sprintf("%s%s%d", first, last, i),
+			Email:        fmt.Sprintf("%s.%s%d@example.com", first, last, i),
+			Bio:          fmt.Sprintf("%s %s writes about %s.", capitalize(first), capitalize(last), seedTopics[rnd.Intn(len(seedTopics))]),
+			PasswordHash: passwordHash,
+			// Seeded accounts can write even with auth.require_verified_email
+			EmailVerifiedAt: &verifiedAt,
+		}
+		if err := users.SaveOne(ctx, &userModel); err != nil {
+			return err
+		}
+		userModels = append(userModels, userModel)
+		authors = append(authors, articles.GetArticleUserModel(ctx, userModel))
+	}
+
+	tagModels := make([]articles.TagModel, len(seedTags))
+	for i, tag := range seedTags {
+		if err := db.FirstOrCreate(&tagModels[i], articles.TagModel{Tag: tag}).Error; err != nil {
+			return err
+		}
+	}
+
+	var articleModels []articles.ArticleModel
+	for i := 0; i < articleCount; i++ {
+		title := seedTitle(rnd)
+		articleModel := articles.ArticleModel{
+			Title:       title,
+			Description: seedSentences[rnd.Intn(len(seedSentences))],
+			Body:        seedBody(rnd),
+			Author:      authors[rnd.Intn(len(authors))],
+		}
+		for _, j := range rnd.Perm(len(tagModels))[:1+rnd.Intn(4)] {
+			articleModel.Tags = append(articleModel.Tags, tagModels[j])
+		}
+		if err := articles.CreateArticle(ctx, &articleModel); err != nil {
+			return err
+		}
+		articleModels = append(articleModels, articleModel)
+
+		for c := rnd.Intn(commentCount + 1); c > 0; c-- {
+			comment := articles.CommentModel{
+				ArticleID: articleModel.ID,
+				AuthorID:  authors[rnd.Intn(len(authors))].ID,
+				Body:      seedComments[rnd.Intn(len(seedComments))],
+			}
+			if err := articles.SaveOne(ctx, &comment); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, userModel := range userModels {
+		for _, j := range rnd.Perm(len(userModels))[:min(followCount, len(userModels))] {
+			if j == i {
+				continue
+			}
+			follow := users.FollowModel{FollowingID: userModels[j].ID, FollowedByID: userModel.ID}
+			if err := users.SaveOne(ctx, &follow); err != nil {
+				return err
+			}
+		}
+		if len(articleModels) == 0 {
+			continue
+		}
+		for _, j := range rnd.Perm(len(articleModels))[:min(favoriteCount, len(articleModels))] {
+			favorite := articles.FavoriteModel{FavoriteID: articleModels[j].ID, FavoriteByID: authors[i].ID}
+			if err := articles.SaveOne(ctx, &favorite); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A title whose slug isn't taken yet, so the seeded slugs don't need a suffix.
+func seedTitle(rnd *rand.Rand) string {
+	template := seedTitleTemplates[rnd.Intn(len(seedTitleTemplates))]
+	title := fmt.Sprintf(template, seedTopics[rnd.Intn(len(seedTopics))])
+	title = capitalize(title)
+	candidate := title
+	for n := 2; ; n++ {
+		var count int
+		common.GetDB().Model(&articles.ArticleModel{}).Where(&articles.ArticleModel{Slug: slug.Make(candidate)}).Count(&count)
+		if count == 0 {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s part %d", title, n)
+	}
+}
+
+// A few paragraphs of sentences, well under the 2048 characters accepted by the article validator.
+func seedBody(rnd *rand.Rand) string {
+	var paragraphs []string
+	for p := 2 + rnd.Intn(3); p > 0; p-- {
+		var sentences []string
+		for s := 2 + rnd.Intn(3); s > 0; s-- {
+			sentences = append(sentences, seedSentences[rnd.Intn(len(seedSentences))])
+		}
+		paragraphs = append(paragraphs, strings.Join(sentences, " "))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func seedPerfTestUser(ctx context.Context) error {
+	if _, err := users.FindOneUser(ctx, &users.UserModel{Email: perfTestEmail}); err == nil {
+		return nil
+	}
+	userModel, err := users.NewValidatedUserModel(perfTestUsername, perfTestEmail, perfTestPassword)
+	if err != nil {
+		return err
+	}
+	verifiedAt := time.Now()
+	userModel.EmailVerifiedAt = &verifiedAt
+	return users.SaveOne(ctx, &userModel)
+}
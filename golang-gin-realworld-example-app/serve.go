@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/metrics"
+	"realworld-backend/migrations"
+	"realworld-backend/tracing"
+)
+
+// The `serve` command, it returns once SIGINT/SIGTERM has drained the server
+// so main can close the database last.
+func runServe(cfg config.Config, db *gorm.DB, args []string) error {
+	if cfg.Database.AutoMigrate {
+		if err := Migrate(db); err != nil {
+			return err
+		}
+	}
+	if err := ensureSearchIndex(db); err != nil {
+		return err
+	}
+	if cfg.Metrics.Enabled {
+		metrics.RegisterGormCallbacks(db)
+		if err := metrics.RegisterDBStats(db.DB(), "main"); err != nil {
+			return err
+		}
+	}
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	tracing.RegisterGormCallbacks(db)
+	articles.StartScheduler(cfg.Articles.SchedulerInterval)
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr, // 0.0.0.0:8080 by default
+		Handler:           SetupRouter(cfg),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("listening on %s", ln.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = serveUntilDone(ctx, srv, ln, cfg.Server.ShutdownTimeout)
+
+	// Export the spans still batched, the requests drained above included
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		err = errors.Join(err, flushErr)
+	}
+	return err
+}
+
+// Serve on ln until ctx is done, then stop accepting connections, let the in-flight
+// handlers and the background work finish within shutdownTimeout.
+func serveUntilDone(ctx context.Context, srv *http.Server, ln net.Listener, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if bgErr := common.StopBackground(shutdownCtx); bgErr != nil {
+		err = errors.Join(err, bgErr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Printf("server stopped")
+	return nil
+}
+
+// Create the search index the article_search migration left out when it ran from a build
+// without FTS5, or rebuild the one such a build wrote articles past, once this binary has it.
+// Before that migration the index is left to it.
+func ensureSearchIndex(db *gorm.DB) error {
+	statuses, err := migrations.GetStatus(db)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Name == "article_search" && !status.Applied {
+			return nil
+		}
+	}
+	created, count, err := articles.EnsureSearchIndex(context.Background())
+	if created {
+		log.Printf("rebuilt the missing or stale search index of %d articles", count)
+	}
+	return err
+}
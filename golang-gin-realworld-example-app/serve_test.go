@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	asserts := assert.New(t)
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	asserts.NoError(err)
+
+	backgroundStopped := make(chan struct{})
+	common.RunBackground(func(ctx context.Context) {
+		<-ctx.Done()
+		close(backgroundStopped)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntilDone(ctx, &http.Server{Handler: mux}, ln, 5*time.Second)
+	}()
+
+	responded := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			responded <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		responded <- string(body)
+	}()
+	<-started
+	cancel()
+
+	asserts.Equal("done", <-responded, "The in-flight request should finish")
+	asserts.NoError(<-served)
+	<-backgroundStopped
+
+	_, err = http.Get("http://" + ln.Addr().String() + "/slow")
+	asserts.Error(err, "New connections should be refused after shutdown")
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	asserts := assert.New(t)
+	register := func(r http.Handler, forwardedFor string) int {
+		// httptest requests come from 192.0.2.1, the body fails validation before the database
+		req := httptest.NewRequest("POST", "/api/users/", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	cfg := config.Default()
+	cfg.RateLimit.AuthRequests = 1
+
+	r := SetupRouter(cfg)
+	asserts.Equal(http.StatusUnprocessableEntity, register(r, "198.51.100.1"))
+	asserts.Equal(http.StatusTooManyRequests, register(r, "198.51.100.2"), "A forged X-Forwarded-For shouldn't get a new bucket")
+
+	cfg.Server.TrustedProxies = []string{"192.0.2.1"}
+	r = SetupRouter(cfg)
+	asserts.Equal(http.StatusUnprocessableEntity, register(r, "198.51.100.1"))
+	asserts.Equal(http.StatusUnprocessableEntity, register(r, "198.51.100.2"), "The clients behind a trusted proxy should have their own buckets")
+	asserts.Equal(http.StatusTooManyRequests, register(r, "198.51.100.1"))
+}
@@ -0,0 +1,10 @@
+/*
+The tracing module containing the OpenTelemetry instrumentation of the server.
+
+tracing.go: the tracer provider, the pluggable span exporters (stdout, file) and the span helpers
+
+middlewares.go: the gin middleware reading the W3C traceparent and opening one span per request
+
+gorm.go: the gorm callbacks opening a span per statement, with the SQL and the row count
+*/
+package tracing
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"realworld-backend/common"
+)
+
+const spanKey = "tracing:span"
+
+// Hook a before/after callback around each gorm processor so every statement issued through
+// common.GetDBContext gets a span below the one of its context. The statement is recorded with
+// its placeholders, the bound values (password hashes, tokens) never reach the exporter.
+// Statements without a context, at boot or from the CLI, have no trace to join and aren't traced.
+//
+//	tracing.RegisterGormCallbacks(db)
+func RegisterGormCallbacks(db *gorm.DB) {
+	callback := db.Callback()
+	callback.Create().Before("gorm:begin_transaction").Register("tracing:before_create", before("create"))
+	callback.Create().After("gorm:commit_or_rollback_transaction").Register("tracing:after_create", after)
+	callback.Query().Before("gorm:query").Register("tracing:before_query", before("query"))
+	callback.Query().After("gorm:after_query").Register("tracing:after_query", after)
+	callback.Update().Before("gorm:begin_transaction").Register("tracing:before_update", before("update"))
+	callback.Update().After("gorm:commit_or_rollback_transaction").Register("tracing:after_update", after)
+	callback.Delete().Before("gorm:begin_transaction").Register("tracing:before_delete", before("delete"))
+	callback.Delete().After("gorm:commit_or_rollback_transaction").Register("tracing:after_delete", after)
+	callback.RowQuery().Before("gorm:row_query").Register("tracing:before_row", before("row"))
+	callback.RowQuery().After("gorm:row_query").Register("tracing:after_row", after)
+}
+
+func before(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(common.DBContextKey)
+		if !ok {
+			return
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.operation", operation),
+		}
+		// A raw Row() has no model to take the table from
+		if scope.Value != nil {
+			attrs = append(attrs, attribute.String("db.sql.table", scope.TableName()))
+		}
+		_, span := Start(value.(context.Context), "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+func after(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("db.statement", scope.SQL),
+		attribute.Int64("db.rows_affected", scope.DB().RowsAffected),
+	)
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
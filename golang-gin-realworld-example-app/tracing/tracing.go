@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.32.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+)
+
+// The instrumentation scope of every span the server opens.
+const instrumentationName = "realworld-backend"
+
+// Builds the span exporter selected by tracing.exporter.
+type ExporterFactory func(cfg config.TracingConfig) (sdktrace.SpanExporter, error)
+
+var exporters = map[string]ExporterFactory{
+	"stdout": newStdoutExporter,
+	"file":   newFileExporter,
+}
+
+// Make another exporter selectable from the config, an OTLP one for instance.
+//
+//	tracing.RegisterExporter("otlp", func(cfg config.TracingConfig) (sdktrace.SpanExporter, error) { ... })
+func RegisterExporter(name string, factory ExporterFactory) {
+	exporters[name] = factory
+}
+
+// Install the W3C trace-context propagator and, unless the exporter is "none", a tracer provider
+// batching the spans to the configured exporter. The returned func flushes the pending spans.
+//
+//	shutdown, err := tracing.Setup(cfg.Tracing)
+//	defer shutdown(ctx)
+func Setup(cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+	factory, ok := exporters[cfg.Exporter]
+	if !ok {
+		return nil, fmt.Errorf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+	exporter, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %s exporter: %w", cfg.Exporter, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+		// An incoming traceparent decides for itself, only new traces are sampled by ratio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// One JSON document per span on stdout, for local debugging.
+func newStdoutExporter(cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+}
+
+// One JSON document per span appended to tracing.file, the file is closed on shutdown.
+func newFileExporter(cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fileExporter{exporter, f}, nil
+}
+
+type fileExporter struct {
+	*stdouttrace.Exporter
+	f *os.File
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if closeErr := e.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Start a span as a child of the span in ctx, through the global tracer provider.
+//
+//	ctx, span := tracing.Start(ctx, "articles.FindManyArticle")
+//	defer span.End()
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// Start a span as a child of the request of c and make it the request context until the returned
+// func ends it, so the serializers and statements run through c nest below it.
+//
+//	ctx, end := tracing.StartGin(s.C, "ArticleSerializer.Response")
+//	defer end()
+func StartGin(c *gin.Context, name string) (context.Context, func()) {
+	ctx, span := Start(common.RequestContext(c), name)
+	request := c.Request
+	if request != nil {
+		c.Request = request.WithContext(ctx)
+	}
+	return ctx, func() {
+		c.Request = request
+		span.End()
+	}
+}
@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+)
+
+type tracingTestModel struct {
+	ID   uint `gorm:"primary_key"`
+	Name string
+}
+
+// Record every span in memory through the global provider, as Setup would install it.
+func newRecorder() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func attr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddlewareContinuesTraceparent(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := newRecorder()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/api/articles/:slug", func(c *gin.Context) {
+		_, end := StartGin(c, "ArticleSerializer.Response")
+		end()
+		c.Status(http.StatusNotFound)
+	})
+
+	req, _ := http.NewRequest("GET", "/api/articles/hello", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if asserts.Len(spans, 2) {
+		serializer, server := spans[0], spans[1]
+		asserts.Equal("GET /api/articles/:slug", server.Name(), "The span should be named by route template")
+		asserts.Equal("4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String(), "The incoming trace should be continued")
+		asserts.Equal("00f067aa0ba902b7", server.Parent().SpanID().String())
+		asserts.Equal(int64(404), attr(server, "http.response.status_code").AsInt64())
+		asserts.Equal(server.SpanContext().SpanID(), serializer.Parent().SpanID(), "A serializer span should nest below the request")
+	}
+}
+
+func TestStartGinRestoresRequest(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := newRecorder()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	// Serializer tests build contexts without request
+	_, end := StartGin(c, "outer")
+	end()
+	asserts.Nil(c.Request)
+
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	request := c.Request
+	ctx, end := StartGin(c, "outer")
+	asserts.Equal(ctx, c.Request.Context(), "The span should be the request context until it ends")
+	_, endInner := StartGin(c, "inner")
+	endInner()
+	end()
+	asserts.Same(request, c.Request)
+
+	spans := recorder.Ended()
+	asserts.Len(spans, 3)
+	asserts.Equal(spans[2].SpanContext().SpanID(), spans[1].Parent().SpanID())
+}
+
+func TestGormCallbacks(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := newRecorder()
+	db := common.TestDBInit()
+	db.LogMode(false)
+	defer common.TestDBFree(db)
+	RegisterGormCallbacks(db)
+	db.AutoMigrate(&tracingTestModel{})
+	asserts.Empty(recorder.Ended(), "Statements without context shouldn't be traced")
+
+	ctx, parent := Start(context.Background(), "request")
+	model := tracingTestModel{Name: "a"}
+	asserts.NoError(common.GetDBContext(ctx).Create(&model).Error)
+	var models []tracingTestModel
+	asserts.NoError(common.GetDBContext(ctx).Where(&tracingTestModel{Name: "a"}).Find(&models).Error)
+	var missing tracingTestModel
+	common.GetDBContext(ctx).First(&missing, 1000)
+	parent.End()
+
+	spans := recorder.Ended()
+	if asserts.Len(spans, 4) {
+		create, query, notFound := spans[0], spans[1], spans[2]
+		asserts.Equal("gorm.create", create.Name())
+		asserts.True(strings.HasPrefix(attr(create, "db.statement").AsString(), "INSERT INTO"))
+		asserts.Equal(int64(1), attr(create, "db.rows_affected").AsInt64())
+		asserts.Equal("tracing_test_models", attr(create, "db.sql.table").AsString())
+		asserts.Equal(parent.SpanContext().SpanID(), create.Parent().SpanID())
+
+		asserts.Equal("gorm.query", query.Name())
+		asserts.Contains(attr(query, "db.statement").AsString(), "SELECT")
+		asserts.NotContains(attr(query, "db.statement").AsString(), "'a'", "Bound values shouldn't be recorded")
+		asserts.Equal(int64(1), attr(query, "db.rows_affected").AsInt64())
+		asserts.Empty(notFound.Events(), "A missing row isn't an error")
+	}
+}
+
+func TestSetupFileExporter(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := config.Default().Tracing
+
+	cfg.Exporter = "otlp"
+	_, err := Setup(cfg)
+	asserts.ErrorContains(err, `unknown exporter "otlp"`)
+
+	cfg.Exporter = "file"
+	cfg.File = filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Setup(cfg)
+	asserts.NoError(err)
+	_, span := Start(context.Background(), "exported")
+	span.End()
+	asserts.NoError(shutdown(context.Background()))
+
+	content, err := os.ReadFile(cfg.File)
+	asserts.NoError(err)
+	asserts.Contains(string(content), `"Name":"exported"`)
+	asserts.Contains(string(content), "realworld-backend", "The service name should be exported")
+}
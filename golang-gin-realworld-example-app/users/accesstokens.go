@@ -0,0 +1,180 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// A named personal access token of a user for the scripts, limited to its scopes.
+// Only its SHA-256 is stored like the refresh tokens, it's shown once at creation.
+type AccessTokenModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null;index"`
+	Name      string `gorm:"column:name;size:100;not null"`
+	TokenHash string `gorm:"column:token_hash;size:64;not null;unique_index"`
+	// Space separated, see Scopes
+	Scopes     string `gorm:"column:scopes;size:255;not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+func (AccessTokenModel) TableName() string {
+	return "access_tokens"
+}
+
+// Tells the personal access tokens from the JWTs in the Authorization header, and secret scanners where to look.
+const AccessTokenPrefix = "rwpat_"
+
+// What a personal access token may do, checked by RequireScope on every route.
+const (
+	ScopeArticlesRead  = "articles:read"
+	ScopeArticlesWrite = "articles:write"
+	ScopeCommentsRead  = "comments:read"
+	ScopeCommentsWrite = "comments:write"
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+)
+
+var Scopes = []string{ScopeArticlesRead, ScopeArticlesWrite, ScopeCommentsRead, ScopeCommentsWrite, ScopeProfileRead, ScopeProfileWrite}
+
+// The tokens a user can hold at once, revoked ones aside.
+const maxAccessTokens = 50
+
+// LastUsedAt is written at most that often, not on every request of a busy script.
+const accessTokenUsePrecision = time.Minute
+
+var (
+	ErrAccessTokenInvalid = errors.New("access token is invalid, revoked or expired")
+	ErrAccessTokenLimit   = fmt.Errorf("at most %d access tokens, revoke one first", maxAccessTokens)
+	ErrSessionRequired    = errors.New("log in to do this, access tokens can't")
+)
+
+func (m AccessTokenModel) ScopeList() []string {
+	return strings.Fields(m.Scopes)
+}
+
+// Create a token for the user, returning it in the clear: it can't be shown again.
+//
+//	token, model, err := IssueAccessToken(ctx, userModel.ID, "ci", []string{ScopeArticlesWrite}, nil)
+func IssueAccessToken(ctx context.Context, userID uint, name string, scopes []string, expiresAt *time.Time) (string, AccessTokenModel, error) {
+	token := AccessTokenPrefix + randomToken()
+	model := AccessTokenModel{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	err := common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int
+		if err := tx.Model(&AccessTokenModel{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxAccessTokens {
+			return ErrAccessTokenLimit
+		}
+		return tx.Create(&model).Error
+	})
+	return token, model, err
+}
+
+// The active token, unrevoked and unexpired.
+func FindAccessToken(ctx context.Context, token string) (AccessTokenModel, error) {
+	var model AccessTokenModel
+	db := common.GetDBContext(ctx)
+	err := db.Where(&AccessTokenModel{TokenHash: hashToken(token)}).First(&model).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return model, ErrAccessTokenInvalid
+	}
+	if err != nil {
+		return model, err
+	}
+	now := time.Now()
+	if model.RevokedAt != nil || (model.ExpiresAt != nil && now.After(*model.ExpiresAt)) {
+		return model, ErrAccessTokenInvalid
+	}
+	return model, nil
+}
+
+// Record the use of the token, at most once per accessTokenUsePrecision.
+func (m AccessTokenModel) touch(ctx context.Context) error {
+	now := time.Now()
+	return common.GetDBContext(ctx).Model(&AccessTokenModel{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", m.ID, now.Add(-accessTokenUsePrecision)).
+		UpdateColumn("last_used_at", now).Error
+}
+
+// The tokens of the user not revoked, newest first, expired ones included.
+func (u UserModel) AccessTokens(ctx context.Context) ([]AccessTokenModel, error) {
+	var models []AccessTokenModel
+	err := common.GetDBContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL", u.ID).
+		Order("id desc").
+		Find(&models).Error
+	return models, err
+}
+
+// Revoke the token of the user, ErrAccessTokenInvalid when it isn't one of theirs.
+func (u UserModel) RevokeAccessToken(ctx context.Context, id uint) error {
+	result := common.GetDBContext(ctx).Model(&AccessTokenModel{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, u.ID).
+		UpdateColumn("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccessTokenInvalid
+	}
+	return nil
+}
+
+// The scopes of the request's access token, nil for a login session or an anonymous request.
+func requestScopes(c *gin.Context) []string {
+	scopes, _ := c.Get("my_token_scopes")
+	list, _ := scopes.([]string)
+	return list
+}
+
+// Refuse a request made with an access token lacking the scope, the login sessions have every scope.
+// Goes after AuthMiddleware, anonymous requests pass.
+//
+//	router.POST("/", users.RequireScope(users.ScopeArticlesWrite), ArticleCreate)
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes := requestScopes(c)
+		if scopes == nil {
+			return
+		}
+		for _, granted := range scopes {
+			if granted == scope {
+				return
+			}
+		}
+		// RFC 6750 section 3.1
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+		c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("scope", fmt.Errorf("the access token lacks the %s scope", scope)))
+	}
+}
+
+// Refuse the access tokens on the routes managing the account: a token can't mint tokens
+// nor turn off the second factor.
+//
+//	router.POST("/tokens", users.SessionRequired(), AccessTokenCreate)
+func SessionRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requestScopes(c) != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("token", ErrSessionRequired))
+		}
+	}
+}
@@ -8,5 +8,23 @@ routers.go: router binding and core logic
 serializers.go: definition the schema of return data
 
 validators.go: definition the validator of form data
+
+tokens.go: hashed refresh tokens, their rotation and revocation
+
+lockout.go: login attempt history, progressive delays and temporary lockout after failed logins
+
+sessions.go: the optional session cookie and its double-submit CSRF token
+
+passwords.go: the password reset tokens and the emails sent to the accounts
+
+verification.go: the email verification tokens and the middleware requiring a verified email
+
+twofactor.go: TOTP two-factor authentication, its recovery codes and the challenges of the second login step
+
+identities.go: the logins with an OpenID provider and the identities linking its accounts to the users
+
+accesstokens.go: the personal access tokens of the scripts and the middlewares checking their scopes
+
+roles.go: the roles, their permissions, the bans and the search of the users for the admin API
 */
 package users
@@ -0,0 +1,280 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+	"realworld-backend/oidc"
+)
+
+// An account of an OpenID provider linked to a user, who logs in with it from then on.
+type IdentityModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null;index"`
+	Provider  string `gorm:"column:provider;size:64;not null;unique_index:uix_identities_provider_subject"`
+	Subject   string `gorm:"column:subject;size:255;not null;unique_index:uix_identities_provider_subject"`
+	// The email of the last login, as the provider knows it
+	Email       string `gorm:"column:email;size:255"`
+	LastLoginAt *time.Time
+}
+
+func (IdentityModel) TableName() string {
+	return "identities"
+}
+
+// A login sent to the provider, waiting for its code. Found by the state, hashed like the tokens,
+// the nonce and PKCE verifier are needed in the clear to finish it.
+type OIDCLoginModel struct {
+	ID           uint `gorm:"primary_key"`
+	CreatedAt    time.Time
+	Provider     string `gorm:"column:provider;size:64;not null"`
+	StateHash    string `gorm:"column:state_hash;size:64;not null;unique_index"`
+	Nonce        string `gorm:"column:nonce;size:64;not null"`
+	CodeVerifier string `gorm:"column:code_verifier;size:64;not null"`
+	ExpiresAt    time.Time
+	UsedAt       *time.Time
+}
+
+func (OIDCLoginModel) TableName() string {
+	return "oidc_logins"
+}
+
+// The providers by name and whether their unknown users get an account, set from the config at boot.
+var (
+	OIDCProviders     = map[string]*oidc.Provider{}
+	OIDCAllowSignup   = true
+	OIDCLoginLifetime = 10 * time.Minute
+)
+
+// How LoginWithIdentity found the user.
+const (
+	IdentityExisting = "existing"
+	IdentityLinked   = "linked"
+	IdentityCreated  = "created"
+)
+
+// The cookie binding a login to the browser that started it, so a state can't be
+// finished from another one, e.g. the victim of a login CSRF.
+const (
+	OIDCStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/users/oidc"
+)
+
+var (
+	ErrOIDCProvider        = errors.New("unknown identity provider")
+	ErrOIDCUnavailable     = errors.New("the identity provider is unavailable")
+	ErrOIDCState           = errors.New("login state is invalid or expired, start again")
+	ErrOIDCEmailUnverified = errors.New("the identity provider didn't verify the email of the account")
+	ErrOIDCSignupDisabled  = errors.New("no account with this email, sign up first")
+)
+
+// Prepare a login with the provider, returning the URL of its login page and the state
+// it sends back with the code.
+//
+//	authURL, state, err := StartOIDCLogin(ctx, OIDCProviders["corp"])
+func StartOIDCLogin(ctx context.Context, provider *oidc.Provider) (string, string, error) {
+	state, nonce, verifier := randomToken(), randomToken(), oidc.NewCodeVerifier()
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrOIDCUnavailable, err)
+	}
+	model := OIDCLoginModel{
+		Provider:     provider.Name,
+		StateHash:    hashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(OIDCLoginLifetime),
+	}
+	if err := common.GetDBContext(ctx).Create(&model).Error; err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+func setOIDCStateCookie(c *gin.Context, state string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCStateCookie, state, int(OIDCLoginLifetime.Seconds()), oidcStateCookiePath, "", SecureCookies, true)
+}
+
+func clearOIDCStateCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCStateCookie, "", -1, oidcStateCookiePath, "", SecureCookies, true)
+}
+
+// Whether the state was started by this browser, the one in its cookie.
+func oidcStateCookieMatches(c *gin.Context, state string) bool {
+	cookie, err := c.Cookie(OIDCStateCookie)
+	return err == nil && cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) == 1
+}
+
+// Consume the login of the state, trade the code for the ID token and log its user in.
+// The errors of the provider wrap oidc.ErrExchange or oidc.ErrIDToken, else ErrOIDCUnavailable.
+//
+//	userModel, outcome, err := FinishOIDCLogin(ctx, provider, code, state)
+func FinishOIDCLogin(ctx context.Context, provider *oidc.Provider, code, state string) (UserModel, string, error) {
+	var model OIDCLoginModel
+	db := common.GetDBContext(ctx)
+	err := db.Where(&OIDCLoginModel{StateHash: hashToken(state), Provider: provider.Name}).First(&model).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return UserModel{}, "", ErrOIDCState
+	}
+	if err != nil {
+		return UserModel{}, "", err
+	}
+	now := time.Now()
+	if model.UsedAt != nil || now.After(model.ExpiresAt) {
+		return UserModel{}, "", ErrOIDCState
+	}
+	// Conditional on used_at, a state finishes one login
+	result := db.Model(&OIDCLoginModel{}).Where("id = ? AND used_at IS NULL", model.ID).UpdateColumn("used_at", now)
+	if result.Error != nil {
+		return UserModel{}, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return UserModel{}, "", ErrOIDCState
+	}
+
+	tokens, err := provider.Exchange(ctx, code, model.CodeVerifier)
+	if err == nil {
+		var claims oidc.Claims
+		if claims, err = provider.Verify(ctx, tokens.IDToken, model.Nonce); err == nil {
+			return LoginWithIdentity(ctx, provider.Name, claims)
+		}
+	}
+	if errors.Is(err, oidc.ErrExchange) || errors.Is(err, oidc.ErrIDToken) {
+		return UserModel{}, "", err
+	}
+	return UserModel{}, "", fmt.Errorf("%w: %v", ErrOIDCUnavailable, err)
+}
+
+// The user of the identity of the verified ID token. An unknown identity is linked to the
+// account of its email when the provider verified it, or gets a new account if signups are allowed.
+//
+//	userModel, outcome, err := LoginWithIdentity(ctx, "corp", claims)
+func LoginWithIdentity(ctx context.Context, provider string, claims oidc.Claims) (UserModel, string, error) {
+	var userModel UserModel
+	var outcome string
+	now := time.Now()
+	err := common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var identity IdentityModel
+		err := tx.Where(&IdentityModel{Provider: provider, Subject: claims.Subject}).First(&identity).Error
+		if err == nil {
+			outcome = IdentityExisting
+			if err := tx.First(&userModel, identity.UserID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&identity).UpdateColumns(map[string]interface{}{
+				"email":         claims.Email,
+				"last_login_at": now,
+			}).Error
+		}
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		// Without the provider's word an attacker could claim the email of any account
+		if claims.Email == "" || !claims.EmailVerified {
+			return ErrOIDCEmailUnverified
+		}
+		err = tx.Where("LOWER(email) = LOWER(?)", claims.Email).First(&userModel).Error
+		switch {
+		case err == nil:
+			outcome = IdentityLinked
+		case !gorm.IsRecordNotFoundError(err):
+			return err
+		case !OIDCAllowSignup:
+			return ErrOIDCSignupDisabled
+		default:
+			outcome = IdentityCreated
+			if userModel, err = newIdentityUser(tx, claims); err != nil {
+				return err
+			}
+		}
+		if userModel.EmailVerifiedAt == nil {
+			if err := tx.Model(&userModel).UpdateColumn("email_verified_at", now).Error; err != nil {
+				return err
+			}
+			userModel.EmailVerifiedAt = &now
+		}
+		identity = IdentityModel{
+			UserID:      userModel.ID,
+			Provider:    provider,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: &now,
+		}
+		return tx.Create(&identity).Error
+	})
+	return userModel, outcome, err
+}
+
+// The identities of the user, oldest first.
+func (u UserModel) Identities(ctx context.Context) ([]IdentityModel, error) {
+	var identities []IdentityModel
+	err := common.GetDBContext(ctx).Where(&IdentityModel{UserID: u.ID}).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// An account for the identity, with a random password: the user can choose one with a reset.
+func newIdentityUser(tx *gorm.DB, claims oidc.Claims) (UserModel, error) {
+	base := identityUsername(claims)
+	username := base
+	for i := 0; ; i++ {
+		var count int
+		if err := tx.Model(&UserModel{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return UserModel{}, err
+		}
+		if count == 0 {
+			break
+		}
+		if i == 10 {
+			return UserModel{}, fmt.Errorf("no free username for %q", base)
+		}
+		suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return UserModel{}, err
+		}
+		username = fmt.Sprintf("%s%04d", base, suffix)
+	}
+	userModel, err := NewValidatedUserModel(username, claims.Email, randomToken())
+	if err != nil {
+		return UserModel{}, err
+	}
+	if claims.Picture != "" {
+		userModel.Image = &claims.Picture
+	}
+	return userModel, tx.Create(&userModel).Error
+}
+
+// The letters and digits of the preferred username, else of the email's local part or name,
+// as the usernames of the registrations.
+func identityUsername(claims oidc.Claims) string {
+	for _, candidate := range []string{claims.PreferredUsername, claims.Email, claims.Name} {
+		candidate, _, _ = strings.Cut(candidate, "@")
+		username := strings.Map(func(r rune) rune {
+			if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, candidate)
+		if len(username) > 32 {
+			username = username[:32]
+		}
+		if len(username) >= 4 {
+			return username
+		}
+	}
+	return "user"
+}
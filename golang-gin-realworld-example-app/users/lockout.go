@@ -0,0 +1,209 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+	"realworld-backend/metrics"
+)
+
+// One row per login request, kept to investigate credential stuffing and brute force attacks.
+// UserID is nil when the email didn't match any account.
+type LoginAttemptModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	Email     string `gorm:"column:email;size:255"`
+	UserID    *uint  `gorm:"column:user_id"`
+	// c.ClientIP(): the peer, or the X-Forwarded-For of a proxy in server.trusted_proxies
+	IP        string `gorm:"column:ip;size:64"`
+	UserAgent string `gorm:"column:user_agent;size:512"`
+	Success   bool   `gorm:"column:success"`
+	// Why a failed attempt was rejected, one of the Attempt* constants
+	Reason string `gorm:"column:reason;size:32"`
+}
+
+func (LoginAttemptModel) TableName() string {
+	return "login_attempts"
+}
+
+// The values of LoginAttemptModel.Reason.
+const (
+	AttemptUnknownEmail = "unknown_email"
+	AttemptBadPassword  = "bad_password"
+	AttemptLocked       = "locked"
+	AttemptThrottled    = "throttled"
+	AttemptIPBlocked    = "ip_blocked"
+	// The password was right, the 2FA code wasn't
+	AttemptBadSecondFactor = "bad_second_factor"
+	// The credentials were right, the account is banned
+	AttemptBanned = "banned"
+)
+
+// When failed logins slow down, then lock, an account or an IP.
+type LockoutPolicy struct {
+	// Failures in a row locking the account for LockDuration
+	MaxFailures  int
+	LockDuration time.Duration
+	// The wait imposed after the first failure, doubled by each further one up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures from one IP, on any account, within IPWindow blocking that IP
+	IPMaxFailures int
+	IPWindow      time.Duration
+}
+
+// The lockout policy of UsersLogin, overwritten from the config at boot.
+var Lockout = LockoutPolicy{
+	MaxFailures:   5,
+	LockDuration:  15 * time.Minute,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+	IPMaxFailures: 20,
+	IPWindow:      15 * time.Minute,
+}
+
+// The wait required after `failures` failures in a row.
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// How long the account still refuses to log in, zero when it may try now.
+// The second value is the Reason to record, AttemptLocked or AttemptThrottled.
+//
+//	if wait, reason := userModel.loginWait(time.Now()); wait > 0 { ... }
+func (u UserModel) loginWait(now time.Time) (time.Duration, string) {
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		return u.LockedUntil.Sub(now), AttemptLocked
+	}
+	if u.LastFailedLoginAt != nil {
+		if ready := u.LastFailedLoginAt.Add(Lockout.delay(u.FailedLogins)); now.Before(ready) {
+			return ready.Sub(now), AttemptThrottled
+		}
+	}
+	return 0, ""
+}
+
+// Count a wrong password, locking the account once Lockout.MaxFailures is reached.
+// The counter is incremented in SQL so concurrent attempts can't lose a failure.
+// Returns true when this failure locked the account.
+func (u *UserModel) recordLoginFailure(ctx context.Context, now time.Time) (bool, error) {
+	db := common.GetDBContext(ctx)
+	err := db.Model(u).UpdateColumns(map[string]interface{}{
+		"failed_logins":        gorm.Expr("failed_logins + 1"),
+		"last_failed_login_at": now,
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	if err := db.Select("failed_logins").First(u, u.ID).Error; err != nil {
+		return false, err
+	}
+	if u.FailedLogins < Lockout.MaxFailures {
+		return false, nil
+	}
+	lockedUntil := now.Add(Lockout.LockDuration)
+	// Start over once the lock expires, with the progressive delay again
+	err = db.Model(u).UpdateColumns(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  lockedUntil,
+	}).Error
+	u.LockedUntil = &lockedUntil
+	return err == nil, err
+}
+
+// Forget the failures of the account and lift its lock, after a successful login or by an operator.
+//
+//	err := userModel.Unlock(ctx)
+func (u *UserModel) Unlock(ctx context.Context) error {
+	if u.FailedLogins == 0 && u.LastFailedLoginAt == nil && u.LockedUntil == nil {
+		return nil
+	}
+	err := common.GetDBContext(ctx).Model(u).UpdateColumns(map[string]interface{}{
+		"failed_logins":        0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}).Error
+	if err == nil {
+		u.FailedLogins, u.LastFailedLoginAt, u.LockedUntil = 0, nil, nil
+	}
+	return err
+}
+
+// The failed attempts from ip within Lockout.IPWindow.
+func countIPFailures(ctx context.Context, ip string, now time.Time) (int, error) {
+	var count int
+	err := common.GetDBContext(ctx).Model(&LoginAttemptModel{}).
+		Where("ip = ? AND success = ? AND created_at > ?", ip, false, now.Add(-Lockout.IPWindow)).
+		Count(&count).Error
+	return count, err
+}
+
+// Store the attempt, a failure to do so is logged but never fails the login.
+func recordLoginAttempt(c *gin.Context, attempt LoginAttemptModel) {
+	err := common.GetDBContext(c.Request.Context()).Create(&attempt).Error
+	common.LogDBError(c, err)
+}
+
+// A Retry-After value in whole seconds, rounded up.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Answer 403 to a locked account, 429 to a throttled one, and tell whether it did.
+func refuseLockedLogin(c *gin.Context, userModel UserModel, attempt LoginAttemptModel, now time.Time) bool {
+	wait, reason := userModel.loginWait(now)
+	if wait <= 0 {
+		return false
+	}
+	attempt.Reason = reason
+	recordLoginAttempt(c, attempt)
+	c.Header("Retry-After", retryAfter(wait))
+	if reason == AttemptLocked {
+		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Account temporarily locked after too many failed logins")))
+	} else {
+		c.JSON(http.StatusTooManyRequests, common.NewError("login", errors.New("Too many failed logins, retry later")))
+	}
+	return true
+}
+
+// Count a wrong password or code of a known account, and record the attempt with reason.
+func countLoginFailure(c *gin.Context, userModel *UserModel, attempt LoginAttemptModel, reason string, now time.Time) {
+	metrics.LoginsFailed.Inc()
+	locked, err := userModel.recordLoginFailure(c.Request.Context(), now)
+	common.LogDBError(c, err)
+	if locked {
+		metrics.AccountLockouts.Inc()
+		common.Log(c).Warn("account locked", "user_id", userModel.ID, "until", userModel.LockedUntil)
+	}
+	attempt.Reason = reason
+	recordLoginAttempt(c, attempt)
+}
+
+// Answer 403 to a banned user whose credentials were right, and tell whether it did.
+func refuseBannedLogin(c *gin.Context, userModel UserModel, attempt LoginAttemptModel) bool {
+	if !userModel.IsBanned() {
+		return false
+	}
+	attempt.Reason = AttemptBanned
+	recordLoginAttempt(c, attempt)
+	c.JSON(http.StatusForbidden, common.NewError("login", ErrBanned))
+	return true
+}
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"errors"
 	"net/http"
 	"realworld-backend/common"
 	"strings"
@@ -9,42 +10,46 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// Extract token from Authorization header or access_token parameter
-func extractTokenFromRequest(c *gin.Context) (string, error) {
+// Extract token from Authorization header, access_token parameter or session cookie.
+// The second value tells the token came from the session cookie, so the request needs a CSRF token.
+func extractTokenFromRequest(c *gin.Context) (string, bool, error) {
 	// First try Authorization header
 	authHeader := c.GetHeader("Authorization")
 	if authHeader != "" {
 		// Should be a bearer token
 		if len(authHeader) > 6 && strings.ToUpper(authHeader[0:6]) == "TOKEN " {
-			return authHeader[6:], nil
+			return authHeader[6:], false, nil
 		}
-		return authHeader, nil
+		return authHeader, false, nil
 	}
 
-	// Try access_token parameter
-	tokenParam := c.Query("access_token")
-	if tokenParam != "" {
-		return tokenParam, nil
+	if QueryTokens {
+		// Try access_token parameter
+		tokenParam := c.Query("access_token")
+		if tokenParam != "" {
+			return tokenParam, false, nil
+		}
+
+		// Try from form data
+		tokenForm := c.PostForm("access_token")
+		if tokenForm != "" {
+			return tokenForm, false, nil
+		}
 	}
 
-	// Try from form data
-	tokenForm := c.PostForm("access_token")
-	if tokenForm != "" {
-		return tokenForm, nil
+	if CookieSessions {
+		if tokenCookie, err := c.Cookie(SessionCookie); err == nil && tokenCookie != "" {
+			return tokenCookie, true, nil
+		}
 	}
 
-	return "", http.ErrNoCookie
+	return "", false, http.ErrNoCookie
 }
 
 // Parse and validate JWT token
 func parseToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return []byte(common.NBSecretPassword), nil
-	})
+	// The key named by the kid header, only with its own algorithm
+	token, err := jwt.Parse(tokenString, common.Keys.Keyfunc)
 
 	if err != nil {
 		return nil, err
@@ -61,11 +66,12 @@ func parseToken(tokenString string) (*jwt.Token, error) {
 func UpdateContextUserModel(c *gin.Context, my_user_id uint) {
 	var myUserModel UserModel
 	if my_user_id != 0 {
-		db := common.GetDB()
-		db.First(&myUserModel, my_user_id)
+		db := common.GetDBContext(common.RequestContext(c))
+		common.LogDBError(c, db.First(&myUserModel, my_user_id).Error)
 	}
 	c.Set("my_user_id", my_user_id)
 	c.Set("my_user_model", myUserModel)
+	c.Set("my_token_scopes", []string(nil))
 }
 
 // You can custom middlewares yourself as the doc: https://github.com/gin-gonic/gin#custom-middleware
@@ -75,13 +81,25 @@ func AuthMiddleware(auto401 bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		UpdateContextUserModel(c, 0)
 
-		tokenString, err := extractTokenFromRequest(c)
+		tokenString, fromCookie, err := extractTokenFromRequest(c)
 		if err != nil {
 			if auto401 {
 				c.AbortWithError(http.StatusUnauthorized, err)
 			}
 			return
 		}
+		// Another site can make the browser send the cookie, not read the CSRF token
+		if fromCookie && !checkCSRF(c) {
+			if auto401 {
+				c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("csrf", ErrCSRFToken))
+			}
+			return
+		}
+
+		if strings.HasPrefix(tokenString, AccessTokenPrefix) {
+			authenticateAccessToken(c, tokenString, auto401)
+			return
+		}
 
 		token, err := parseToken(tokenString)
 		if err != nil {
@@ -94,6 +112,79 @@ func AuthMiddleware(auto401 bool) gin.HandlerFunc {
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			my_user_id := uint(claims["id"].(float64))
 			UpdateContextUserModel(c, my_user_id)
+			if issuedBeforePasswordChange(claims, c.MustGet("my_user_model").(UserModel)) {
+				UpdateContextUserModel(c, 0)
+				if auto401 {
+					c.AbortWithError(http.StatusUnauthorized, jwt.ErrTokenExpired)
+				}
+				return
+			}
+			if refuseBanned(c, auto401) {
+				return
+			}
+			c.Set("my_token", tokenString)
+		}
+	}
+}
+
+// Authenticate the request with a personal access token, limited to its scopes.
+// Like the JWTs, the tokens created before a password reset are refused.
+func authenticateAccessToken(c *gin.Context, tokenString string, auto401 bool) {
+	accessToken, err := FindAccessToken(c.Request.Context(), tokenString)
+	if err == nil {
+		UpdateContextUserModel(c, accessToken.UserID)
+		myUserModel := c.MustGet("my_user_model").(UserModel)
+		if myUserModel.PasswordChangedAt != nil && accessToken.CreatedAt.Before(*myUserModel.PasswordChangedAt) {
+			UpdateContextUserModel(c, 0)
+			err = ErrAccessTokenInvalid
+		}
+	} else if !errors.Is(err, ErrAccessTokenInvalid) {
+		common.LogDBError(c, err)
+	}
+	if err != nil {
+		if auto401 {
+			c.AbortWithError(http.StatusUnauthorized, err)
+		}
+		return
+	}
+	if refuseBanned(c, auto401) {
+		return
+	}
+	common.LogDBError(c, accessToken.touch(c.Request.Context()))
+	c.Set("my_token", tokenString)
+	c.Set("my_token_scopes", accessToken.ScopeList())
+}
+
+// A banned user is anonymous on the public routes and refused on the others.
+func refuseBanned(c *gin.Context, auto401 bool) bool {
+	if !c.MustGet("my_user_model").(UserModel).IsBanned() {
+		return false
+	}
+	UpdateContextUserModel(c, 0)
+	if auto401 {
+		c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("user", ErrBanned))
+	}
+	return true
+}
+
+// Refuse the users below the role, goes after AuthMiddleware(true).
+//
+//	admin.AdminRegister(v1.Group("/admin", users.RequireRole(users.RoleModerator)))
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.MustGet("my_user_model").(UserModel).HasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("role", ErrNotPermitted))
+		}
+	}
+}
+
+// Refuse the users whose role lacks the permission, goes after AuthMiddleware(true).
+//
+//	router.POST("/users/:username/ban", users.RequirePermission(users.PermissionBanUsers), UserBan)
+func RequirePermission(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.MustGet("my_user_model").(UserModel).Can(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("role", ErrNotPermitted))
 		}
 	}
 }
@@ -1,7 +1,9 @@
 package users
 
 import (
+	"context"
 	"errors"
+	"time"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
 	"golang.org/x/crypto/bcrypt"
@@ -19,6 +21,23 @@ type UserModel struct {
 	Bio          string  `gorm:"column:bio;size:1024"`
 	Image        *string `gorm:"column:image"`
 	PasswordHash string  `gorm:"column:password;not null"`
+	Role         string  `gorm:"column:role;size:32;not null;default:'user'"`
+	// Failed logins in a row and the lock they led to, see lockout.go
+	FailedLogins      int        `gorm:"column:failed_logins;not null;default:0"`
+	LastFailedLoginAt *time.Time `gorm:"column:last_failed_login_at"`
+	LockedUntil       *time.Time `gorm:"column:locked_until"`
+	// The last password reset, refusing the access tokens issued before it
+	PasswordChangedAt *time.Time `gorm:"column:password_changed_at"`
+	// Nil until the user opens the link of the verification email, again after a change of email
+	EmailVerifiedAt *time.Time `gorm:"column:email_verified_at"`
+	// The base32 TOTP secret, pending until TOTPEnabledAt is set, and the last time step
+	// accepted so a code can't be replayed, see twofactor.go
+	TOTPSecret    string     `gorm:"column:totp_secret;size:64"`
+	TOTPEnabledAt *time.Time `gorm:"column:totp_enabled_at"`
+	TOTPLastStep  int64      `gorm:"column:totp_last_step;not null;default:0"`
+	// Set by a moderator, the user can't log in nor use the tokens of before, see roles.go
+	BannedAt  *time.Time `gorm:"column:banned_at"`
+	BanReason string     `gorm:"column:ban_reason;size:255"`
 }
 
 // A hack way to save ManyToMany relationship,
@@ -39,17 +58,29 @@ type FollowModel struct {
 	FollowedByID uint
 }
 
+// The bcrypt cost used by setPassword, overwritten from the config at boot.
+var BcryptCost = bcrypt.DefaultCost
+
 // Migrate the schema of database if needed
 func AutoMigrate() {
 	db := common.GetDB()
 
 	db.AutoMigrate(&UserModel{})
 	db.AutoMigrate(&FollowModel{})
+	db.AutoMigrate(&LoginAttemptModel{})
+	db.AutoMigrate(&RefreshTokenModel{})
+	db.AutoMigrate(&PasswordResetModel{})
+	db.AutoMigrate(&EmailVerificationModel{})
+	db.AutoMigrate(&RecoveryCodeModel{})
+	db.AutoMigrate(&LoginChallengeModel{})
+	db.AutoMigrate(&IdentityModel{})
+	db.AutoMigrate(&OIDCLoginModel{})
+	db.AutoMigrate(&AccessTokenModel{})
 }
 
 // What's bcrypt? https://en.wikipedia.org/wiki/Bcrypt
 // Golang bcrypt doc: https://godoc.org/golang.org/x/crypto/bcrypt
-// You can change the value in bcrypt.DefaultCost to adjust the security index.
+// You can change the value in BcryptCost to adjust the security index.
 // 	err := userModel.setPassword("password0")
 func (u *UserModel) setPassword(password string) error {
 	if len(password) == 0 {
@@ -57,7 +88,7 @@ func (u *UserModel) setPassword(password string) error {
 	}
 	bytePassword := []byte(password)
 	// Make sure the second param `bcrypt generator cost` between [4, 32)
-	passwordHash, _ := bcrypt.GenerateFromPassword(bytePassword, bcrypt.DefaultCost)
+	passwordHash, _ := bcrypt.GenerateFromPassword(bytePassword, BcryptCost)
 	u.PasswordHash = string(passwordHash)
 	return nil
 }
@@ -71,34 +102,34 @@ func (u *UserModel) checkPassword(password string) error {
 }
 
 // You could input the conditions and it will return an UserModel in database with error info.
-// 	userModel, err := FindOneUser(&UserModel{Username: "username0"})
-func FindOneUser(condition interface{}) (UserModel, error) {
-	db := common.GetDB()
+// 	userModel, err := FindOneUser(ctx, &UserModel{Username: "username0"})
+func FindOneUser(ctx context.Context, condition interface{}) (UserModel, error) {
+	db := common.GetDBContext(ctx)
 	var model UserModel
 	err := db.Where(condition).First(&model).Error
 	return model, err
 }
 
 // You could input an UserModel which will be saved in database returning with error info
-// 	if err := SaveOne(&userModel); err != nil { ... }
-func SaveOne(data interface{}) error {
-	db := common.GetDB()
+// 	if err := SaveOne(ctx, &userModel); err != nil { ... }
+func SaveOne(ctx context.Context, data interface{}) error {
+	db := common.GetDBContext(ctx)
 	err := db.Save(data).Error
 	return err
 }
 
 // You could update properties of an UserModel to database returning with error info.
 //  err := db.Model(userModel).Update(UserModel{Username: "wangzitian0"}).Error
-func (model *UserModel) Update(data interface{}) error {
-	db := common.GetDB()
+func (model *UserModel) Update(ctx context.Context, data interface{}) error {
+	db := common.GetDBContext(ctx)
 	err := db.Model(model).Update(data).Error
 	return err
 }
 
 // You could add a following relationship as userModel1 following userModel2
-// 	err = userModel1.following(userModel2)
-func (u UserModel) following(v UserModel) error {
-	db := common.GetDB()
+// 	err = userModel1.following(ctx, userModel2)
+func (u UserModel) following(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
 	var follow FollowModel
 	err := db.FirstOrCreate(&follow, &FollowModel{
 		FollowingID:  v.ID,
@@ -108,9 +139,9 @@ func (u UserModel) following(v UserModel) error {
 }
 
 // You could check whether  userModel1 following userModel2
-// 	followingBool = myUserModel.isFollowing(self.UserModel)
-func (u UserModel) isFollowing(v UserModel) bool {
-	db := common.GetDB()
+// 	followingBool = myUserModel.isFollowing(ctx, self.UserModel)
+func (u UserModel) isFollowing(ctx context.Context, v UserModel) bool {
+	db := common.GetDBContext(ctx)
 	var follow FollowModel
 	db.Where(FollowModel{
 		FollowingID:  v.ID,
@@ -120,9 +151,9 @@ func (u UserModel) isFollowing(v UserModel) bool {
 }
 
 // You could delete a following relationship as userModel1 following userModel2
-// 	err = userModel1.unFollowing(userModel2)
-func (u UserModel) unFollowing(v UserModel) error {
-	db := common.GetDB()
+// 	err = userModel1.unFollowing(ctx, userModel2)
+func (u UserModel) unFollowing(ctx context.Context, v UserModel) error {
+	db := common.GetDBContext(ctx)
 	err := db.Where(FollowModel{
 		FollowingID:  v.ID,
 		FollowedByID: u.ID,
@@ -131,9 +162,9 @@ func (u UserModel) unFollowing(v UserModel) error {
 }
 
 // You could get a following list of userModel
-// 	followings := userModel.GetFollowings()
-func (u UserModel) GetFollowings() []UserModel {
-	db := common.GetDB()
+// 	followings := userModel.GetFollowings(ctx)
+func (u UserModel) GetFollowings(ctx context.Context) []UserModel {
+	db := common.GetDBContext(ctx)
 	tx := db.Begin()
 	var follows []FollowModel
 	var followings []UserModel
@@ -0,0 +1,179 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+	"realworld-backend/mailer"
+	"realworld-backend/metrics"
+)
+
+// A single use password reset token, only its SHA-256 is stored like the refresh tokens.
+type PasswordResetModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null"`
+	TokenHash string `gorm:"column:token_hash;size:64;not null;unique_index"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func (PasswordResetModel) TableName() string {
+	return "password_resets"
+}
+
+// How long a password reset link can be used, overwritten from the config at boot.
+var PasswordResetLifetime = time.Hour
+
+// Delivers the emails of the accounts and the frontend their links point to, set from the config at boot.
+// Without a mailer the messages are logged as not sent.
+var (
+	Mailer mailer.Mailer
+	AppURL = "http://localhost:4100"
+)
+
+var ErrPasswordResetInvalid = errors.New("password reset token is invalid, used or expired")
+
+// Issue a reset token for the user, the previous unused ones stop working.
+//
+//	token, err := IssuePasswordReset(ctx, userModel.ID)
+func IssuePasswordReset(ctx context.Context, userID uint) (string, error) {
+	db := common.GetDBContext(ctx)
+	now := time.Now()
+	err := db.Model(&PasswordResetModel{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		UpdateColumn("used_at", now).Error
+	if err != nil {
+		return "", err
+	}
+	token := randomToken()
+	model := PasswordResetModel{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(PasswordResetLifetime),
+	}
+	return token, db.Create(&model).Error
+}
+
+// Set the password of the token's user and consume the token. The account is unlocked,
+// every refresh token revoked and the access tokens issued before refused from now on.
+//
+//	userModel, err := ResetPassword(ctx, token, password)
+func ResetPassword(ctx context.Context, token, password string) (UserModel, error) {
+	var userModel UserModel
+	var model PasswordResetModel
+	db := common.GetDBContext(ctx)
+	err := db.Where(&PasswordResetModel{TokenHash: hashToken(token)}).First(&model).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return userModel, ErrPasswordResetInvalid
+	}
+	if err != nil {
+		return userModel, err
+	}
+	now := time.Now()
+	if model.UsedAt != nil || now.After(model.ExpiresAt) {
+		return userModel, ErrPasswordResetInvalid
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Conditional on used_at so two concurrent resets with the same token can't both win
+		result := tx.Model(&PasswordResetModel{}).
+			Where("id = ? AND used_at IS NULL", model.ID).
+			UpdateColumn("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPasswordResetInvalid
+		}
+		if err := tx.First(&userModel, model.UserID).Error; err != nil {
+			return err
+		}
+		if err := userModel.setPassword(password); err != nil {
+			return err
+		}
+		return tx.Model(&userModel).UpdateColumns(map[string]interface{}{
+			"password":             userModel.PasswordHash,
+			"password_changed_at":  now,
+			"failed_logins":        0,
+			"last_failed_login_at": nil,
+			"locked_until":         nil,
+		}).Error
+	})
+	if err != nil {
+		return userModel, err
+	}
+	userModel.PasswordChangedAt = &now
+	return userModel, RevokeUserRefreshTokens(ctx, userModel.ID)
+}
+
+// Whether the access token was issued before the last password reset of its user, which revoked it.
+// The tokens of GenToken carry iat. Those issued before it did are dated exp - TokenLifetime,
+// drop this fallback once they have all expired.
+func issuedBeforePasswordChange(claims jwt.MapClaims, userModel UserModel) bool {
+	if userModel.PasswordChangedAt == nil {
+		return false
+	}
+	var issuedAt int64
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = int64(iat)
+	} else if exp, ok := claims["exp"].(float64); ok {
+		issuedAt = int64(exp) - int64(common.TokenLifetime.Seconds())
+	}
+	return issuedAt < userModel.PasswordChangedAt.Unix()
+}
+
+// Mail the reset link after the response, so its latency can't tell a registered email from another.
+func sendPasswordReset(userModel UserModel, token string) {
+	link := fmt.Sprintf("%s/reset-password?token=%s", AppURL, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      userModel.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"To choose a new one, open this link within %s:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email: your password stays unchanged.\n",
+			userModel.Username, humanDuration(PasswordResetLifetime), link),
+	}
+	sendMail("password_reset", msg)
+}
+
+// A duration for a human reader: "1 hour", "30 minutes", else its Go form.
+func humanDuration(d time.Duration) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int64(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(int64(d/time.Minute), "minute")
+	}
+	return d.String()
+}
+
+// Send the message in the background, the server waits for it on shutdown.
+func sendMail(kind string, msg mailer.Message) {
+	if Mailer == nil {
+		common.Logger.Warn("mail not sent, no mailer configured", "kind", kind)
+		return
+	}
+	common.RunBackground(func(ctx context.Context) {
+		err := Mailer.Send(ctx, msg)
+		if err != nil {
+			metrics.MailsSent.WithLabelValues(kind, "error").Inc()
+			common.Logger.Error("mail not sent", "kind", kind, "error", err.Error())
+			return
+		}
+		metrics.MailsSent.WithLabelValues(kind, "ok").Inc()
+	})
+}
@@ -0,0 +1,169 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// The values of UserModel.Role, a new user is always a RoleUser.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// What a role allows besides writing one's own content, checked by RequirePermission.
+type Permission string
+
+const (
+	// List, search and look at the accounts
+	PermissionViewUsers Permission = "users:view"
+	// Ban and unban the users of a lower role
+	PermissionBanUsers Permission = "users:ban"
+	// Delete any article or comment
+	PermissionModerateContent Permission = "content:moderate"
+	// Change the role of the other users
+	PermissionManageRoles Permission = "roles:manage"
+	// The statistics of the site
+	PermissionViewStats Permission = "stats:view"
+)
+
+// The roles from the least to the most powerful, a role has the permissions of the ones before.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+var rolePermissions = map[string][]Permission{
+	RoleUser:      nil,
+	RoleModerator: {PermissionViewUsers, PermissionBanUsers, PermissionModerateContent},
+	RoleAdmin:     {PermissionViewUsers, PermissionBanUsers, PermissionModerateContent, PermissionManageRoles, PermissionViewStats},
+}
+
+var (
+	ErrBanned       = errors.New("this account is banned")
+	ErrUnknownRole  = errors.New("unknown role")
+	ErrOutranked    = errors.New("only users of a lower role can be changed")
+	ErrNotPermitted = errors.New("your role doesn't allow this")
+)
+
+// The position of the role in Roles, -1 for an unknown one.
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func (u UserModel) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Whether the user's role is role or a more powerful one.
+func (u UserModel) HasRole(role string) bool {
+	rank := roleRank(role)
+	return rank >= 0 && roleRank(u.Role) >= rank
+}
+
+func (u UserModel) Can(permission Permission) bool {
+	for _, p := range rolePermissions[u.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (u UserModel) IsBanned() bool {
+	return u.BannedAt != nil
+}
+
+// Whether u may ban or change the role of other: never oneself, only a lower role.
+func (u UserModel) Outranks(other UserModel) bool {
+	return u.ID != other.ID && roleRank(u.Role) > roleRank(other.Role)
+}
+
+// Ban the user and revoke the refresh tokens: the access tokens are refused by AuthMiddleware.
+func (u *UserModel) Ban(ctx context.Context, reason string) error {
+	now := time.Now()
+	err := common.GetDBContext(ctx).Model(u).UpdateColumns(map[string]interface{}{
+		"banned_at":  now,
+		"ban_reason": reason,
+	}).Error
+	if err != nil {
+		return err
+	}
+	u.BannedAt, u.BanReason = &now, reason
+	return RevokeUserRefreshTokens(ctx, u.ID)
+}
+
+func (u *UserModel) Unban(ctx context.Context) error {
+	err := common.GetDBContext(ctx).Model(u).UpdateColumns(map[string]interface{}{
+		"banned_at":  nil,
+		"ban_reason": "",
+	}).Error
+	if err == nil {
+		u.BannedAt, u.BanReason = nil, ""
+	}
+	return err
+}
+
+func (u *UserModel) SetRole(ctx context.Context, role string) error {
+	if roleRank(role) < 0 {
+		return ErrUnknownRole
+	}
+	err := common.GetDBContext(ctx).Model(u).UpdateColumn("role", role).Error
+	if err == nil {
+		u.Role = role
+	}
+	return err
+}
+
+// The filters of SearchUsers, the empty ones don't filter.
+type UserQuery struct {
+	// A part of the username or email
+	Search string
+	Role   string
+	Banned *bool
+	Limit  int
+	Offset int
+}
+
+// The users matching the query by id, and how many match in all.
+//
+//	userModels, count, err := SearchUsers(ctx, UserQuery{Search: "jake", Limit: 20})
+func SearchUsers(ctx context.Context, query UserQuery) ([]UserModel, int, error) {
+	var userModels []UserModel
+	var count int
+	db := common.GetDBContext(ctx).Model(&UserModel{})
+	if query.Search != "" {
+		pattern := "%" + escapeLike(query.Search) + "%"
+		db = db.Where(`username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+	if query.Role != "" {
+		db = db.Where("role = ?", query.Role)
+	}
+	if query.Banned != nil && *query.Banned {
+		db = db.Where("banned_at IS NOT NULL")
+	} else if query.Banned != nil {
+		db = db.Where("banned_at IS NULL")
+	}
+	if err := db.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	err := db.Order("id").Offset(query.Offset).Limit(query.Limit).Find(&userModels).Error
+	return userModels, count, err
+}
+
+func escapeLike(s string) string {
+	var escaped []rune
+	for _, r := range s {
+		if r == '%' || r == '_' || r == '\\' {
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, r)
+	}
+	return string(escaped)
+}
@@ -3,29 +3,50 @@ package users
 import (
 	"errors"
 	"realworld-backend/common"
+	"realworld-backend/metrics"
+	"realworld-backend/oidc"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func UsersRegister(router *gin.RouterGroup) {
 	router.POST("/", UsersRegistration)
 	router.POST("/login", UsersLogin)
+	router.POST("/login/2fa", UsersLoginTwoFactor)
+	router.POST("/oidc/:provider", OIDCLoginStart)
+	router.POST("/oidc/:provider/callback", OIDCLoginCallback)
+	router.POST("/refresh", UsersRefresh)
+	router.POST("/logout", UsersLogout)
+	router.POST("/password/forgot", PasswordForgot)
+	router.POST("/password/reset", PasswordReset)
+	router.POST("/verify", EmailVerify)
+	router.POST("/verify/resend", EmailVerifyResend)
 }
 
 func UserRegister(router *gin.RouterGroup) {
-	router.GET("/", UserRetrieve)
-	router.PUT("/", UserUpdate)
+	router.GET("/", RequireScope(ScopeProfileRead), UserRetrieve)
+	router.PUT("/", RequireScope(ScopeProfileWrite), UserUpdate)
+	router.GET("/2fa", SessionRequired(), TwoFactorStatus)
+	router.POST("/2fa", SessionRequired(), TwoFactorEnroll)
+	router.POST("/2fa/confirm", SessionRequired(), TwoFactorConfirm)
+	router.DELETE("/2fa", SessionRequired(), TwoFactorDisable)
+	router.GET("/identities", SessionRequired(), IdentityList)
+	router.GET("/tokens", SessionRequired(), AccessTokenList)
+	router.POST("/tokens", SessionRequired(), AccessTokenCreate)
+	router.DELETE("/tokens/:id", SessionRequired(), AccessTokenRevoke)
 }
 
 func ProfileRegister(router *gin.RouterGroup) {
-	router.GET("/:username", ProfileRetrieve)
-	router.POST("/:username/follow", ProfileFollow)
-	router.DELETE("/:username/follow", ProfileUnfollow)
+	router.GET("/:username", RequireScope(ScopeProfileRead), ProfileRetrieve)
+	router.POST("/:username/follow", RequireScope(ScopeProfileWrite), ProfileFollow)
+	router.DELETE("/:username/follow", RequireScope(ScopeProfileWrite), ProfileUnfollow)
 }
 
 func ProfileRetrieve(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
@@ -36,14 +57,15 @@ func ProfileRetrieve(c *gin.Context) {
 
 func ProfileFollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
-	err = myUserModel.following(userModel)
+	err = myUserModel.following(c.Request.Context(), userModel)
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -53,15 +75,16 @@ func ProfileFollow(c *gin.Context) {
 
 func ProfileUnfollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Username: username})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
 
-	err = myUserModel.unFollowing(userModel)
+	err = myUserModel.unFollowing(c.Request.Context(), userModel)
 	if err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -76,10 +99,21 @@ func UsersRegistration(c *gin.Context) {
 		return
 	}
 
-	if err := SaveOne(&userModelValidator.userModel); err != nil {
+	if err := SaveOne(c.Request.Context(), &userModelValidator.userModel); err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	metrics.UserRegistrations.Inc()
+	refreshToken, err := IssueRefreshToken(c.Request.Context(), userModelValidator.userModel.ID)
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	setRefreshTokenCookie(c, refreshToken)
+	startEmailVerification(c, userModelValidator.userModel)
+	startSession(c, userModelValidator.userModel.ID)
 	c.Set("my_user_model", userModelValidator.userModel)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusCreated, gin.H{"user": serializer.Response()})
@@ -91,22 +125,291 @@ func UsersLogin(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
-	userModel, err := FindOneUser(&UserModel{Email: loginValidator.userModel.Email})
+	ctx := c.Request.Context()
+	now := time.Now()
+	attempt := LoginAttemptModel{Email: loginValidator.userModel.Email, IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+
+	ipFailures, err := countIPFailures(ctx, attempt.IP, now)
+	common.LogDBError(c, err)
+	if ipFailures >= Lockout.IPMaxFailures {
+		attempt.Reason = AttemptIPBlocked
+		recordLoginAttempt(c, attempt)
+		c.Header("Retry-After", retryAfter(Lockout.IPWindow))
+		c.JSON(http.StatusTooManyRequests, common.NewError("login", errors.New("Too many failed logins, retry later")))
+		return
+	}
+
+	userModel, err := FindOneUser(ctx, &UserModel{Email: loginValidator.userModel.Email})
 
 	if err != nil {
+		common.LogDBError(c, err)
+		metrics.LoginsFailed.Inc()
+		attempt.Reason = AttemptUnknownEmail
+		recordLoginAttempt(c, attempt)
 		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
 		return
 	}
+	attempt.UserID = &userModel.ID
+
+	if refuseLockedLogin(c, userModel, attempt, now) {
+		return
+	}
 
 	if userModel.checkPassword(loginValidator.User.Password) != nil {
+		countLoginFailure(c, &userModel, attempt, AttemptBadPassword, now)
 		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
 		return
 	}
+	answerLogin(c, userModel, attempt)
+}
+
+// The second step of a login with 2FA: the challenge token of the first step and a code
+// of the authenticator app, or a recovery code. Wrong codes count toward the lockout.
+func UsersLoginTwoFactor(c *gin.Context) {
+	challengeValidator := NewLoginChallengeValidator()
+	if err := challengeValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	ctx := c.Request.Context()
+	now := time.Now()
+	challenge, err := findLoginChallenge(ctx, challengeValidator.User.ChallengeToken)
+	if err != nil {
+		if !errors.Is(err, ErrChallengeInvalid) {
+			common.LogDBError(c, err)
+		}
+		c.JSON(http.StatusUnauthorized, common.NewError("challengeToken", ErrChallengeInvalid))
+		return
+	}
+	userModel, err := FindOneUser(ctx, &UserModel{ID: challenge.UserID})
+	if err != nil || !userModel.TwoFactorEnabled() {
+		c.JSON(http.StatusUnauthorized, common.NewError("challengeToken", ErrChallengeInvalid))
+		return
+	}
+	attempt := LoginAttemptModel{Email: userModel.Email, UserID: &userModel.ID, IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	if refuseLockedLogin(c, userModel, attempt, now) {
+		return
+	}
+	if refuseLoginChallenge(c, challenge.countAttempt(ctx)) {
+		return
+	}
+
+	if err := userModel.checkSecondFactor(ctx, challengeValidator.User.Code); err != nil {
+		if !errors.Is(err, ErrTwoFactorCode) {
+			common.LogDBError(c, err)
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+			return
+		}
+		countLoginFailure(c, &userModel, attempt, AttemptBadSecondFactor, now)
+		c.JSON(http.StatusForbidden, common.NewError("code", ErrTwoFactorCode))
+		return
+	}
+	if refuseLoginChallenge(c, challenge.finish(ctx)) {
+		return
+	}
+	completeLogin(c, userModel, attempt)
+}
+
+// Answer 401 to a challenge spent by a concurrent request, 422 to a database error, and tell
+// whether it did.
+func refuseLoginChallenge(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrChallengeInvalid):
+		c.JSON(http.StatusUnauthorized, common.NewError("challengeToken", ErrChallengeInvalid))
+	default:
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	}
+	return true
+}
+
+// Answer the first step of a login: the challenge of the second step for a user with 2FA, else the user.
+func answerLogin(c *gin.Context, userModel UserModel, attempt LoginAttemptModel) {
+	if refuseBannedLogin(c, userModel, attempt) {
+		return
+	}
+	if !userModel.TwoFactorEnabled() {
+		completeLogin(c, userModel, attempt)
+		return
+	}
+	// The failures are only forgotten after the code, or the password would reset the lockout between guesses
+	challengeToken, err := IssueLoginChallenge(c.Request.Context(), userModel.ID)
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"twoFactor": TwoFactorChallengeResponse{
+		ChallengeToken: challengeToken,
+		ExpiresIn:      int(LoginChallengeLifetime.Seconds()),
+	}})
+}
+
+// Answer a successful login: forget the failures, record the attempt, start the session.
+func completeLogin(c *gin.Context, userModel UserModel, attempt LoginAttemptModel) {
+	if refuseBannedLogin(c, userModel, attempt) {
+		return
+	}
+	ctx := c.Request.Context()
+	common.LogDBError(c, userModel.Unlock(ctx))
+	attempt.Success = true
+	recordLoginAttempt(c, attempt)
+	refreshToken, err := IssueRefreshToken(ctx, userModel.ID)
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	setRefreshTokenCookie(c, refreshToken)
 	UpdateContextUserModel(c, userModel.ID)
+	startSession(c, userModel.ID)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
 }
 
+// Exchange a refresh token for a new access token and the next refresh token of its family.
+// The refresh token comes from the cookie set at login, or from the body for non browser clients,
+// the next one is returned the same way.
+func UsersRefresh(c *gin.Context) {
+	refreshValidator := NewRefreshTokenValidator()
+	if err := refreshValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userID, refreshToken, err := RotateRefreshToken(c.Request.Context(), refreshValidator.RefreshToken)
+	if errors.Is(err, ErrRefreshTokenInvalid) || errors.Is(err, ErrRefreshTokenReused) {
+		clearRefreshTokenCookie(c)
+		endSession(c)
+	}
+	if errors.Is(err, ErrRefreshTokenReused) {
+		metrics.RefreshTokenReuses.Inc()
+		common.Log(c).Warn("refresh token reused, family revoked", "user_id", userID)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrRefreshTokenInvalid) && !errors.Is(err, ErrRefreshTokenReused) {
+			common.LogDBError(c, err)
+		}
+		c.JSON(http.StatusUnauthorized, common.NewError("refreshToken", ErrRefreshTokenInvalid))
+		return
+	}
+	UpdateContextUserModel(c, userID)
+	if c.GetUint("my_user_id") == 0 || c.MustGet("my_user_model").(UserModel).ID == 0 {
+		c.JSON(http.StatusUnauthorized, common.NewError("refreshToken", ErrRefreshTokenInvalid))
+		return
+	}
+	setRefreshTokenCookie(c, refreshToken)
+	startSession(c, userID)
+	serializer := UserSerializer{c}
+	response := gin.H{"user": serializer.Response()}
+	if refreshValidator.fromBody {
+		response["refreshToken"] = refreshToken
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke the refresh token family of this session, or every session with "all": true.
+// The access tokens already issued stay valid until they expire, see auth.token_lifetime.
+func UsersLogout(c *gin.Context) {
+	refreshValidator := NewRefreshTokenValidator()
+	if err := refreshValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	err := RevokeRefreshToken(c.Request.Context(), refreshValidator.RefreshToken, refreshValidator.All)
+	if err != nil && !errors.Is(err, ErrRefreshTokenInvalid) {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	// An unknown token is already as logged out as it gets
+	clearRefreshTokenCookie(c)
+	endSession(c)
+	c.Status(http.StatusNoContent)
+}
+
+// Mail a password reset link when the email belongs to an account.
+// The answer is the same either way, it must not tell which emails are registered.
+func PasswordForgot(c *gin.Context) {
+	forgotValidator := NewPasswordForgotValidator()
+	if err := forgotValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Email: forgotValidator.User.Email})
+	if err == nil {
+		token, err := IssuePasswordReset(c.Request.Context(), userModel.ID)
+		if err != nil {
+			common.LogDBError(c, err)
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+			return
+		}
+		sendPasswordReset(userModel, token)
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// Choose a new password with the token of the reset email, which logs out every session.
+func PasswordReset(c *gin.Context) {
+	resetValidator := NewPasswordResetValidator()
+	if err := resetValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModel, err := ResetPassword(c.Request.Context(), resetValidator.User.Token, resetValidator.User.Password)
+	if errors.Is(err, ErrPasswordResetInvalid) {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("token", err))
+		return
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	metrics.PasswordResets.Inc()
+	common.Log(c).Info("password reset", "user_id", userModel.ID)
+	clearRefreshTokenCookie(c)
+	endSession(c)
+	c.Status(http.StatusNoContent)
+}
+
+// Verify the email of an account with the token of the verification email.
+func EmailVerify(c *gin.Context) {
+	verifyValidator := NewEmailVerifyValidator()
+	if err := verifyValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModel, err := VerifyEmail(c.Request.Context(), verifyValidator.User.Token)
+	if errors.Is(err, ErrEmailVerificationInvalid) {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("token", err))
+		return
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	common.Log(c).Info("email verified", "user_id", userModel.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// Mail a new verification link when the email belongs to an account not verified yet.
+// Like PasswordForgot the answer doesn't tell which emails are registered.
+func EmailVerifyResend(c *gin.Context) {
+	resendValidator := NewEmailVerifyResendValidator()
+	if err := resendValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModel, err := FindOneUser(c.Request.Context(), &UserModel{Email: resendValidator.User.Email})
+	if err == nil && userModel.EmailVerifiedAt == nil {
+		startEmailVerification(c, userModel)
+	}
+	c.Status(http.StatusAccepted)
+}
+
 func UserRetrieve(c *gin.Context) {
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
@@ -121,11 +424,259 @@ func UserUpdate(c *gin.Context) {
 	}
 
 	userModelValidator.userModel.ID = myUserModel.ID
-	if err := myUserModel.Update(userModelValidator.userModel); err != nil {
+	emailChanged := userModelValidator.userModel.Email != myUserModel.Email
+	// The password and email lead to the account, out of reach of the access tokens
+	passwordChanged := userModelValidator.userModel.PasswordHash != ""
+	if (emailChanged || passwordChanged) && requestScopes(c) != nil {
+		c.JSON(http.StatusForbidden, common.NewError("token", ErrSessionRequired))
+		return
+	}
+	if err := myUserModel.Update(c.Request.Context(), userModelValidator.userModel); err != nil {
+		common.LogDBError(c, err)
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	// The new address has to be verified in turn
+	if emailChanged {
+		myUserModel.Email = userModelValidator.userModel.Email
+		common.LogDBError(c, myUserModel.unverifyEmail(c.Request.Context()))
+		startEmailVerification(c, myUserModel)
+	}
 	UpdateContextUserModel(c, myUserModel.ID)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
 }
+
+func TwoFactorStatus(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	serializer := TwoFactorSerializer{c, myUserModel}
+	c.JSON(http.StatusOK, gin.H{"twoFactor": serializer.Response()})
+}
+
+// Generate a TOTP secret for the authenticator app, enabled once TwoFactorConfirm gets one of its codes.
+func TwoFactorEnroll(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	secret, uri, err := myUserModel.StartTOTPEnrollment(c.Request.Context())
+	if errors.Is(err, ErrTwoFactorEnabled) {
+		c.JSON(http.StatusConflict, common.NewError("twoFactor", err))
+		return
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := TwoFactorSerializer{c, myUserModel}
+	response := serializer.Response()
+	response.Secret, response.URI = secret, uri
+	c.JSON(http.StatusOK, gin.H{"twoFactor": response})
+}
+
+// Enable 2FA with a first code, answering the recovery codes: they're never shown again.
+func TwoFactorConfirm(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	codeValidator := NewTwoFactorCodeValidator()
+	if err := codeValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	recoveryCodes, err := myUserModel.ConfirmTOTP(c.Request.Context(), codeValidator.TwoFactor.Code)
+	switch {
+	case errors.Is(err, ErrTwoFactorEnabled):
+		c.JSON(http.StatusConflict, common.NewError("twoFactor", err))
+		return
+	case errors.Is(err, ErrTwoFactorNotStarted), errors.Is(err, ErrTwoFactorCode):
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("code", err))
+		return
+	case err != nil:
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	common.Log(c).Info("two-factor authentication enabled", "user_id", myUserModel.ID)
+	serializer := TwoFactorSerializer{c, myUserModel}
+	response := serializer.Response()
+	response.RecoveryCodes = recoveryCodes
+	c.JSON(http.StatusOK, gin.H{"twoFactor": response})
+}
+
+// Turn 2FA off with the password and a current code or a recovery code. Wrong ones count
+// toward the lockout of the login, or a stolen access token could guess the codes.
+func TwoFactorDisable(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	disableValidator := NewTwoFactorDisableValidator()
+	if err := disableValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	ctx := c.Request.Context()
+	now := time.Now()
+	attempt := LoginAttemptModel{Email: myUserModel.Email, UserID: &myUserModel.ID, IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	if refuseLockedLogin(c, myUserModel, attempt, now) {
+		return
+	}
+	if myUserModel.checkPassword(disableValidator.TwoFactor.Password) != nil {
+		countLoginFailure(c, &myUserModel, attempt, AttemptBadPassword, now)
+		c.JSON(http.StatusForbidden, common.NewError("password", errors.New("Invalid password")))
+		return
+	}
+	err := myUserModel.DisableTOTP(ctx, disableValidator.TwoFactor.Code)
+	switch {
+	case errors.Is(err, ErrTwoFactorNotEnabled):
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("code", err))
+		return
+	case errors.Is(err, ErrTwoFactorCode):
+		countLoginFailure(c, &myUserModel, attempt, AttemptBadSecondFactor, now)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("code", err))
+		return
+	case err != nil:
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	common.LogDBError(c, myUserModel.Unlock(ctx))
+	common.Log(c).Info("two-factor authentication disabled", "user_id", myUserModel.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// Start a login with an OpenID provider: the frontend sends the user to the authorization URL
+// and gets back on its redirect_url with the code and state for OIDCLoginCallback. The state is
+// also set in a cookie, which the callback must carry.
+func OIDCLoginStart(c *gin.Context) {
+	provider, ok := OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, common.NewError("provider", ErrOIDCProvider))
+		return
+	}
+	authURL, state, err := StartOIDCLogin(c.Request.Context(), provider)
+	if errors.Is(err, ErrOIDCUnavailable) {
+		common.Log(c).Error("oidc login not started", "provider", provider.Name, "error", err.Error())
+		c.JSON(http.StatusBadGateway, common.NewError("oidc", ErrOIDCUnavailable))
+		return
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	setOIDCStateCookie(c, state)
+	c.JSON(http.StatusOK, gin.H{"oidc": OIDCLoginResponse{AuthorizationURL: authURL, State: state}})
+}
+
+// Finish the login with the code and state of the provider, answering like UsersLogin.
+// The state must be the one in the cookie of OIDCLoginStart.
+func OIDCLoginCallback(c *gin.Context) {
+	provider, ok := OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, common.NewError("provider", ErrOIDCProvider))
+		return
+	}
+	callbackValidator := NewOIDCCallbackValidator()
+	if err := callbackValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if !oidcStateCookieMatches(c, callbackValidator.OIDC.State) {
+		metrics.OIDCLogins.WithLabelValues(provider.Name, "refused").Inc()
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("state", ErrOIDCState))
+		return
+	}
+	clearOIDCStateCookie(c)
+	userModel, outcome, err := FinishOIDCLogin(c.Request.Context(), provider, callbackValidator.OIDC.Code, callbackValidator.OIDC.State)
+	if err != nil {
+		metrics.OIDCLogins.WithLabelValues(provider.Name, "refused").Inc()
+		switch {
+		case errors.Is(err, ErrOIDCState):
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("state", err))
+		case errors.Is(err, oidc.ErrExchange), errors.Is(err, oidc.ErrIDToken):
+			common.Log(c).Warn("oidc login refused", "provider", provider.Name, "error", err.Error())
+			c.JSON(http.StatusUnauthorized, common.NewError("oidc", errors.New("the identity provider didn't confirm the login")))
+		case errors.Is(err, ErrOIDCEmailUnverified), errors.Is(err, ErrOIDCSignupDisabled):
+			c.JSON(http.StatusForbidden, common.NewError("email", err))
+		case errors.Is(err, ErrOIDCUnavailable):
+			common.Log(c).Error("oidc login failed", "provider", provider.Name, "error", err.Error())
+			c.JSON(http.StatusBadGateway, common.NewError("oidc", ErrOIDCUnavailable))
+		default:
+			common.LogDBError(c, err)
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		}
+		return
+	}
+	metrics.OIDCLogins.WithLabelValues(provider.Name, outcome).Inc()
+	if outcome == IdentityCreated {
+		metrics.UserRegistrations.Inc()
+	}
+	if outcome != IdentityExisting {
+		common.Log(c).Info("oidc identity linked", "provider", provider.Name, "user_id", userModel.ID, "outcome", outcome)
+	}
+	attempt := LoginAttemptModel{Email: userModel.Email, UserID: &userModel.ID, IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	answerLogin(c, userModel, attempt)
+}
+
+func IdentityList(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	identities, err := myUserModel.Identities(c.Request.Context())
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := IdentitiesSerializer{c, identities}
+	c.JSON(http.StatusOK, gin.H{"identities": serializer.Response()})
+}
+
+func AccessTokenList(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	accessTokens, err := myUserModel.AccessTokens(c.Request.Context())
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := AccessTokensSerializer{c, accessTokens}
+	c.JSON(http.StatusOK, gin.H{"tokens": serializer.Response()})
+}
+
+// Create a personal access token, answered in the clear this once.
+func AccessTokenCreate(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	accessTokenValidator := NewAccessTokenValidator()
+	if err := accessTokenValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	token, accessToken, err := IssueAccessToken(c.Request.Context(), myUserModel.ID,
+		accessTokenValidator.Token.Name, accessTokenValidator.Token.Scopes, accessTokenValidator.Token.ExpiresAt)
+	if errors.Is(err, ErrAccessTokenLimit) {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("token", err))
+		return
+	}
+	if err != nil {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	common.Log(c).Info("access token created", "user_id", myUserModel.ID, "token_id", accessToken.ID, "scopes", accessToken.Scopes)
+	serializer := AccessTokenSerializer{c, accessToken}
+	response := serializer.Response()
+	response.Token = token
+	c.JSON(http.StatusCreated, gin.H{"token": response})
+}
+
+func AccessTokenRevoke(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err == nil {
+		err = myUserModel.RevokeAccessToken(c.Request.Context(), uint(id))
+	}
+	if err != nil && !errors.Is(err, ErrAccessTokenInvalid) {
+		common.LogDBError(c, err)
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("token", errors.New("Invalid id")))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -1,9 +1,12 @@
 package users
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 
 	"realworld-backend/common"
+	"realworld-backend/tracing"
 )
 
 type ProfileSerializer struct {
@@ -22,13 +25,15 @@ type ProfileResponse struct {
 
 // Put your response logic including wrap the userModel here.
 func (self *ProfileSerializer) Response() ProfileResponse {
+	ctx, end := tracing.StartGin(self.C, "ProfileSerializer.Response")
+	defer end()
 	myUserModel := self.C.MustGet("my_user_model").(UserModel)
 	profile := ProfileResponse{
 		ID:        self.ID,
 		Username:  self.Username,
 		Bio:       self.Bio,
 		Image:     self.Image,
-		Following: myUserModel.isFollowing(self.UserModel),
+		Following: myUserModel.isFollowing(ctx, self.UserModel),
 	}
 	return profile
 }
@@ -45,14 +50,121 @@ type UserResponse struct {
 	Token    string  `json:"token"`
 }
 
+// The token is the one the request was authenticated with, a new one is only minted
+// at login, registration and refresh.
 func (self *UserSerializer) Response() UserResponse {
 	myUserModel := self.c.MustGet("my_user_model").(UserModel)
+	token := self.c.GetString("my_token")
+	if token == "" {
+		token = common.GenToken(myUserModel.ID)
+	}
 	user := UserResponse{
 		Username: myUserModel.Username,
 		Email:    myUserModel.Email,
 		Bio:      myUserModel.Bio,
 		Image:    myUserModel.Image,
-		Token:    common.GenToken(myUserModel.ID),
+		Token:    token,
 	}
 	return user
 }
+
+type TwoFactorSerializer struct {
+	C *gin.Context
+	UserModel
+}
+
+// The secret and its URI are only answered by the enrollment, the recovery codes by the confirmation.
+type TwoFactorResponse struct {
+	Enabled           bool     `json:"enabled"`
+	Secret            string   `json:"secret,omitempty"`
+	URI               string   `json:"uri,omitempty"`
+	RecoveryCodes     []string `json:"recoveryCodes,omitempty"`
+	RecoveryCodesLeft int      `json:"recoveryCodesLeft"`
+}
+
+func (self *TwoFactorSerializer) Response() TwoFactorResponse {
+	left, err := self.RecoveryCodesLeft(self.C.Request.Context())
+	common.LogDBError(self.C, err)
+	return TwoFactorResponse{
+		Enabled:           self.TwoFactorEnabled(),
+		RecoveryCodesLeft: left,
+	}
+}
+
+// Answered by the login of a user with 2FA instead of the user, see UsersLoginTwoFactor.
+type TwoFactorChallengeResponse struct {
+	ChallengeToken string `json:"challengeToken"`
+	ExpiresIn      int    `json:"expiresIn"`
+}
+
+// Answered by OIDCLoginStart, the frontend keeps the state to check the one of the callback.
+type OIDCLoginResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+	State            string `json:"state"`
+}
+
+type IdentitiesSerializer struct {
+	C          *gin.Context
+	Identities []IdentityModel
+}
+
+type IdentityResponse struct {
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+func (self *IdentitiesSerializer) Response() []IdentityResponse {
+	response := []IdentityResponse{}
+	for _, identity := range self.Identities {
+		response = append(response, IdentityResponse{
+			Provider:    identity.Provider,
+			Email:       identity.Email,
+			CreatedAt:   identity.CreatedAt,
+			LastLoginAt: identity.LastLoginAt,
+		})
+	}
+	return response
+}
+
+type AccessTokenSerializer struct {
+	C *gin.Context
+	AccessTokenModel
+}
+
+// Token is only set in the answer of the creation.
+type AccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Token      string     `json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+func (self *AccessTokenSerializer) Response() AccessTokenResponse {
+	return AccessTokenResponse{
+		ID:         self.ID,
+		Name:       self.Name,
+		Scopes:     self.ScopeList(),
+		CreatedAt:  self.CreatedAt,
+		ExpiresAt:  self.ExpiresAt,
+		LastUsedAt: self.LastUsedAt,
+	}
+}
+
+type AccessTokensSerializer struct {
+	C            *gin.Context
+	AccessTokens []AccessTokenModel
+}
+
+func (self *AccessTokensSerializer) Response() []AccessTokenResponse {
+	response := []AccessTokenResponse{}
+	for _, accessToken := range self.AccessTokens {
+		serializer := AccessTokenSerializer{self.C, accessToken}
+		response = append(response, serializer.Response())
+	}
+	return response
+}
@@ -0,0 +1,69 @@
+package users
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+)
+
+// Also deliver the access token in an HttpOnly session cookie, for the web frontend.
+// Overwritten from the config at boot.
+var CookieSessions = false
+
+// Accept the access token from the access_token query parameter and form field.
+// They end up in access logs and browser history, overwritten from the config at boot.
+var QueryTokens = true
+
+// The session cookie and its double-submit CSRF token: the frontend reads the csrf_token
+// cookie and echoes it in the X-CSRF-Token header of every unsafe request.
+const (
+	SessionCookie = "session"
+	CSRFCookie    = "csrf_token"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+var ErrCSRFToken = errors.New("missing or invalid CSRF token")
+
+// Mint the access token answered to a login, a registration or a refresh.
+// With CookieSessions it's set in the session cookie too, along a CSRF token when there's none yet.
+func startSession(c *gin.Context, userID uint) {
+	token := common.GenToken(userID)
+	c.Set("my_token", token)
+	if !CookieSessions {
+		return
+	}
+	// Lax rather than Strict so following a link to the frontend keeps the session,
+	// the unsafe methods are covered by the CSRF token
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, token, int(common.TokenLifetime.Seconds()), "/", "", SecureCookies, true)
+	// Kept across refreshes so a request in flight never carries a stale one
+	if csrf, err := c.Cookie(CSRFCookie); err != nil || csrf == "" {
+		c.SetCookie(CSRFCookie, randomToken(), int(RefreshTokenLifetime.Seconds()), "/", "", SecureCookies, false)
+	}
+}
+
+// Drop the session and CSRF cookies, at logout or when the refresh token is refused.
+func endSession(c *gin.Context) {
+	if !CookieSessions {
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, "", -1, "/", "", SecureCookies, true)
+	c.SetCookie(CSRFCookie, "", -1, "/", "", SecureCookies, false)
+}
+
+// Whether a request authenticated by the session cookie proves it comes from our frontend:
+// safe methods never change anything, the others must echo the CSRF cookie in the header.
+func checkCSRF(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := c.Cookie(CSRFCookie)
+	header := c.GetHeader(CSRFHeader)
+	return err == nil && cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
@@ -0,0 +1,165 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// An opaque refresh token, only its SHA-256 is stored so a database leak can't be replayed.
+//
+// Every refresh rotates the token: the presented one is marked used and a new one of the same
+// family replaces it. A used token presented again means it was stolen, the whole family is revoked.
+type RefreshTokenModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null"`
+	FamilyID  string `gorm:"column:family_id;size:64;not null"`
+	TokenHash string `gorm:"column:token_hash;size:64;not null;unique_index"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func (RefreshTokenModel) TableName() string {
+	return "refresh_tokens"
+}
+
+// How long a refresh token can be exchanged, overwritten from the config at boot.
+var RefreshTokenLifetime = 30 * 24 * time.Hour
+
+// Mark the refresh token cookie Secure, on in production where the API is served over TLS.
+var SecureCookies = false
+
+// The HttpOnly cookie carrying the refresh token, only sent to /api/users/refresh and /logout.
+const (
+	RefreshTokenCookie     = "refresh_token"
+	refreshTokenCookiePath = "/api/users"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, its family is revoked")
+)
+
+// 32 random bytes, URL safe. Without the randomness of the OS no token can be issued safely,
+// it panics rather than hand out a guessable one.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Start a new family of refresh tokens for the user, at login or registration.
+//
+//	refreshToken, err := IssueRefreshToken(ctx, userModel.ID)
+func IssueRefreshToken(ctx context.Context, userID uint) (string, error) {
+	return issueRefreshToken(ctx, userID, randomToken())
+}
+
+func issueRefreshToken(ctx context.Context, userID uint, familyID string) (string, error) {
+	token := randomToken()
+	model := RefreshTokenModel{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(RefreshTokenLifetime),
+	}
+	err := common.GetDBContext(ctx).Create(&model).Error
+	return token, err
+}
+
+// Exchange a refresh token for a new one of the same family, returning the id of its user.
+// A token already exchanged revokes its family and returns ErrRefreshTokenReused.
+//
+//	userID, refreshToken, err := RotateRefreshToken(ctx, presented)
+func RotateRefreshToken(ctx context.Context, token string) (uint, string, error) {
+	db := common.GetDBContext(ctx)
+	model, err := findRefreshToken(ctx, token)
+	if err != nil {
+		return 0, "", err
+	}
+	now := time.Now()
+	if model.RevokedAt != nil || now.After(model.ExpiresAt) {
+		return 0, "", ErrRefreshTokenInvalid
+	}
+	// Conditional on used_at so two concurrent refreshes with the same token can't both win
+	result := db.Model(&RefreshTokenModel{}).
+		Where("id = ? AND used_at IS NULL", model.ID).
+		UpdateColumn("used_at", now)
+	if result.Error != nil {
+		return 0, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := revokeFamily(ctx, model.FamilyID); err != nil {
+			return 0, "", err
+		}
+		return model.UserID, "", ErrRefreshTokenReused
+	}
+	next, err := issueRefreshToken(ctx, model.UserID, model.FamilyID)
+	return model.UserID, next, err
+}
+
+// Revoke the family of the token, the logout of one session, or with `all` every token of its user.
+// An unknown token returns ErrRefreshTokenInvalid.
+//
+//	err := RevokeRefreshToken(ctx, presented, false)
+func RevokeRefreshToken(ctx context.Context, token string, all bool) error {
+	model, err := findRefreshToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if all {
+		return RevokeUserRefreshTokens(ctx, model.UserID)
+	}
+	return revokeFamily(ctx, model.FamilyID)
+}
+
+func findRefreshToken(ctx context.Context, token string) (RefreshTokenModel, error) {
+	var model RefreshTokenModel
+	err := common.GetDBContext(ctx).Where(&RefreshTokenModel{TokenHash: hashToken(token)}).First(&model).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return model, ErrRefreshTokenInvalid
+	}
+	return model, err
+}
+
+// Revoke every refresh token of the user, the logout of all sessions.
+func RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	return common.GetDBContext(ctx).Model(&RefreshTokenModel{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func revokeFamily(ctx context.Context, familyID string) error {
+	return common.GetDBContext(ctx).Model(&RefreshTokenModel{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func setRefreshTokenCookie(c *gin.Context, token string) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(RefreshTokenCookie, token, int(RefreshTokenLifetime.Seconds()), refreshTokenCookiePath, "", SecureCookies, true)
+}
+
+func clearRefreshTokenCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(RefreshTokenCookie, "", -1, refreshTokenCookiePath, "", SecureCookies, true)
+}
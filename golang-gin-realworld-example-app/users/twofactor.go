@@ -0,0 +1,335 @@
+package users
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// One of the single use codes logging in without the authenticator app, hashed like the tokens.
+type RecoveryCodeModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null;index"`
+	CodeHash  string `gorm:"column:code_hash;size:64;not null"`
+	UsedAt    *time.Time
+}
+
+func (RecoveryCodeModel) TableName() string {
+	return "recovery_codes"
+}
+
+// The second step of a login with 2FA: the password was right, a code is still expected.
+// Dead after LoginChallengeAttempts codes, each counted before it's checked.
+type LoginChallengeModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null"`
+	TokenHash string `gorm:"column:token_hash;size:64;not null;unique_index"`
+	ExpiresAt time.Time
+	Attempts  int `gorm:"column:attempts;not null;default:0"`
+	UsedAt    *time.Time
+}
+
+func (LoginChallengeModel) TableName() string {
+	return "login_challenges"
+}
+
+// The issuer shown by the authenticator apps and how long the second step of a login may take,
+// overwritten from the config at boot.
+var (
+	TOTPIssuer             = "RealWorld"
+	LoginChallengeLifetime = 5 * time.Minute
+)
+
+// RFC 6238 parameters understood by every authenticator app: SHA-1, 6 digits, 30 seconds.
+const (
+	totpPeriod             = 30
+	totpDigits             = 6
+	totpSkew               = 1
+	recoveryCodeCount      = 10
+	LoginChallengeAttempts = 5
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotStarted = errors.New("start the enrollment first")
+	ErrTwoFactorCode       = errors.New("invalid two-factor code")
+	ErrChallengeInvalid    = errors.New("login challenge is invalid or expired, log in again")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// The code of the RFC 6238 time step, an RFC 4226 HOTP of the step counter.
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// The time step matching the code around `now`, later than lastStep so a code can't be replayed.
+func matchTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// The otpauth:// URI of the secret, shown as a QR code for the authenticator apps.
+func totpURI(account, secret string) string {
+	label := url.PathEscape(TOTPIssuer) + ":" + url.PathEscape(account)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {TOTPIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// "abcd-efgh-ijkl", typed by hand so lower case and without the ambiguous padding.
+// Panics without the randomness of the OS, as randomToken.
+func newRecoveryCode() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	code := strings.ToLower(base32NoPadding.EncodeToString(b))[:12]
+	return code[:4] + "-" + code[4:8] + "-" + code[8:]
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func (u UserModel) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// Generate a new secret, pending until ConfirmTOTP proves the app reads it.
+// Returns the secret and its otpauth:// URI.
+//
+//	secret, uri, err := userModel.StartTOTPEnrollment(ctx)
+func (u *UserModel) StartTOTPEnrollment(ctx context.Context) (string, string, error) {
+	if u.TwoFactorEnabled() {
+		return "", "", ErrTwoFactorEnabled
+	}
+	key := make([]byte, 20)
+	// Panics as randomToken, a zero secret would accept codes anyone can compute
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	secret := base32NoPadding.EncodeToString(key)
+	err := common.GetDBContext(ctx).Model(u).UpdateColumns(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+	u.TOTPSecret, u.TOTPLastStep = secret, 0
+	return secret, totpURI(u.Email, secret), nil
+}
+
+// Enable 2FA with a code of the pending secret, returning the recovery codes, shown this once.
+//
+//	recoveryCodes, err := userModel.ConfirmTOTP(ctx, code)
+func (u *UserModel) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	if u.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotStarted
+	}
+	step, ok := matchTOTP(u.TOTPSecret, code, u.TOTPLastStep, time.Now())
+	if !ok {
+		return nil, ErrTwoFactorCode
+	}
+	now := time.Now()
+	var codes []string
+	err := common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(u).UpdateColumns(map[string]interface{}{
+			"totp_enabled_at": now,
+			"totp_last_step":  step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, u.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.TOTPEnabledAt, u.TOTPLastStep = &now, step
+	return codes, nil
+}
+
+// Turn 2FA off, proven with a current code or a recovery code.
+func (u *UserModel) DisableTOTP(ctx context.Context, code string) error {
+	if !u.TwoFactorEnabled() {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := u.checkSecondFactor(ctx, code); err != nil {
+		return err
+	}
+	return u.ResetTwoFactor(ctx)
+}
+
+// Remove the secret and recovery codes of the user, without any code: for operators only.
+func (u *UserModel) ResetTwoFactor(ctx context.Context) error {
+	err := common.GetDBContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(u).UpdateColumns(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", u.ID).Delete(&RecoveryCodeModel{}).Error
+	})
+	if err == nil {
+		u.TOTPSecret, u.TOTPEnabledAt, u.TOTPLastStep = "", nil, 0
+	}
+	return err
+}
+
+// The recovery codes the user can still use.
+func (u UserModel) RecoveryCodesLeft(ctx context.Context) (int, error) {
+	var count int
+	err := common.GetDBContext(ctx).Model(&RecoveryCodeModel{}).
+		Where("user_id = ? AND used_at IS NULL", u.ID).
+		Count(&count).Error
+	return count, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCodeModel{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		model := RecoveryCodeModel{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(codes[i]))}
+		if err := tx.Create(&model).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// Accept a code of the authenticator app, or else consume a recovery code.
+// Both are conditional updates so a code can't be used twice, even concurrently.
+func (u *UserModel) checkSecondFactor(ctx context.Context, code string) error {
+	db := common.GetDBContext(ctx)
+	if step, ok := matchTOTP(u.TOTPSecret, strings.TrimSpace(code), u.TOTPLastStep, time.Now()); ok {
+		result := db.Model(&UserModel{}).
+			Where("id = ? AND totp_last_step < ?", u.ID, step).
+			UpdateColumn("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			u.TOTPLastStep = step
+			return nil
+		}
+		return ErrTwoFactorCode
+	}
+	result := db.Model(&RecoveryCodeModel{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", u.ID, hashToken(normalizeRecoveryCode(code))).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorCode
+	}
+	return nil
+}
+
+// Start the second step of the login of the user, returning the token of the challenge.
+func IssueLoginChallenge(ctx context.Context, userID uint) (string, error) {
+	token := randomToken()
+	model := LoginChallengeModel{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(LoginChallengeLifetime),
+	}
+	return token, common.GetDBContext(ctx).Create(&model).Error
+}
+
+func findLoginChallenge(ctx context.Context, token string) (LoginChallengeModel, error) {
+	var model LoginChallengeModel
+	err := common.GetDBContext(ctx).Where(&LoginChallengeModel{TokenHash: hashToken(token)}).First(&model).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return model, ErrChallengeInvalid
+	}
+	if err != nil {
+		return model, err
+	}
+	if model.UsedAt != nil || model.Attempts >= LoginChallengeAttempts || time.Now().After(model.ExpiresAt) {
+		return model, ErrChallengeInvalid
+	}
+	return model, nil
+}
+
+// Count a code against the challenge before checking it, ErrChallengeInvalid when it has
+// no attempt left. Conditional on attempts so parallel guesses can't go past LoginChallengeAttempts.
+func (m *LoginChallengeModel) countAttempt(ctx context.Context) error {
+	result := common.GetDBContext(ctx).Model(&LoginChallengeModel{}).
+		Where("id = ? AND attempts < ? AND used_at IS NULL AND expires_at > ?", m.ID, LoginChallengeAttempts, time.Now()).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrChallengeInvalid
+	}
+	m.Attempts++
+	return nil
+}
+
+// Close the challenge after the right code, ErrChallengeInvalid when a concurrent request
+// closed it first. Conditional on used_at, a challenge finishes one login.
+func (m *LoginChallengeModel) finish(ctx context.Context) error {
+	now := time.Now()
+	result := common.GetDBContext(ctx).Model(&LoginChallengeModel{}).
+		Where("id = ? AND used_at IS NULL", m.ID).
+		UpdateColumn("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrChallengeInvalid
+	}
+	m.UsedAt = &now
+	return nil
+}
@@ -1,18 +1,25 @@
 package users
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"testing"
 
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
+	"realworld-backend/mailer"
+	"realworld-backend/oidc"
+	"realworld-backend/oidc/oidctest"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	_ "regexp"
+	"regexp"
+	"time"
 )
 
 var image_url = "https://golang.org/doc/gopher/frontpage.png"
@@ -76,19 +83,19 @@ func TestUserModel(t *testing.T) {
 	a := users[0]
 	b := users[1]
 	c := users[2]
-	asserts.Equal(0, len(a.GetFollowings()), "GetFollowings should be right before following")
-	asserts.Equal(false, a.isFollowing(b), "isFollowing relationship should be right at init")
-	a.following(b)
-	asserts.Equal(1, len(a.GetFollowings()), "GetFollowings should be right after a following b")
-	asserts.Equal(true, a.isFollowing(b), "isFollowing should be right after a following b")
-	a.following(c)
-	asserts.Equal(2, len(a.GetFollowings()), "GetFollowings be right after a following c")
-	asserts.EqualValues(b, a.GetFollowings()[0], "GetFollowings should be right")
-	asserts.EqualValues(c, a.GetFollowings()[1], "GetFollowings should be right")
-	a.unFollowing(b)
-	asserts.Equal(1, len(a.GetFollowings()), "GetFollowings should be right after a unFollowing b")
-	asserts.EqualValues(c, a.GetFollowings()[0], "GetFollowings should be right after a unFollowing b")
-	asserts.Equal(false, a.isFollowing(b), "isFollowing should be right after a unFollowing b")
+	asserts.Equal(0, len(a.GetFollowings(context.Background())), "GetFollowings should be right before following")
+	asserts.Equal(false, a.isFollowing(context.Background(), b), "isFollowing relationship should be right at init")
+	a.following(context.Background(), b)
+	asserts.Equal(1, len(a.GetFollowings(context.Background())), "GetFollowings should be right after a following b")
+	asserts.Equal(true, a.isFollowing(context.Background(), b), "isFollowing should be right after a following b")
+	a.following(context.Background(), c)
+	asserts.Equal(2, len(a.GetFollowings(context.Background())), "GetFollowings be right after a following c")
+	asserts.EqualValues(b, a.GetFollowings(context.Background())[0], "GetFollowings should be right")
+	asserts.EqualValues(c, a.GetFollowings(context.Background())[1], "GetFollowings should be right")
+	a.unFollowing(context.Background(), b)
+	asserts.Equal(1, len(a.GetFollowings(context.Background())), "GetFollowings should be right after a unFollowing b")
+	asserts.EqualValues(c, a.GetFollowings(context.Background())[0], "GetFollowings should be right after a unFollowing b")
+	asserts.Equal(false, a.isFollowing(context.Background(), b), "isFollowing should be right after a unFollowing b")
 }
 
 //Reset test DB and create new one with mock data
@@ -103,6 +110,37 @@ func HeaderTokenMock(req *http.Request, u uint) {
 	req.Header.Set("Authorization", fmt.Sprintf("Token %v", common.GenToken(u)))
 }
 
+// The users API as mounted by SetupRouter
+func newRouter() *gin.Engine {
+	r := gin.New()
+	UsersRegister(r.Group("/users"))
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	return r
+}
+
+// A JSON request to r with the cookies, authenticated as the user id unless it's 0
+func request(r *gin.Engine, method, url, body string, id uint, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if id != 0 {
+		HeaderTokenMock(req, id)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// The JSON object of the response
+func decode(w *httptest.ResponseRecorder) map[string]interface{} {
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
 //You could write the init logic like reset database code here
 var unauthRequestTests = []struct {
 	init           func(*http.Request)
@@ -125,7 +163,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "jakejxke"}}`,
 		http.StatusCreated,
-		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":null,"token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":null,"token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"valid data and should return StatusCreated",
 	},
 	{
@@ -174,7 +212,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "password123"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"right info login should return user",
 	},
 	{
@@ -245,7 +283,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"request should return current user with token",
 	},
 
@@ -295,7 +333,7 @@ var unauthRequestTests = []struct {
 		"PUT",
 		`{"user":{"username":"user123","password": "password126","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"current user profile should be changed",
 	},
 	{
@@ -315,7 +353,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user123@linkedin.com","password": "password126"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"user should login using new password after changed",
 	},
 	{
@@ -479,6 +517,671 @@ func TestWithoutAuth(t *testing.T) {
 	}
 }
 
+func TestRefreshTokenRotation(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	r := newRouter()
+	post := func(url, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := request(r, "POST", url, body, 0)
+		return w, decode(w)
+	}
+	login := func() string {
+		w, _ := post("/users/login", `{"user":{"email": "user1@linkedin.com","password": "password123"}}`)
+		asserts.Equal(http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		if !asserts.Len(cookies, 1) {
+			return ""
+		}
+		asserts.Equal(RefreshTokenCookie, cookies[0].Name)
+		asserts.Equal("/api/users", cookies[0].Path)
+		asserts.True(cookies[0].HttpOnly)
+		return cookies[0].Value
+	}
+	refresh := func(token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return post("/users/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, token))
+	}
+
+	first := login()
+	w, response := refresh(first)
+	asserts.Equal(http.StatusOK, w.Code)
+	second, _ := response["refreshToken"].(string)
+	asserts.NotEqual(first, second, "The refresh token should rotate")
+	asserts.Equal("user1", response["user"].(map[string]interface{})["username"])
+
+	var stored RefreshTokenModel
+	test_db.First(&stored)
+	asserts.Equal(hashToken(first), stored.TokenHash, "Only the hash should be stored")
+	asserts.NotNil(stored.UsedAt)
+
+	w, _ = refresh(first)
+	asserts.Equal(http.StatusUnauthorized, w.Code, "A rotated token shouldn't be accepted again")
+	w, _ = refresh(second)
+	asserts.Equal(http.StatusUnauthorized, w.Code, "The reuse should revoke the whole family")
+
+	other := login()
+	third := login()
+	w, _ = post("/users/logout", fmt.Sprintf(`{"refreshToken": "%s"}`, third))
+	asserts.Equal(http.StatusNoContent, w.Code)
+	w, _ = refresh(third)
+	asserts.Equal(http.StatusUnauthorized, w.Code, "Logout should revoke the session")
+	w, _ = refresh(other)
+	asserts.Equal(http.StatusOK, w.Code, "Logout shouldn't touch the other sessions")
+
+	w, _ = post("/users/logout", fmt.Sprintf(`{"refreshToken": "%s", "all": true}`, login()))
+	asserts.Equal(http.StatusNoContent, w.Code)
+	var active int
+	test_db.Model(&RefreshTokenModel{}).Where("revoked_at IS NULL").Count(&active)
+	asserts.Equal(0, active, "Logout with all should revoke every session")
+
+	w, _ = refresh("not-a-token")
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+
+	// A browser sends the cookie and gets the next token as a cookie only
+	req, _ := http.NewRequest("POST", "/users/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: login()})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.NotContains(w.Body.String(), "refreshToken")
+	asserts.Len(w.Result().Cookies(), 1)
+}
+
+func TestLoginLockout(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	defer func(policy LockoutPolicy) { Lockout = policy }(Lockout)
+	Lockout = LockoutPolicy{MaxFailures: 3, LockDuration: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Minute, IPMaxFailures: 100, IPWindow: time.Hour}
+
+	r := gin.New()
+	// As SetupRouter without server.trusted_proxies
+	r.SetTrustedProxies(nil)
+	UsersRegister(r.Group("/users"))
+	forged := 0
+	login := func(password string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"user":{"email": "user1@linkedin.com","password": "%s"}}`, password)
+		req := httptest.NewRequest("POST", "/users/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "lockout-test")
+		// Another client IP at every try shouldn't get around the IP limit
+		forged++
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", forged))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	// Pretend the progressive delay is over
+	waitDelay := func() {
+		test_db.Model(&UserModel{}).Where("id = ?", 1).UpdateColumn("last_failed_login_at", time.Now().Add(-2*time.Minute))
+	}
+
+	asserts.Equal(http.StatusForbidden, login("wrong-password").Code)
+	w := login("password123")
+	asserts.Equal(http.StatusTooManyRequests, w.Code, "The right password should wait for the delay after a failure")
+	asserts.Equal("60", w.Header().Get("Retry-After"))
+
+	waitDelay()
+	asserts.Equal(http.StatusForbidden, login("wrong-password").Code)
+	waitDelay()
+	asserts.Equal(http.StatusForbidden, login("wrong-password").Code)
+	w = login("password123")
+	asserts.Equal(http.StatusForbidden, w.Code, "The third failure should lock the account")
+	asserts.Contains(w.Body.String(), "locked")
+	asserts.Equal("3600", w.Header().Get("Retry-After"))
+
+	userModel, _ := FindOneUser(context.Background(), &UserModel{ID: 1})
+	asserts.NotNil(userModel.LockedUntil)
+	asserts.NoError(userModel.Unlock(context.Background()))
+	asserts.Equal(http.StatusOK, login("password123").Code, "Unlock should lift the lock")
+
+	var attempts []LoginAttemptModel
+	test_db.Order("id").Find(&attempts)
+	var reasons []string
+	for _, attempt := range attempts {
+		reasons = append(reasons, attempt.Reason)
+	}
+	asserts.Equal([]string{AttemptBadPassword, AttemptThrottled, AttemptBadPassword, AttemptBadPassword, AttemptLocked, ""}, reasons)
+	asserts.True(attempts[5].Success)
+	asserts.Equal("lockout-test", attempts[5].UserAgent)
+	asserts.Equal(uint(1), *attempts[5].UserID)
+	for _, attempt := range attempts {
+		asserts.Equal("192.0.2.1", attempt.IP, "The IP of the peer should be recorded, not the forged X-Forwarded-For")
+	}
+
+	Lockout.IPMaxFailures = 5
+	w = login("password123")
+	asserts.Equal(http.StatusTooManyRequests, w.Code, "An IP with too many failures should be blocked")
+	asserts.Contains(w.Body.String(), "Too many failed logins")
+}
+
+func TestEmailVerification(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	outbox := t.TempDir()
+	Mailer = &mailer.FileMailer{Dir: outbox, From: "noreply@realworld.local"}
+	RequireVerifiedEmail = true
+	defer func() { Mailer, RequireVerifiedEmail = nil, false }()
+	r := newRouter()
+	r.POST("/articles", VerifiedEmailRequired(), func(c *gin.Context) { c.Status(http.StatusCreated) })
+	var messages []mailer.Message
+	waitMail := func(expected int) string {
+		asserts.Eventually(func() bool {
+			messages, _ = mailer.ReadOutbox(outbox)
+			return len(messages) == expected
+		}, 5*time.Second, 10*time.Millisecond)
+		if len(messages) < expected {
+			return ""
+		}
+		match := regexp.MustCompile(`verify-email\?token=([\w-]+)`).FindStringSubmatch(messages[expected-1].Body)
+		if !asserts.Len(match, 2) {
+			return ""
+		}
+		return match[1]
+	}
+	verify := func(token string) int {
+		return request(r, "POST", "/users/verify", fmt.Sprintf(`{"user":{"token": "%s"}}`, token), 0).Code
+	}
+
+	asserts.Equal(http.StatusCreated, request(r, "POST", "/users/", `{"user":{"username": "verifier","email": "verifier@linkedin.com","password": "password123"}}`, 0).Code)
+	verifier, _ := FindOneUser(context.Background(), &UserModel{Username: "verifier"})
+	first := waitMail(1)
+	asserts.Equal("verifier@linkedin.com", messages[0].To)
+	asserts.Equal(http.StatusForbidden, request(r, "POST", "/articles", `{}`, verifier.ID).Code, "An unverified user shouldn't write")
+
+	asserts.Equal(http.StatusAccepted, request(r, "POST", "/users/verify/resend", `{"user":{"email": "verifier@linkedin.com"}}`, 0).Code)
+	second := waitMail(2)
+	asserts.Equal(http.StatusUnprocessableEntity, verify(first), "A resend should invalidate the previous link")
+	asserts.Equal(http.StatusNoContent, verify(second))
+	asserts.Equal(http.StatusUnprocessableEntity, verify(second), "A link should only be used once")
+	asserts.Equal(http.StatusCreated, request(r, "POST", "/articles", `{}`, verifier.ID).Code)
+
+	asserts.Equal(http.StatusAccepted, request(r, "POST", "/users/verify/resend", `{"user":{"email": "verifier@linkedin.com"}}`, 0).Code)
+	asserts.Equal(http.StatusAccepted, request(r, "POST", "/users/verify/resend", `{"user":{"email": "nobody@linkedin.com"}}`, 0).Code)
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "POST", "/users/verify/resend", `{"user":{"email": "verifier"}}`, 0).Code)
+
+	// A new email has to be verified again, the link sent before the change can't do it
+	asserts.Equal(http.StatusAccepted, request(r, "POST", "/users/verify/resend", `{"user":{"email": "verifier@linkedin.com"}}`, 0).Code)
+	asserts.Equal(http.StatusOK, request(r, "PUT", "/user/", `{"user":{"email": "changed@linkedin.com"}}`, verifier.ID).Code)
+	asserts.Equal(http.StatusForbidden, request(r, "POST", "/articles", `{}`, verifier.ID).Code)
+	third := waitMail(3)
+	asserts.Equal("changed@linkedin.com", messages[2].To, "Verified or unknown emails shouldn't get a resend")
+	asserts.Equal(http.StatusNoContent, verify(third))
+	asserts.Equal(http.StatusCreated, request(r, "POST", "/articles", `{}`, verifier.ID).Code)
+}
+
+func TestTOTPCode(t *testing.T) {
+	asserts := assert.New(t)
+	// RFC 6238 appendix B, SHA-1, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	asserts.Equal("287082", totpCode(secret, 59/totpPeriod))
+	asserts.Equal("081804", totpCode(secret, 1111111109/totpPeriod))
+
+	encoded := base32NoPadding.EncodeToString(secret)
+	now := time.Unix(1111111109, 0)
+	step, ok := matchTOTP(encoded, "081804", 0, now)
+	asserts.True(ok)
+	_, ok = matchTOTP(encoded, "081804", step, now)
+	asserts.False(ok, "A code shouldn't be accepted twice")
+	_, ok = matchTOTP(encoded, "081804", 0, now.Add(2*time.Minute))
+	asserts.False(ok, "An old code shouldn't be accepted")
+	asserts.Equal("abcd1234efgh", normalizeRecoveryCode(" ABCD-1234-efgh "))
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	defer func(policy LockoutPolicy) { Lockout = policy }(Lockout)
+	Lockout = LockoutPolicy{MaxFailures: 3, LockDuration: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Minute, IPMaxFailures: 100, IPWindow: time.Hour}
+	r := newRouter()
+	login := func() string {
+		w := request(r, "POST", "/users/login", `{"user":{"email": "twofactor@linkedin.com","password": "password123"}}`, 0)
+		asserts.Equal(http.StatusOK, w.Code)
+		response := decode(w)
+		asserts.NotContains(response, "user", "The password alone shouldn't log in")
+		challenge, _ := response["twoFactor"].(map[string]interface{})
+		token, _ := challenge["challengeToken"].(string)
+		return token
+	}
+	secondStep := func(token, code string) int {
+		return request(r, "POST", "/users/login/2fa", fmt.Sprintf(`{"user":{"challengeToken": "%s","code": "%s"}}`, token, code), 0).Code
+	}
+	codeAt := func(secret string, offset int64) string {
+		key, _ := base32NoPadding.DecodeString(secret)
+		return totpCode(key, time.Now().Unix()/totpPeriod+offset)
+	}
+
+	asserts.Equal(http.StatusCreated, request(r, "POST", "/users/", `{"user":{"username": "twofactor","email": "twofactor@linkedin.com","password": "password123"}}`, 0).Code)
+	user, _ := FindOneUser(context.Background(), &UserModel{Username: "twofactor"})
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "POST", "/user/2fa/confirm", `{"twoFactor":{"code": "123456"}}`, user.ID).Code, "Confirming should need an enrollment")
+
+	w := request(r, "POST", "/user/2fa", ``, user.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	enrollment, _ := decode(w)["twoFactor"].(map[string]interface{})
+	secret, _ := enrollment["secret"].(string)
+	asserts.Contains(enrollment["uri"], "otpauth://totp/RealWorld:twofactor@linkedin.com?")
+	asserts.Equal(false, enrollment["enabled"])
+
+	w = request(r, "POST", "/user/2fa/confirm", fmt.Sprintf(`{"twoFactor":{"code": "%s"}}`, codeAt(secret, 0)), user.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	confirmed, _ := decode(w)["twoFactor"].(map[string]interface{})
+	recoveryCodes, _ := confirmed["recoveryCodes"].([]interface{})
+	if !asserts.Len(recoveryCodes, recoveryCodeCount) {
+		return
+	}
+	asserts.Equal(http.StatusConflict, request(r, "POST", "/user/2fa", ``, user.ID).Code)
+
+	challenge := login()
+	asserts.NotEmpty(challenge)
+	asserts.Equal(http.StatusOK, secondStep(challenge, codeAt(secret, 1)))
+	asserts.Equal(http.StatusUnauthorized, secondStep(challenge, codeAt(secret, 1)), "A challenge should only be used once")
+
+	// Two requests reading the challenge before either writes it
+	challenge = login()
+	first, err := findLoginChallenge(context.Background(), challenge)
+	asserts.NoError(err)
+	second := first
+	asserts.NoError(first.finish(context.Background()))
+	asserts.ErrorIs(second.finish(context.Background()), ErrChallengeInvalid, "A challenge should finish one login")
+	challenge = login()
+	first, _ = findLoginChallenge(context.Background(), challenge)
+	test_db.Model(&first).UpdateColumn("attempts", LoginChallengeAttempts-1)
+	second = first
+	asserts.NoError(first.countAttempt(context.Background()))
+	asserts.ErrorIs(second.countAttempt(context.Background()), ErrChallengeInvalid, "Parallel guesses shouldn't go past the attempts")
+	asserts.Equal(http.StatusUnauthorized, secondStep(challenge, codeAt(secret, 0)))
+
+	challenge = login()
+	asserts.Equal(http.StatusOK, secondStep(challenge, recoveryCodes[0].(string)))
+	w = request(r, "GET", "/user/2fa", ``, user.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(map[string]interface{}{"enabled": true, "recoveryCodesLeft": float64(recoveryCodeCount - 1)}, decode(w)["twoFactor"])
+
+	challenge = login()
+	asserts.Equal(http.StatusForbidden, secondStep(challenge, recoveryCodes[0].(string)), "A recovery code should only be used once")
+	asserts.Equal(http.StatusUnauthorized, secondStep("not-a-challenge", recoveryCodes[1].(string)))
+
+	disable := func(password, code string) int {
+		return request(r, "DELETE", "/user/2fa", fmt.Sprintf(`{"twoFactor":{"password": "%s","code": "%s"}}`, password, code), user.ID).Code
+	}
+	// Pretend the progressive delay is over
+	waitDelay := func() {
+		test_db.Model(&UserModel{}).Where("username = ?", "twofactor").UpdateColumn("last_failed_login_at", time.Now().Add(-2*time.Minute))
+	}
+	waitDelay()
+	asserts.Equal(http.StatusUnprocessableEntity, request(r, "DELETE", "/user/2fa", fmt.Sprintf(`{"twoFactor":{"code": "%s"}}`, recoveryCodes[1]), user.ID).Code,
+		"Disabling 2FA should need the password")
+	asserts.Equal(http.StatusForbidden, disable("wrong-password", recoveryCodes[1].(string)))
+	asserts.Equal(http.StatusTooManyRequests, disable("password123", recoveryCodes[1].(string)), "A wrong password should wait for the delay as a login")
+	waitDelay()
+	asserts.Equal(http.StatusUnprocessableEntity, disable("password123", "000000"))
+	asserts.Equal(http.StatusForbidden, disable("password123", recoveryCodes[1].(string)), "The third failure, a wrong code, should lock the account")
+	user, _ = FindOneUser(context.Background(), &UserModel{ID: user.ID})
+	asserts.True(user.TwoFactorEnabled())
+	asserts.NoError(user.Unlock(context.Background()))
+
+	asserts.Equal(http.StatusNoContent, disable("password123", recoveryCodes[1].(string)))
+	user, _ = FindOneUser(context.Background(), &UserModel{ID: user.ID})
+	asserts.False(user.TwoFactorEnabled())
+	left, _ := user.RecoveryCodesLeft(context.Background())
+	asserts.Zero(left)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	idp := oidctest.NewServer(t, "realworld", "secret")
+	OIDCProviders = map[string]*oidc.Provider{"corp": oidc.NewProvider(idp.Config("corp", "http://localhost:4100/oidc/corp"), nil)}
+	defer func() { OIDCProviders, OIDCAllowSignup = map[string]*oidc.Provider{}, true }()
+	r := newRouter()
+	// The state of the last login started and its cookies, sent back like a browser would
+	var state string
+	var stateCookies []*http.Cookie
+	start := func(claims jwt.MapClaims) string {
+		w := request(r, "POST", "/users/oidc/corp", ``, 0)
+		asserts.Equal(http.StatusOK, w.Code)
+		started, _ := decode(w)["oidc"].(map[string]interface{})
+		authURL, _ := started["authorizationUrl"].(string)
+		code, returned, err := idp.Authorize(authURL, claims)
+		asserts.NoError(err)
+		asserts.Equal(started["state"], returned)
+		state, stateCookies = returned, w.Result().Cookies()
+		return code
+	}
+	finish := func(code string, cookies ...*http.Cookie) (int, map[string]interface{}) {
+		w := request(r, "POST", "/users/oidc/corp/callback", fmt.Sprintf(`{"oidc":{"code": "%s","state": "%s"}}`, code, state), 0, cookies...)
+		return w.Code, decode(w)
+	}
+	login := func(claims jwt.MapClaims) (int, map[string]interface{}) {
+		return finish(start(claims), stateCookies...)
+	}
+	username := func(response map[string]interface{}) string {
+		user, _ := response["user"].(map[string]interface{})
+		name, _ := user["username"].(string)
+		return name
+	}
+
+	jake := jwt.MapClaims{"sub": "jake-1", "email": "jake@corp.com", "email_verified": true, "preferred_username": "jake.corp"}
+	status, response := login(jake)
+	asserts.Equal(http.StatusOK, status)
+	asserts.Equal("jakecorp", username(response), "An unknown email should get an account")
+	created, err := FindOneUser(context.Background(), &UserModel{Email: "jake@corp.com"})
+	asserts.NoError(err)
+	asserts.NotNil(created.EmailVerifiedAt, "The provider verified the email")
+
+	status, response = login(jake)
+	asserts.Equal(http.StatusOK, status)
+	asserts.Equal("jakecorp", username(response))
+	status, _ = finish("any", stateCookies...)
+	asserts.Equal(http.StatusUnprocessableEntity, status, "A state should only finish one login")
+
+	// A login CSRF: the state of the attacker finished in the browser of the victim
+	code := start(jake)
+	if asserts.Len(stateCookies, 1) {
+		asserts.Equal(OIDCStateCookie, stateCookies[0].Name)
+		asserts.True(stateCookies[0].HttpOnly)
+		asserts.Equal(http.SameSiteLaxMode, stateCookies[0].SameSite)
+	}
+	status, _ = finish(code)
+	asserts.Equal(http.StatusUnprocessableEntity, status, "The state should need its cookie")
+	status, _ = finish(code, &http.Cookie{Name: OIDCStateCookie, Value: randomToken()})
+	asserts.Equal(http.StatusUnprocessableEntity, status, "The state should match its cookie")
+	status, _ = finish(code, stateCookies...)
+	asserts.Equal(http.StatusOK, status, "A refused state should still finish the login of its browser")
+
+	// Another person whose preferred username is taken
+	status, response = login(jwt.MapClaims{"sub": "jake-2", "email": "jake@other.com", "email_verified": true, "preferred_username": "jake.corp"})
+	asserts.Equal(http.StatusOK, status)
+	asserts.Regexp(`^jakecorp\d{4}$`, username(response))
+
+	// The identity of an existing account is linked by its verified email only
+	status, _ = login(jwt.MapClaims{"sub": "user1", "email": "User1@linkedin.com", "email_verified": false})
+	asserts.Equal(http.StatusForbidden, status)
+	status, response = login(jwt.MapClaims{"sub": "user1", "email": "User1@linkedin.com", "email_verified": true})
+	asserts.Equal(http.StatusOK, status)
+	asserts.Equal("user1", username(response))
+	w := request(r, "GET", "/user/identities", ``, 1)
+	asserts.Equal(http.StatusOK, w.Code)
+	identities, _ := decode(w)["identities"].([]interface{})
+	if asserts.Len(identities, 1) {
+		asserts.Equal("corp", identities[0].(map[string]interface{})["provider"])
+	}
+
+	OIDCAllowSignup = false
+	status, _ = login(jwt.MapClaims{"sub": "new", "email": "new@corp.com", "email_verified": true})
+	asserts.Equal(http.StatusForbidden, status)
+	status, _ = login(jake)
+	asserts.Equal(http.StatusOK, status, "Known identities should still log in")
+	asserts.Equal(http.StatusNotFound, request(r, "POST", "/users/oidc/unknown", ``, 0).Code)
+}
+
+func TestAccessTokens(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	ProfileRegister(r.Group("/profiles"))
+	r.POST("/articles", RequireScope(ScopeArticlesWrite), func(c *gin.Context) { c.Status(http.StatusCreated) })
+	request := func(method, url, body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	session := common.GenToken(1)
+	create := func(body string) (int, string) {
+		w := request("POST", "/user/tokens", body, session)
+		var response struct {
+			Token AccessTokenResponse `json:"token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Token.Token
+	}
+
+	code, _ := create(`{"token":{"name": "ci","scopes": ["articles:publish"]}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+	code, _ = create(`{"token":{"name": "ci","scopes": ["articles:write"],"expiresAt": "2020-01-01T00:00:00Z"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, code, "An expiry in the past should be refused")
+	code, ci := create(`{"token":{"name": "ci","scopes": ["articles:write","profile:read"]}}`)
+	asserts.Equal(http.StatusCreated, code)
+	asserts.Regexp(`^rwpat_[\w-]{43}$`, ci)
+
+	asserts.Equal(http.StatusCreated, request("POST", "/articles", `{}`, ci).Code)
+	w := request("GET", "/user/", ``, ci)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"token":"`+ci+`"`, "A token shouldn't be traded for a JWT")
+	asserts.Equal(http.StatusForbidden, request("PUT", "/user/", `{"user":{"bio": "ci"}}`, ci).Code)
+	w = request("POST", "/profiles/user2/follow", ``, ci)
+	asserts.Equal(http.StatusForbidden, w.Code)
+	asserts.Equal(`Bearer error="insufficient_scope", scope="profile:write"`, w.Header().Get("WWW-Authenticate"))
+	asserts.Equal(http.StatusForbidden, request("POST", "/user/tokens", `{"token":{"name": "more","scopes": ["profile:write"]}}`, ci).Code,
+		"A token shouldn't mint tokens")
+	asserts.Equal(http.StatusForbidden, request("GET", "/user/2fa", ``, ci).Code)
+
+	code, profile := create(`{"token":{"name": "profile","scopes": ["profile:write"],"expiresAt": "2099-01-01T00:00:00Z"}}`)
+	asserts.Equal(http.StatusCreated, code)
+	asserts.Equal(http.StatusOK, request("PUT", "/user/", `{"user":{"bio": "automated"}}`, profile).Code)
+	asserts.Equal(http.StatusForbidden, request("PUT", "/user/", `{"user":{"password": "stolen123"}}`, profile).Code)
+	asserts.Equal(http.StatusForbidden, request("PUT", "/user/", `{"user":{"email": "stolen@evil.com"}}`, profile).Code)
+
+	w = request("GET", "/user/tokens", ``, session)
+	var listed struct {
+		Tokens []AccessTokenResponse `json:"tokens"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &listed))
+	if asserts.Len(listed.Tokens, 2) {
+		asserts.Equal("profile", listed.Tokens[0].Name)
+		asserts.Empty(listed.Tokens[1].Token, "A token should only be shown at creation")
+		asserts.NotNil(listed.Tokens[1].LastUsedAt)
+		asserts.Equal(http.StatusNotFound, request("DELETE", fmt.Sprintf("/user/tokens/%d", listed.Tokens[1].ID), ``, common.GenToken(2)).Code)
+		asserts.Equal(http.StatusNoContent, request("DELETE", fmt.Sprintf("/user/tokens/%d", listed.Tokens[1].ID), ``, session).Code)
+	}
+	asserts.Equal(http.StatusUnauthorized, request("POST", "/articles", `{}`, ci).Code, "A revoked token should be refused")
+
+	past := time.Now().Add(-time.Minute)
+	expired, _, err := IssueAccessToken(context.Background(), 1, "old", []string{ScopeArticlesWrite}, &past)
+	asserts.NoError(err)
+	asserts.Equal(http.StatusUnauthorized, request("POST", "/articles", `{}`, expired).Code)
+}
+
+func TestHumanDuration(t *testing.T) {
+	asserts := assert.New(t)
+	asserts.Equal("1 hour", humanDuration(time.Hour))
+	asserts.Equal("90 minutes", humanDuration(90*time.Minute))
+	asserts.Equal("1m30s", humanDuration(90*time.Second))
+}
+
+func TestLockoutDelay(t *testing.T) {
+	asserts := assert.New(t)
+	policy := LockoutPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	asserts.Equal(time.Duration(0), policy.delay(0))
+	asserts.Equal(time.Second, policy.delay(1))
+	asserts.Equal(4*time.Second, policy.delay(3))
+	asserts.Equal(10*time.Second, policy.delay(20), "The delay should be capped")
+}
+
+func TestCookieSession(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	CookieSessions, QueryTokens = true, false
+	defer func() { CookieSessions, QueryTokens = false, true }()
+	r := newRouter()
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	req, _ := http.NewRequest("POST", "/users/login", bytes.NewBufferString(`{"user":{"email": "user1@linkedin.com","password": "password123"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := serve(req)
+	asserts.Equal(http.StatusOK, w.Code)
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	session, csrf := cookies[SessionCookie], cookies[CSRFCookie]
+	if !asserts.NotNil(session) || !asserts.NotNil(csrf) {
+		return
+	}
+	asserts.True(session.HttpOnly)
+	asserts.Equal(http.SameSiteLaxMode, session.SameSite)
+	asserts.False(csrf.HttpOnly, "The frontend should read the CSRF token")
+	asserts.Contains(w.Body.String(), session.Value, "The body should carry the same token")
+
+	req, _ = http.NewRequest("GET", "/user/", nil)
+	req.AddCookie(session)
+	asserts.Equal(http.StatusOK, serve(req).Code, "The session cookie should authenticate")
+
+	update := func(header string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/user/", bytes.NewBufferString(`{"user":{"bio": "cookies"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(session)
+		req.AddCookie(csrf)
+		if header != "" {
+			req.Header.Set(CSRFHeader, header)
+		}
+		return serve(req)
+	}
+	w = update("")
+	asserts.Equal(http.StatusForbidden, w.Code, "An unsafe request without CSRF token should be refused")
+	asserts.Contains(w.Body.String(), "csrf")
+	asserts.Equal(http.StatusForbidden, update("forged").Code)
+	asserts.Equal(http.StatusOK, update(csrf.Value).Code)
+
+	req, _ = http.NewRequest("GET", "/user/?access_token="+common.GenToken(1), nil)
+	asserts.Equal(http.StatusUnauthorized, serve(req).Code, "Query tokens should be refused when disabled")
+
+	req, _ = http.NewRequest("POST", "/users/logout", nil)
+	req.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: "gone"})
+	w = serve(req)
+	asserts.Equal(http.StatusNoContent, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		asserts.Equal("", cookie.Value, "Logout should clear %s", cookie.Name)
+	}
+	asserts.Len(w.Result().Cookies(), 3)
+}
+
+func TestPasswordReset(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	outbox := t.TempDir()
+	Mailer = &mailer.FileMailer{Dir: outbox, From: "noreply@realworld.local"}
+	defer func() { Mailer = nil }()
+	r := newRouter()
+	post := func(url, body string) int {
+		return request(r, "POST", url, body, 0).Code
+	}
+	// Access tokens issued a minute ago, with a lifetime longer than token_lifetime is now and
+	// without iat as before GenToken had it, and a session to revoke
+	before, _ := common.Keys.Sign(jwt.MapClaims{"id": 1, "iat": time.Now().Add(-time.Minute).Unix(), "exp": time.Now().Add(24 * time.Hour).Unix()})
+	legacy, _ := common.Keys.Sign(jwt.MapClaims{"id": 1, "exp": time.Now().Add(common.TokenLifetime - time.Minute).Unix()})
+	refreshToken, _ := IssueRefreshToken(context.Background(), 1)
+
+	// The mails are sent in the background, wait for each before the next request to keep their order
+	var messages []mailer.Message
+	forgot := func(email string, expected int) {
+		asserts.Equal(http.StatusAccepted, post("/users/password/forgot", fmt.Sprintf(`{"user":{"email": "%s"}}`, email)))
+		asserts.Eventually(func() bool {
+			messages, _ = mailer.ReadOutbox(outbox)
+			return len(messages) == expected
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	forgot("nobody@linkedin.com", 0)
+	forgot("user1@linkedin.com", 1)
+	forgot("user1@linkedin.com", 2)
+	if !asserts.Len(messages, 2, "Only the registered email should get the link, once per request") {
+		return
+	}
+	asserts.Equal("user1@linkedin.com", messages[0].To)
+	token := func(msg mailer.Message) string {
+		match := regexp.MustCompile(`reset-password\?token=([\w-]+)`).FindStringSubmatch(msg.Body)
+		if !asserts.Len(match, 2) {
+			return ""
+		}
+		return match[1]
+	}
+	reset := func(token, password string) int {
+		return post("/users/password/reset", fmt.Sprintf(`{"user":{"token": "%s", "password": "%s"}}`, token, password))
+	}
+	asserts.Equal(http.StatusUnprocessableEntity, reset(token(messages[0]), "newpassword123"), "A new request should invalidate the previous link")
+	asserts.Equal(http.StatusNoContent, reset(token(messages[1]), "newpassword123"))
+	asserts.Equal(http.StatusUnprocessableEntity, reset(token(messages[1]), "otherpassword123"), "A link should only be used once")
+
+	asserts.Equal(http.StatusOK, post("/users/login", `{"user":{"email": "user1@linkedin.com","password": "newpassword123"}}`))
+	asserts.Equal(http.StatusForbidden, post("/users/login", `{"user":{"email": "user1@linkedin.com","password": "password123"}}`))
+	_, _, err := RotateRefreshToken(context.Background(), refreshToken)
+	asserts.ErrorIs(err, ErrRefreshTokenInvalid, "The reset should revoke the sessions")
+
+	for _, token := range []string{before, legacy} {
+		req, _ := http.NewRequest("GET", "/user/", nil)
+		req.Header.Set("Authorization", "Token "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusUnauthorized, w.Code, "The access tokens issued before the reset should be refused")
+	}
+	req, _ := http.NewRequest("GET", "/user/", nil)
+	HeaderTokenMock(req, 1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+}
+
+func TestRolesAndBans(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	ctx := context.Background()
+	r := newRouter()
+	r.GET("/admin", RequireRole(RoleModerator), RequirePermission(PermissionViewStats), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	var user, moderator, admin UserModel
+	test_db.First(&user, 1)
+	test_db.First(&moderator, 2)
+	test_db.First(&admin, 3)
+	asserts.Equal(ErrUnknownRole, user.SetRole(ctx, "root"))
+	asserts.NoError(moderator.SetRole(ctx, RoleModerator))
+	asserts.NoError(admin.SetRole(ctx, RoleAdmin))
+	asserts.True(admin.HasRole(RoleModerator))
+	asserts.False(moderator.HasRole(RoleAdmin))
+	asserts.True(moderator.Can(PermissionBanUsers))
+	asserts.False(moderator.Can(PermissionManageRoles))
+	asserts.True(moderator.Outranks(user))
+	asserts.False(moderator.Outranks(moderator), "Nobody should outrank themselves")
+	asserts.False(moderator.Outranks(admin))
+
+	asserts.Equal(http.StatusForbidden, request(r, "GET", "/admin", ``, user.ID).Code)
+	asserts.Equal(http.StatusForbidden, request(r, "GET", "/admin", ``, moderator.ID).Code, "A moderator can't view the stats")
+	asserts.Equal(http.StatusOK, request(r, "GET", "/admin", ``, admin.ID).Code)
+
+	login := `{"user":{"email": "user1@linkedin.com","password": "password123"}}`
+	asserts.Equal(http.StatusOK, request(r, "POST", "/users/login", login, 0).Code)
+	asserts.NoError(user.Ban(ctx, "spam"))
+	w := request(r, "POST", "/users/login", login, 0)
+	asserts.Equal(http.StatusForbidden, w.Code)
+	asserts.Contains(w.Body.String(), ErrBanned.Error())
+	asserts.Equal(http.StatusForbidden, request(r, "GET", "/user/", ``, user.ID).Code, "The tokens of a banned user should be refused")
+
+	banned := true
+	found, count, err := SearchUsers(ctx, UserQuery{Banned: &banned, Limit: 10})
+	asserts.NoError(err)
+	if asserts.Equal(1, count) {
+		asserts.Equal("spam", found[0].BanReason)
+	}
+	_, count, _ = SearchUsers(ctx, UserQuery{Search: "USER", Limit: 10})
+	asserts.Equal(3, count)
+	_, count, _ = SearchUsers(ctx, UserQuery{Search: "user_", Limit: 10})
+	asserts.Equal(0, count, "The wildcards of the search should be escaped")
+	_, count, _ = SearchUsers(ctx, UserQuery{Role: RoleAdmin, Limit: 10})
+	asserts.Equal(1, count)
+
+	asserts.NoError(user.Unban(ctx))
+	asserts.Equal(http.StatusOK, request(r, "POST", "/users/login", login, 0).Code)
+	asserts.Equal(http.StatusOK, request(r, "GET", "/user/", ``, user.ID).Code)
+}
+
 //This is a hack way to add test database for each case, as whole test will just share one database.
 //You can read TestWithoutAuth's comment to know how to not share database each case.
 func TestMain(m *testing.M) {
@@ -3,6 +3,8 @@ package users
 import (
 	"realworld-backend/common"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"time"
 )
 
 // *ModelValidator containing two parts:
@@ -61,6 +63,24 @@ func NewUserModelValidatorFillWith(userModel UserModel) UserModelValidator {
 	return userModelValidator
 }
 
+// Check plain values with the same rules as the registration API, it's used by the command line tools
+// which have no request to bind.
+//	userModel, err := NewValidatedUserModel("wangzitian0", "wzt@gg.cn", "jakejxke")
+func NewValidatedUserModel(username, email, password string) (UserModel, error) {
+	userModelValidator := NewUserModelValidator()
+	userModelValidator.User.Username = username
+	userModelValidator.User.Email = email
+	userModelValidator.User.Password = password
+	if err := binding.Validator.ValidateStruct(&userModelValidator); err != nil {
+		return UserModel{}, err
+	}
+	userModel := UserModel{Username: username, Email: email}
+	if err := userModel.setPassword(password); err != nil {
+		return UserModel{}, err
+	}
+	return userModel, nil
+}
+
 type LoginValidator struct {
 	User struct {
 		Email    string `form:"email" json:"email" binding:"required,email"`
@@ -84,3 +104,163 @@ func NewLoginValidator() LoginValidator {
 	loginValidator := LoginValidator{}
 	return loginValidator
 }
+
+// The body of POST /api/users/refresh and /api/users/logout, `all` logs out every session of the user.
+// The token falls back to the refresh_token cookie, the body is optional then.
+type RefreshTokenValidator struct {
+	RefreshToken string `form:"refreshToken" json:"refreshToken" binding:"max=255"`
+	All          bool   `form:"all" json:"all"`
+	fromBody     bool   `json:"-"`
+}
+
+func (self *RefreshTokenValidator) Bind(c *gin.Context) error {
+	if c.Request.ContentLength != 0 {
+		if err := common.Bind(c, self); err != nil {
+			return err
+		}
+	}
+	self.fromBody = self.RefreshToken != ""
+	if !self.fromBody {
+		self.RefreshToken, _ = c.Cookie(RefreshTokenCookie)
+	}
+	return nil
+}
+
+func NewRefreshTokenValidator() RefreshTokenValidator {
+	return RefreshTokenValidator{}
+}
+
+type PasswordForgotValidator struct {
+	User struct {
+		Email string `form:"email" json:"email" binding:"required,email"`
+	} `json:"user"`
+}
+
+func (self *PasswordForgotValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewPasswordForgotValidator() PasswordForgotValidator {
+	return PasswordForgotValidator{}
+}
+
+type PasswordResetValidator struct {
+	User struct {
+		Token    string `form:"token" json:"token" binding:"required,max=255"`
+		Password string `form:"password" json:"password" binding:"required,min=8,max=255"`
+	} `json:"user"`
+}
+
+func (self *PasswordResetValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewPasswordResetValidator() PasswordResetValidator {
+	return PasswordResetValidator{}
+}
+
+type EmailVerifyValidator struct {
+	User struct {
+		Token string `form:"token" json:"token" binding:"required,max=255"`
+	} `json:"user"`
+}
+
+func (self *EmailVerifyValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewEmailVerifyValidator() EmailVerifyValidator {
+	return EmailVerifyValidator{}
+}
+
+type EmailVerifyResendValidator struct {
+	User struct {
+		Email string `form:"email" json:"email" binding:"required,email"`
+	} `json:"user"`
+}
+
+func (self *EmailVerifyResendValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewEmailVerifyResendValidator() EmailVerifyResendValidator {
+	return EmailVerifyResendValidator{}
+}
+
+type LoginChallengeValidator struct {
+	User struct {
+		ChallengeToken string `form:"challengeToken" json:"challengeToken" binding:"required,max=255"`
+		// A code of the authenticator app or a recovery code
+		Code string `form:"code" json:"code" binding:"required,max=32"`
+	} `json:"user"`
+}
+
+func (self *LoginChallengeValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewLoginChallengeValidator() LoginChallengeValidator {
+	return LoginChallengeValidator{}
+}
+
+type TwoFactorCodeValidator struct {
+	TwoFactor struct {
+		Code string `form:"code" json:"code" binding:"required,max=32"`
+	} `json:"twoFactor"`
+}
+
+func (self *TwoFactorCodeValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewTwoFactorCodeValidator() TwoFactorCodeValidator {
+	return TwoFactorCodeValidator{}
+}
+
+// The password and a current code or a recovery code, both needed to turn 2FA off.
+type TwoFactorDisableValidator struct {
+	TwoFactor struct {
+		Password string `form:"password" json:"password" binding:"required,max=255"`
+		Code     string `form:"code" json:"code" binding:"required,max=32"`
+	} `json:"twoFactor"`
+}
+
+func (self *TwoFactorDisableValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewTwoFactorDisableValidator() TwoFactorDisableValidator {
+	return TwoFactorDisableValidator{}
+}
+
+type OIDCCallbackValidator struct {
+	OIDC struct {
+		Code  string `form:"code" json:"code" binding:"required,max=2048"`
+		State string `form:"state" json:"state" binding:"required,max=255"`
+	} `json:"oidc"`
+}
+
+func (self *OIDCCallbackValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewOIDCCallbackValidator() OIDCCallbackValidator {
+	return OIDCCallbackValidator{}
+}
+
+type AccessTokenValidator struct {
+	Token struct {
+		Name   string   `form:"name" json:"name" binding:"required,max=100"`
+		Scopes []string `form:"scopes" json:"scopes" binding:"required,min=1,max=6,unique,dive,oneof=articles:read articles:write comments:read comments:write profile:read profile:write"`
+		// Never expires when empty
+		ExpiresAt *time.Time `form:"expiresAt" json:"expiresAt" binding:"omitempty,gt"`
+	} `json:"token"`
+}
+
+func (self *AccessTokenValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewAccessTokenValidator() AccessTokenValidator {
+	return AccessTokenValidator{}
+}
@@ -0,0 +1,153 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+	"realworld-backend/mailer"
+)
+
+// A single use token proving the user reads the mail of Email, hashed like the reset tokens.
+// Changing the email again makes it useless: it only verifies the address it was sent to.
+type EmailVerificationModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"column:user_id;not null"`
+	Email     string `gorm:"column:email;size:255;not null"`
+	TokenHash string `gorm:"column:token_hash;size:64;not null;unique_index"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func (EmailVerificationModel) TableName() string {
+	return "email_verifications"
+}
+
+// How long a verification link can be used and whether writing articles and comments
+// needs a verified email, overwritten from the config at boot.
+var (
+	EmailVerificationLifetime = 48 * time.Hour
+	RequireVerifiedEmail      = false
+)
+
+var (
+	ErrEmailVerificationInvalid = errors.New("email verification token is invalid, used or expired")
+	ErrEmailNotVerified         = errors.New("verify your email address first")
+)
+
+// Issue a verification token for the current email of the user, the previous unused ones stop working.
+//
+//	token, err := IssueEmailVerification(ctx, userModel)
+func IssueEmailVerification(ctx context.Context, userModel UserModel) (string, error) {
+	db := common.GetDBContext(ctx)
+	now := time.Now()
+	err := db.Model(&EmailVerificationModel{}).
+		Where("user_id = ? AND used_at IS NULL", userModel.ID).
+		UpdateColumn("used_at", now).Error
+	if err != nil {
+		return "", err
+	}
+	token := randomToken()
+	model := EmailVerificationModel{
+		UserID:    userModel.ID,
+		Email:     userModel.Email,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(EmailVerificationLifetime),
+	}
+	return token, db.Create(&model).Error
+}
+
+// Mark the email of the token's user verified and consume the token.
+//
+//	userModel, err := VerifyEmail(ctx, token)
+func VerifyEmail(ctx context.Context, token string) (UserModel, error) {
+	var userModel UserModel
+	var model EmailVerificationModel
+	db := common.GetDBContext(ctx)
+	err := db.Where(&EmailVerificationModel{TokenHash: hashToken(token)}).First(&model).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return userModel, ErrEmailVerificationInvalid
+	}
+	if err != nil {
+		return userModel, err
+	}
+	now := time.Now()
+	if model.UsedAt != nil || now.After(model.ExpiresAt) {
+		return userModel, ErrEmailVerificationInvalid
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Conditional on used_at, like the other single use tokens
+		result := tx.Model(&EmailVerificationModel{}).
+			Where("id = ? AND used_at IS NULL", model.ID).
+			UpdateColumn("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrEmailVerificationInvalid
+		}
+		if err := tx.First(&userModel, model.UserID).Error; err != nil {
+			return err
+		}
+		if userModel.Email != model.Email {
+			return ErrEmailVerificationInvalid
+		}
+		return tx.Model(&userModel).UpdateColumn("email_verified_at", now).Error
+	})
+	if err != nil {
+		return userModel, err
+	}
+	userModel.EmailVerifiedAt = &now
+	return userModel, nil
+}
+
+// Forget the verification of the user, after a change of email.
+func (u *UserModel) unverifyEmail(ctx context.Context) error {
+	err := common.GetDBContext(ctx).Model(u).UpdateColumn("email_verified_at", nil).Error
+	if err == nil {
+		u.EmailVerifiedAt = nil
+	}
+	return err
+}
+
+// Issue a token and mail its link to the user, at registration, change of email or on request.
+// A failure is logged only: the account was saved, the user can ask for another link.
+func startEmailVerification(c *gin.Context, userModel UserModel) {
+	token, err := IssueEmailVerification(c.Request.Context(), userModel)
+	if err != nil {
+		common.LogDBError(c, err)
+		return
+	}
+	link := fmt.Sprintf("%s/verify-email?token=%s", AppURL, url.QueryEscape(token))
+	sendMail("email_verification", mailer.Message{
+		To:      userModel.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nTo confirm %s is your email address, open this link within %s:\n\n%s\n\n"+
+			"If you didn't sign up, ignore this email.\n",
+			userModel.Username, userModel.Email, humanDuration(EmailVerificationLifetime), link),
+	})
+}
+
+// Refuse the request of a user whose email isn't verified, when RequireVerifiedEmail is on.
+// Goes after AuthMiddleware(true).
+//
+//	router.POST("/", users.VerifiedEmailRequired(), ArticleCreate)
+func VerifiedEmailRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !RequireVerifiedEmail {
+			return
+		}
+		if c.MustGet("my_user_model").(UserModel).EmailVerifiedAt == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("email", ErrEmailNotVerified))
+		}
+	}
+}